@@ -0,0 +1,36 @@
+package main
+
+// BiDimensional limits requests by their count and their total size in bytes at the same time, such as "10
+// requests and 5MB per second" for an upload API, admitting a request only when both limits permit it
+type BiDimensional struct {
+	reqLimiter  RateLimiter
+	byteLimiter RateLimiter
+}
+
+// NewBiDimensional creates a BiDimensional that takes 1 token from reqLimiter and a token per byte from byteLimiter
+// for every request
+func NewBiDimensional(reqLimiter, byteLimiter RateLimiter) *BiDimensional {
+	return &BiDimensional{reqLimiter: reqLimiter, byteLimiter: byteLimiter}
+}
+
+// Allow reports whether a request of bytes is admitted by both limiters. A request the byte limiter denies after
+// the request limiter admitted it is refunded to the request limiter, if that supports refunds like the token
+// and leaky buckets do, so a denied request doesn't use up the request limit
+func (b *BiDimensional) Allow(bytes int) bool {
+	if bytes <= 0 || !b.reqLimiter.Allow(1) {
+		return false
+	}
+	if b.byteLimiter.Allow(bytes) {
+		return true
+	}
+	if r, ok := b.reqLimiter.(interface{ Refund(tokens int) }); ok {
+		r.Refund(1)
+	}
+	return false
+}
+
+// Stop stops both limiters
+func (b *BiDimensional) Stop() {
+	b.reqLimiter.Stop()
+	b.byteLimiter.Stop()
+}
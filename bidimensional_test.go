@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestBiDimensional(t *testing.T) {
+	tests := []struct {
+		name      string
+		reqCap    int
+		byteCap   int
+		bytes     []int
+		want      []bool
+		wantReqs  int
+		wantBytes int
+	}{
+		{"Request small uploads, expect the request limit binding", 2, 100, []int{10, 10, 10}, []bool{true, true, false}, 0, 80},
+		{"Request large uploads, expect the byte limit binding", 5, 100, []int{60, 60}, []bool{true, false}, 4, 40},
+		{"Request an upload the bytes deny, expect the request refunded", 1, 100, []int{200, 50}, []bool{false, true}, 0, 50},
+		{"Request 0 bytes, expect denied without taking a request", 1, 100, []int{0}, []bool{false}, 1, 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			reqLimiter := NewTokenBucket(tt.reqCap, 1, tt.reqCap, WithClock(clock)).(*TokenBucket)
+			byteLimiter := NewTokenBucket(tt.byteCap, 1, tt.byteCap, WithClock(clock)).(*TokenBucket)
+			b := NewBiDimensional(reqLimiter, byteLimiter)
+			defer b.Stop()
+
+			for i, bytes := range tt.bytes {
+				if got := b.Allow(bytes); got != tt.want[i] {
+					t.Errorf("Allow(%d) #%d = %v, want %v", bytes, i, got, tt.want[i])
+				}
+			}
+			if got := reqLimiter.Tokens(); got != tt.wantReqs {
+				t.Errorf("request limiter Tokens() = %d, want %d", got, tt.wantReqs)
+			}
+			if got := byteLimiter.Tokens(); got != tt.wantBytes {
+				t.Errorf("byte limiter Tokens() = %d, want %d", got, tt.wantBytes)
+			}
+		})
+	}
+}
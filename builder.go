@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUnknownAlgorithm = errors.New("ratelimitters: unknown algorithm")
+	ErrMissingParameter = errors.New("ratelimitters: missing parameter")
+	ErrInvalidParameter = errors.New("ratelimitters: invalid parameter")
+)
+
+// ParamInfo describes a parameter New takes for an algorithm
+type ParamInfo struct {
+	Name string
+	// Type is "int" for an integer or "duration" for a time.Duration or time.ParseDuration string
+	Type     string
+	Required bool
+}
+
+// AlgorithmInfo describes an algorithm New can build and the parameters it takes
+type AlgorithmInfo struct {
+	Name   string
+	Params []ParamInfo
+}
+
+type builder struct {
+	params []ParamInfo
+	build  func(p params, opts []Option) (RateLimiter, error)
+}
+
+// builders maps each algorithm name accepted by New to its parameters and the function building it from them
+var builders = map[string]builder{
+	"token_bucket": {
+		params: []ParamInfo{
+			{Name: "capacity", Type: "int", Required: true},
+			{Name: "tokens_per_second", Type: "int", Required: true},
+			{Name: "tokens", Type: "int", Required: false},
+		},
+		build: func(p params, opts []Option) (RateLimiter, error) {
+			capacity, err := p.int("capacity")
+			if err != nil {
+				return nil, err
+			}
+			tokensPerSecond, err := p.int("tokens_per_second")
+			if err != nil {
+				return nil, err
+			}
+			// the bucket starts full unless told otherwise
+			tokens, err := p.optionalInt("tokens", capacity)
+			if err != nil {
+				return nil, err
+			}
+			return NewTokenBucket(capacity, tokensPerSecond, tokens, opts...), nil
+		},
+	},
+	"leaky_bucket": {
+		params: []ParamInfo{
+			{Name: "capacity", Type: "int", Required: true},
+			{Name: "leak_rate", Type: "int", Required: true},
+		},
+		build: func(p params, opts []Option) (RateLimiter, error) {
+			capacity, err := p.int("capacity")
+			if err != nil {
+				return nil, err
+			}
+			leakRate, err := p.int("leak_rate")
+			if err != nil {
+				return nil, err
+			}
+			return NewLeakyBucket(capacity, leakRate, opts...), nil
+		},
+	},
+	"fixed_window": {
+		params: []ParamInfo{
+			{Name: "window_size", Type: "int", Required: true},
+			{Name: "capacity", Type: "int", Required: true},
+		},
+		build: func(p params, opts []Option) (RateLimiter, error) {
+			windowSize, err := p.int("window_size")
+			if err != nil {
+				return nil, err
+			}
+			capacity, err := p.int("capacity")
+			if err != nil {
+				return nil, err
+			}
+			return NewFixedWindow(windowSize, capacity, opts...), nil
+		},
+	},
+	"sliding_window": {
+		params: []ParamInfo{
+			{Name: "limit", Type: "int", Required: true},
+			{Name: "window_size", Type: "duration", Required: true},
+		},
+		build: func(p params, opts []Option) (RateLimiter, error) {
+			limit, err := p.int("limit")
+			if err != nil {
+				return nil, err
+			}
+			windowSize, err := p.duration("window_size")
+			if err != nil {
+				return nil, err
+			}
+			return NewSlidingWindow(limit, windowSize, opts...), nil
+		},
+	},
+	"min_interval": {
+		params: []ParamInfo{
+			{Name: "interval", Type: "duration", Required: true},
+		},
+		build: func(p params, opts []Option) (RateLimiter, error) {
+			interval, err := p.duration("interval")
+			if err != nil {
+				return nil, err
+			}
+			return NewMinInterval(interval, opts...), nil
+		},
+	},
+	"decaying_window": {
+		params: []ParamInfo{
+			{Name: "limit", Type: "int", Required: true},
+			{Name: "half_life", Type: "duration", Required: true},
+		},
+		build: func(p params, opts []Option) (RateLimiter, error) {
+			limit, err := p.int("limit")
+			if err != nil {
+				return nil, err
+			}
+			halfLife, err := p.duration("half_life")
+			if err != nil {
+				return nil, err
+			}
+			return NewDecayingWindow(limit, halfLife, opts...), nil
+		},
+	},
+}
+
+// Algorithms lists the algorithms New can build along with their parameters, sorted by name, so tools such as
+// config UIs can tell what's configurable without hard-coding it
+func Algorithms() []AlgorithmInfo {
+	infos := make([]AlgorithmInfo, 0, len(builders))
+	for name, b := range builders {
+		infos = append(infos, AlgorithmInfo{Name: name, Params: append([]ParamInfo(nil), b.params...)})
+	}
+	slices.SortFunc(infos, func(a, b AlgorithmInfo) int { return strings.Compare(a.Name, b.Name) })
+	return infos
+}
+
+// New builds the limiter named by algo, one of "token_bucket", "leaky_bucket", "fixed_window", "sliding_window",
+// "min_interval" or "decaying_window", from a parameter map such as one decoded from a config file. The
+// parameters are named after the constructor arguments in snake case, integers may be given as any Go integer or
+// as a whole float64 (as decoded from JSON) and durations, such as the sliding window's window_size, as a
+// time.Duration or a time.ParseDuration string
+func New(algo string, values map[string]any, opts ...Option) (RateLimiter, error) {
+	b, ok := builders[algo]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownAlgorithm, algo)
+	}
+	return b.build(params{algo: algo, values: values}, opts)
+}
+
+type params struct {
+	algo   string
+	values map[string]any
+}
+
+func (p params) int(name string) (int, error) {
+	v, ok := p.values[name]
+	if !ok {
+		return 0, fmt.Errorf("%w %q for %s", ErrMissingParameter, name, p.algo)
+	}
+	switch v := v.(type) {
+	case int:
+		return v, nil
+	case int32:
+		return int(v), nil
+	case int64:
+		return int(v), nil
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) <= 1<<53 {
+			return int(v), nil
+		}
+	}
+	return 0, fmt.Errorf("%w %q for %s: want an integer, got %v", ErrInvalidParameter, name, p.algo, v)
+}
+
+func (p params) optionalInt(name string, fallback int) (int, error) {
+	if _, ok := p.values[name]; !ok {
+		return fallback, nil
+	}
+	return p.int(name)
+}
+
+func (p params) duration(name string) (time.Duration, error) {
+	v, ok := p.values[name]
+	if !ok {
+		return 0, fmt.Errorf("%w %q for %s", ErrMissingParameter, name, p.algo)
+	}
+	switch v := v.(type) {
+	case time.Duration:
+		return v, nil
+	case string:
+		if d, err := time.ParseDuration(v); err == nil {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("%w %q for %s: want a duration, got %v", ErrInvalidParameter, name, p.algo, v)
+}
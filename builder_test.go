@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name     string
+		algo     string
+		params   map[string]any
+		wantType RateLimiter
+		tokens   int
+		want     bool
+	}{
+		{"token_bucket, expect a full TokenBucket", "token_bucket", map[string]any{"capacity": 10, "tokens_per_second": 5}, &TokenBucket{}, 10, true},
+		{"token_bucket with JSON numbers, expect a TokenBucket with 3 tokens", "token_bucket", map[string]any{"capacity": 10.0, "tokens_per_second": 5.0, "tokens": 3.0}, &TokenBucket{}, 4, false},
+		{"leaky_bucket, expect a LeakyBucket", "leaky_bucket", map[string]any{"capacity": 10, "leak_rate": 5}, &LeakyBucket{}, 1, false},
+		{"fixed_window, expect a FixedWindow", "fixed_window", map[string]any{"window_size": 1, "capacity": 15}, &FixedWindow{}, 15, true},
+		{"sliding_window with a duration, expect a SlidingWindow", "sliding_window", map[string]any{"limit": 15, "window_size": 500 * time.Millisecond}, &SlidingWindow{}, 15, true},
+		{"sliding_window with a duration string, expect a SlidingWindow", "sliding_window", map[string]any{"limit": 15, "window_size": "500ms"}, &SlidingWindow{}, 16, false},
+		{"min_interval, expect a MinInterval", "min_interval", map[string]any{"interval": "100ms"}, &MinInterval{}, 3, true},
+		{"decaying_window, expect a DecayingWindow", "decaying_window", map[string]any{"limit": 10, "half_life": "1s"}, &DecayingWindow{}, 11, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl, err := New(tt.algo, tt.params)
+			if err != nil {
+				t.Fatalf("New(%q) error = %v", tt.algo, err)
+			}
+			defer rl.Stop()
+
+			if gotType, wantType := fmt.Sprintf("%T", rl), fmt.Sprintf("%T", tt.wantType); gotType != wantType {
+				t.Errorf("New(%q) built a %s, want a %s", tt.algo, gotType, wantType)
+			}
+			if got := rl.Allow(tt.tokens); got != tt.want {
+				t.Errorf("Allow(%d) = %v, want %v", tt.tokens, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNew_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		algo    string
+		params  map[string]any
+		wantErr error
+	}{
+		{"Unknown algorithm, expect ErrUnknownAlgorithm", "gcra", map[string]any{}, ErrUnknownAlgorithm},
+		{"Empty algorithm, expect ErrUnknownAlgorithm", "", nil, ErrUnknownAlgorithm},
+		{"token_bucket without a rate, expect ErrMissingParameter", "token_bucket", map[string]any{"capacity": 10}, ErrMissingParameter},
+		{"leaky_bucket without params, expect ErrMissingParameter", "leaky_bucket", nil, ErrMissingParameter},
+		{"fixed_window without a capacity, expect ErrMissingParameter", "fixed_window", map[string]any{"window_size": 1}, ErrMissingParameter},
+		{"sliding_window without a window, expect ErrMissingParameter", "sliding_window", map[string]any{"limit": 5}, ErrMissingParameter},
+		{"token_bucket with a string capacity, expect ErrInvalidParameter", "token_bucket", map[string]any{"capacity": "10", "tokens_per_second": 5}, ErrInvalidParameter},
+		{"token_bucket with a fractional rate, expect ErrInvalidParameter", "token_bucket", map[string]any{"capacity": 10, "tokens_per_second": 2.5}, ErrInvalidParameter},
+		{"sliding_window with a malformed window, expect ErrInvalidParameter", "sliding_window", map[string]any{"limit": 5, "window_size": "soon"}, ErrInvalidParameter},
+		{"min_interval without an interval, expect ErrMissingParameter", "min_interval", map[string]any{}, ErrMissingParameter},
+		{"decaying_window without a half life, expect ErrMissingParameter", "decaying_window", map[string]any{"limit": 10}, ErrMissingParameter},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl, err := New(tt.algo, tt.params)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("New(%q) error = %v, want %v", tt.algo, err, tt.wantErr)
+			}
+			if rl != nil {
+				rl.Stop()
+				t.Errorf("New(%q) returned a limiter alongside an error", tt.algo)
+			}
+		})
+	}
+}
+
+func TestAlgorithms(t *testing.T) {
+	want := []AlgorithmInfo{
+		{"decaying_window", []ParamInfo{{"limit", "int", true}, {"half_life", "duration", true}}},
+		{"fixed_window", []ParamInfo{{"window_size", "int", true}, {"capacity", "int", true}}},
+		{"leaky_bucket", []ParamInfo{{"capacity", "int", true}, {"leak_rate", "int", true}}},
+		{"min_interval", []ParamInfo{{"interval", "duration", true}}},
+		{"sliding_window", []ParamInfo{{"limit", "int", true}, {"window_size", "duration", true}}},
+		{"token_bucket", []ParamInfo{{"capacity", "int", true}, {"tokens_per_second", "int", true}, {"tokens", "int", false}}},
+	}
+	got := Algorithms()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Algorithms() = %+v, want %+v", got, want)
+	}
+
+	// New builds every algorithm from its required parameters alone and reports each one missing
+	for _, info := range got {
+		values := map[string]any{}
+		for _, p := range info.Params {
+			if !p.Required {
+				continue
+			}
+			values[p.Name] = 1
+			if p.Type == "duration" {
+				values[p.Name] = "1s"
+			}
+		}
+		rl, err := New(info.Name, values)
+		if err != nil {
+			t.Errorf("New(%q, %v) error = %v", info.Name, values, err)
+			continue
+		}
+		rl.Stop()
+		for name := range values {
+			partial := maps.Clone(values)
+			delete(partial, name)
+			if _, err := New(info.Name, partial); !errors.Is(err, ErrMissingParameter) {
+				t.Errorf("New(%q) without %q error = %v, want %v", info.Name, name, err, ErrMissingParameter)
+			}
+		}
+	}
+}
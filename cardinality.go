@@ -0,0 +1,74 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// CardinalityLimiter limits how many distinct values, such as client IPs, are seen within a sliding window
+// rather than how many requests are made. A value counts towards the window until window has passed since it
+// was last seen
+type CardinalityLimiter struct {
+	mu          sync.Mutex
+	maxDistinct int
+	window      time.Duration
+	clock       Clock
+	// seen maps each value in the window to when it was last seen
+	seen map[string]time.Time
+}
+
+// NewCardinalityLimiter creates a CardinalityLimiter admitting up to maxDistinct distinct values per window. Of
+// the options only WithClock applies, it has no goroutine to configure
+func NewCardinalityLimiter(maxDistinct int, window time.Duration, opts ...Option) *CardinalityLimiter {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.clock == nil {
+		o.clock = realClock{}
+	}
+	return &CardinalityLimiter{
+		maxDistinct: maxDistinct,
+		window:      window,
+		clock:       o.clock,
+		seen:        map[string]time.Time{},
+	}
+}
+
+// Allow admits value if it's already in the window, which keeps it there for another window, or if fewer than
+// maxDistinct distinct values are
+func (c *CardinalityLimiter) Allow(value string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.clock.Now()
+	if last, ok := c.seen[value]; ok && now.Sub(last) < c.window {
+		c.seen[value] = now
+		return true
+	}
+	if len(c.seen) >= c.maxDistinct {
+		c.expire(now)
+		if len(c.seen) >= c.maxDistinct {
+			return false
+		}
+	}
+	c.seen[value] = now
+	return true
+}
+
+// Distinct returns how many distinct values are in the window right now
+func (c *CardinalityLimiter) Distinct() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expire(c.clock.Now())
+	return len(c.seen)
+}
+
+// expire forgets the values that have left the window, it's only run once the limiter looks full so the cost of
+// scanning every value isn't paid on each request
+func (c *CardinalityLimiter) expire(now time.Time) {
+	for value, last := range c.seen {
+		if now.Sub(last) >= c.window {
+			delete(c.seen, value)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCardinalityLimiter(t *testing.T) {
+	clock := newFakeClock()
+	c := NewCardinalityLimiter(3, time.Minute, WithClock(clock))
+
+	tests := []struct {
+		name         string
+		advance      time.Duration
+		value        string
+		want         bool
+		wantDistinct int
+	}{
+		{"Request a new value, expect allowed (1 distinct)", 0, "10.0.0.1", true, 1},
+		{"Request a new value, expect allowed (2 distinct)", 0, "10.0.0.2", true, 2},
+		{"Request a new value, expect allowed at the cap (3 distinct)", 0, "10.0.0.3", true, 3},
+		{"Request a 4th new value, expect denied", 0, "10.0.0.4", false, 3},
+		{"Request a seen value, expect allowed at the cap", 0, "10.0.0.1", true, 3},
+		{"Request a seen value again, expect allowed again", 0, "10.0.0.1", true, 3},
+		{"Request a seen value 30s later, expect allowed and kept in the window", 30 * time.Second, "10.0.0.1", true, 3},
+		{"Request a 4th new value, expect still denied", 0, "10.0.0.4", false, 3},
+		{"Request a 4th new value once 2 values slid out, expect allowed", 30 * time.Second, "10.0.0.4", true, 2},
+		{"Request a value that slid out, expect allowed as a new one", 0, "10.0.0.2", true, 3},
+		{"Request another new value, expect denied at the cap", 0, "10.0.0.5", false, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock.Advance(tt.advance)
+			if got := c.Allow(tt.value); got != tt.want {
+				t.Errorf("Allow(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+			if got := c.Distinct(); got != tt.wantDistinct {
+				t.Errorf("Distinct() = %d, want %d", got, tt.wantDistinct)
+			}
+		})
+	}
+}
@@ -0,0 +1,14 @@
+package main
+
+// Decorator wraps a RateLimiter to add behaviour such as metrics, logging or smoothing around its Allow and Stop
+type Decorator func(RateLimiter) RateLimiter
+
+// Chain wraps base in each decorator in turn, so the first decorator sits closest to base and the last one is
+// the outermost and sees every call first
+func Chain(base RateLimiter, decorators ...Decorator) RateLimiter {
+	rl := base
+	for _, decorate := range decorators {
+		rl = decorate(rl)
+	}
+	return rl
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// countingLimiter counts the Allow calls passing through it and how many were admitted
+type countingLimiter struct {
+	RateLimiter
+	calls, allowed int
+}
+
+func (c *countingLimiter) Allow(tokens int) bool {
+	c.calls++
+	ok := c.RateLimiter.Allow(tokens)
+	if ok {
+		c.allowed++
+	}
+	return ok
+}
+
+// loggingLimiter appends a line per Allow call to log
+type loggingLimiter struct {
+	RateLimiter
+	log *[]string
+}
+
+func (l loggingLimiter) Allow(tokens int) bool {
+	ok := l.RateLimiter.Allow(tokens)
+	*l.log = append(*l.log, fmt.Sprintf("Allow(%d) = %v", tokens, ok))
+	return ok
+}
+
+func TestChain(t *testing.T) {
+	var metrics *countingLimiter
+	var log []string
+	var order []string
+
+	rl := Chain(NewTokenBucket(10, 5, 5, WithClock(newFakeClock())),
+		func(rl RateLimiter) RateLimiter {
+			order = append(order, "metrics")
+			metrics = &countingLimiter{RateLimiter: rl}
+			return metrics
+		},
+		func(rl RateLimiter) RateLimiter {
+			order = append(order, "logging")
+			return loggingLimiter{RateLimiter: rl, log: &log}
+		},
+	)
+	defer rl.Stop()
+
+	if _, ok := rl.(loggingLimiter); !ok {
+		t.Fatalf("Chain returned %T, want the last decorator outermost", rl)
+	}
+	if fmt.Sprint(order) != "[metrics logging]" {
+		t.Fatalf("decorators applied in order %v, want [metrics logging]", order)
+	}
+
+	for _, tokens := range []int{3, 3, 2} {
+		rl.Allow(tokens)
+	}
+
+	if metrics.calls != 3 || metrics.allowed != 2 {
+		t.Errorf("metrics saw %d calls with %d allowed, want 3 with 2 allowed", metrics.calls, metrics.allowed)
+	}
+	want := []string{"Allow(3) = true", "Allow(3) = false", "Allow(2) = true"}
+	if fmt.Sprint(log) != fmt.Sprint(want) {
+		t.Errorf("log = %q, want %q", log, want)
+	}
+}
+
+func TestChain_NoDecorators(t *testing.T) {
+	base := NewSlidingWindow(1, 0)
+	defer base.Stop()
+
+	if rl := Chain(base); rl != base {
+		t.Errorf("Chain(base) = %v, want base itself", rl)
+	}
+}
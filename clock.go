@@ -0,0 +1,14 @@
+package main
+
+import "time"
+
+// Clock is the source of the current time for a limiter, swap it with WithClock to drive time deterministically in tests
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
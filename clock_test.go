@@ -0,0 +1,140 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock whose time only moves when Advance is called
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+func TestWithClock(t *testing.T) {
+	clock := newFakeClock()
+	rl := NewTokenBucket(10, 5, 0, WithClock(clock))
+	defer rl.Stop()
+
+	tests := []struct {
+		name    string
+		tokens  int
+		want    bool
+		advance time.Duration
+	}{
+		{"Request 1 token, expect denied (bucket starts empty)", 1, false, 0},
+		{"Request 1 token after 100 milliseconds, expect denied (no refill yet)", 1, false, 100 * time.Millisecond},
+		{"Request 5 tokens after 1 second, expect allowed", 5, true, time.Second},
+		{"Request 10 tokens after 1 hour, expect allowed (refill capped at capacity)", 10, true, time.Hour},
+		{"Request 1 token, expect denied (bucket drained)", 1, false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock.Advance(tt.advance)
+
+			got := rl.Allow(tt.tokens)
+			if got != tt.want {
+				t.Errorf("Allow(%d) = %v, want %v", tt.tokens, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackwardClockJump(t *testing.T) {
+	tests := []struct {
+		name  string
+		newRL func(clock Clock) RateLimiter
+	}{
+		{"TokenBucket", func(clock Clock) RateLimiter { return NewTokenBucket(4, 4, 4, WithClock(clock)) }},
+		{"LeakyBucket", func(clock Clock) RateLimiter {
+			rl := NewLeakyBucket(4, 4, WithClock(clock))
+			// the leaky bucket starts full, let it drain
+			clock.(*fakeClock).Advance(time.Second)
+			return rl
+		}},
+		{"FixedWindow", func(clock Clock) RateLimiter { return NewFixedWindow(1, 4, WithClock(clock)) }},
+		{"SlidingWindow", func(clock Clock) RateLimiter { return NewSlidingWindow(4, time.Second, WithClock(clock)) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			rl := tt.newRL(clock)
+			defer rl.Stop()
+
+			if !rl.Allow(2) {
+				t.Fatalf("Allow(2) = false, want true")
+			}
+			// the wall clock is stepped back an hour, as by an NTP correction, and keeps running from there
+			clock.Advance(-time.Hour)
+			if !rl.Allow(2) {
+				t.Fatalf("Allow(2) after the clock stepped back = false, want true")
+			}
+			for _, step := range []time.Duration{0, 500 * time.Millisecond, time.Second, 30 * time.Minute} {
+				clock.Advance(step)
+				if rl.Allow(1) {
+					t.Fatalf("Allow(1) %v after the clock stepped back = true, want false (no extra capacity)", step)
+				}
+			}
+
+			// capacity comes back once the clock has caught up with the time the window was used up at
+			clock.Advance(time.Hour)
+			if !rl.Allow(4) {
+				t.Errorf("Allow(4) once the clock caught up = false, want true")
+			}
+		})
+	}
+}
+
+func TestForwardClockJump(t *testing.T) {
+	tests := []struct {
+		name  string
+		newRL func(clock Clock) RateLimiter
+	}{
+		{"TokenBucket", func(clock Clock) RateLimiter { return NewTokenBucket(4, 4, 4, WithClock(clock)) }},
+		{"TokenBucket refilling a million per second", func(clock Clock) RateLimiter { return NewTokenBucket(4, 1_000_000, 4, WithClock(clock)) }},
+		{"LeakyBucket", func(clock Clock) RateLimiter { return NewLeakyBucket(4, 4, WithClock(clock)) }},
+		{"FixedWindow", func(clock Clock) RateLimiter { return NewFixedWindow(1, 4, WithClock(clock)) }},
+		{"SlidingWindow", func(clock Clock) RateLimiter { return NewSlidingWindow(4, time.Second, WithClock(clock)) }},
+		{"DecayingWindow", func(clock Clock) RateLimiter { return NewDecayingWindow(4, time.Second, WithClock(clock)) }},
+	}
+
+	for _, tt := range tests {
+		for _, jump := range []time.Duration{time.Hour, 100 * 365 * 24 * time.Hour} {
+			t.Run(tt.name+", jump "+jump.String(), func(t *testing.T) {
+				clock := newFakeClock()
+				rl := tt.newRL(clock)
+				defer rl.Stop()
+				DrainUntilDenied(rl, 1)
+
+				// the clock jumps forward, as after a paused VM or a long stall, which credits the capacity and
+				// no more than that
+				clock.Advance(jump)
+				if got := rl.(Introspector).Tokens(); got != 4 {
+					t.Errorf("Tokens() after the jump = %d, want the capacity of 4", got)
+				}
+				if got := DrainUntilDenied(rl, 1); got != 4 {
+					t.Errorf("admitted %d after the jump, want the capacity of 4", got)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+// noCopy is embedded in every limiter so that go vet's copylocks check reports a limiter copied by value. A copy
+// would share the original's goroutine and state with none of its synchronization, Clone is the supported way to
+// duplicate a limiter
+type noCopy struct{}
+
+func (*noCopy) Lock()   {}
+func (*noCopy) Unlock() {}
+
+// Clone returns an independent limiter with the same configuration and a copy of the current state, running on
+// its own goroutine, so requests to one never affect the other. Stats start over from zero and the clone isn't
+// added to the original's Registry. A clone of a stopped limiter is stopped as well
+func (rlb *RateLimiterBase) Clone() RateLimiter {
+	o := rlb.options
+	// the source of WithRand isn't safe for concurrent use, so the clone draws from its own
+	o.rand, o.registry = nil, nil
+	clone, ctx := newRateLimiterBaseWithOptions(o)
+	var algo algorithm
+	copyState := func() {
+		algo = rlb.algo.clone(clone)
+		algo.load(rlb.algo.save())
+		clone.lastNow = rlb.lastNow
+		clone.penalty = rlb.penalty
+		clone.starvation = rlb.starvation
+	}
+	stopped := !rlb.exec(copyState)
+	if stopped {
+		// nothing touches the state of a stopped limiter anymore
+		copyState()
+	}
+	clone.start(ctx, algo)
+	if stopped {
+		clone.Stop()
+	}
+	return algo.(RateLimiter)
+}
+
+func (rl *TokenBucket) clone(rlb *RateLimiterBase) algorithm {
+	return &TokenBucket{
+		RateLimiterBase: rlb,
+		capacity:        rl.capacity,
+		refillTokens:    rl.refillTokens,
+		refillPeriod:    rl.refillPeriod,
+		grace:           rl.grace,
+	}
+}
+
+func (rl *LeakyBucket) clone(rlb *RateLimiterBase) algorithm {
+	return &LeakyBucket{
+		RateLimiterBase: rlb,
+		capacity:        rl.capacity,
+		leakRate:        rl.leakRate,
+		lastSeen:        rl.lastSeen,
+	}
+}
+
+func (rl *FixedWindow) clone(rlb *RateLimiterBase) algorithm {
+	return &FixedWindow{
+		RateLimiterBase: rlb,
+		windowSize:      rl.windowSize,
+		capacity:        rl.capacity,
+	}
+}
+
+func (rl *SlidingWindow) clone(rlb *RateLimiterBase) algorithm {
+	return &SlidingWindow{
+		RateLimiterBase: rlb,
+		limit:           rl.limit,
+		windowSize:      rl.windowSize,
+		timeStamps:      newTimeRing(len(rl.timeStamps.buf)),
+	}
+}
+
+func (rl *MinInterval) clone(rlb *RateLimiterBase) algorithm {
+	return &MinInterval{
+		RateLimiterBase: rlb,
+		interval:        rl.interval,
+	}
+}
+
+func (rl *DecayingWindow) clone(rlb *RateLimiterBase) algorithm {
+	return &DecayingWindow{
+		RateLimiterBase: rlb,
+		limit:           rl.limit,
+		halfLife:        rl.halfLife,
+	}
+}
+
+func (rl *EventBucket) clone(rlb *RateLimiterBase) algorithm {
+	return &EventBucket{
+		RateLimiterBase: rlb,
+		capacity:        rl.capacity,
+	}
+}
+
+func (rl *BaselineLimiter) clone(rlb *RateLimiterBase) algorithm {
+	return &BaselineLimiter{
+		RateLimiterBase: rlb,
+		multiplier:      rl.multiplier,
+		slot:            rl.slot,
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClone(t *testing.T) {
+	clock := newFakeClock()
+	tests := []struct {
+		name  string
+		newRL func() RateLimiter
+	}{
+		{"Clone a token bucket, expect independent tokens", func() RateLimiter { return NewTokenBucket(10, 1, 10, WithClock(clock)) }},
+		{"Clone a leaky bucket, expect independent tokens", func() RateLimiter { return NewLeakyBucket(10, 1, WithClock(clock)) }},
+		{"Clone a fixed window, expect independent tokens", func() RateLimiter { return NewFixedWindow(10, 60, WithClock(clock)) }},
+		{"Clone a sliding window, expect independent tokens", func() RateLimiter { return NewSlidingWindow(10, time.Minute, WithClock(clock)) }},
+		{"Clone a decaying window, expect independent tokens", func() RateLimiter { return NewDecayingWindow(10, time.Minute, WithClock(clock)) }},
+		{"Clone an event bucket, expect independent tokens", func() RateLimiter { return NewEventBucket(10) }},
+		{"Clone a token bucket without a goroutine, expect independent tokens", func() RateLimiter {
+			return NewTokenBucket(10, 1, 10, WithClock(clock), WithoutGoroutine())
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl := tt.newRL()
+			defer rl.Stop()
+			// a leaky bucket starts full
+			clock.Advance(10 * time.Second)
+			for i := 0; i < 4; i++ {
+				rl.Allow(1)
+			}
+			want := rl.(Introspector).Tokens()
+
+			clone := rl.(interface{ Clone() RateLimiter }).Clone()
+			defer clone.Stop()
+			if got := clone.(Introspector).Tokens(); got != want {
+				t.Fatalf("clone Tokens() = %d, want %d", got, want)
+			}
+			if got := DrainUntilDenied(clone, 1); got != want {
+				t.Errorf("DrainUntilDenied(clone) = %d, want %d", got, want)
+			}
+			if got := rl.(Introspector).Tokens(); got != want {
+				t.Errorf("original Tokens() after draining the clone = %d, want %d", got, want)
+			}
+			if got := clone.(interface{ Stats() Stats }).Stats().Allowed; got != uint64(want) {
+				t.Errorf("clone Stats().Allowed = %d, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestClone_Stopped(t *testing.T) {
+	rl := NewTokenBucket(10, 1, 5, WithClock(newFakeClock()))
+	rl.Stop()
+	clone := rl.(*TokenBucket).Clone()
+	if clone.Allow(1) {
+		t.Error("Allow(1) on the clone of a stopped limiter = true, want false")
+	}
+}
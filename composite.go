@@ -0,0 +1,55 @@
+package main
+
+import "math"
+
+// AllLimiter admits a request only when every one of its members does, such as a per-second and a per-minute
+// limit enforced together
+type AllLimiter struct {
+	members []RateLimiter
+}
+
+// All composes limiters into an AllLimiter asking each of them in turn
+func All(limiters ...RateLimiter) *AllLimiter {
+	return &AllLimiter{members: limiters}
+}
+
+// Allow reports whether every member admits tokens. The members ahead of the first one to deny the request are
+// refunded, if they support refunds like the token and leaky buckets do, so a denied request uses up none of them
+func (a *AllLimiter) Allow(tokens int) bool {
+	for i, rl := range a.members {
+		if rl.Allow(tokens) {
+			continue
+		}
+		for _, admitted := range a.members[:i] {
+			if r, ok := admitted.(interface{ Refund(tokens int) }); ok {
+				r.Refund(tokens)
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Stop stops every member
+func (a *AllLimiter) Stop() {
+	for _, rl := range a.members {
+		rl.Stop()
+	}
+}
+
+// EffectiveRate returns the long-run rate in tokens per second rl admits. That's the SustainedRate of a single
+// limiter and the lowest effective rate among the members of an All, the one that binds. A limiter with no known
+// rate, such as one from outside this package, counts as +Inf since it doesn't constrain the rate
+func EffectiveRate(rl RateLimiter) float64 {
+	switch rl := rl.(type) {
+	case *AllLimiter:
+		rate := math.Inf(1)
+		for _, member := range rl.members {
+			rate = min(rate, EffectiveRate(member))
+		}
+		return rate
+	case interface{ SustainedRate() float64 }:
+		return rl.SustainedRate()
+	}
+	return math.Inf(1)
+}
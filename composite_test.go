@@ -0,0 +1,68 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestAll(t *testing.T) {
+	clock := newFakeClock()
+	perSecond := NewTokenBucket(3, 3, 3, WithClock(clock)).(*TokenBucket)
+	perMinute := NewTokenBucket(5, 5, 5, WithClock(clock), WithRefillUnit(time.Minute)).(*TokenBucket)
+	rl := All(perSecond, perMinute)
+	defer rl.Stop()
+
+	tests := []struct {
+		name    string
+		advance time.Duration
+		tokens  int
+		want    bool
+	}{
+		{"Request 3 tokens, expect allowed", 0, 3, true},
+		{"Request 1 token right after, expect denied by the per-second limit", 0, 1, false},
+		{"Request 3 tokens after 1s, expect denied by the per-minute limit (2 left)", time.Second, 3, false},
+		{"Request 2 tokens, expect allowed (the denial was refunded)", 0, 2, true},
+	}
+
+	for _, tt := range tests {
+		clock.Advance(tt.advance)
+		if got := rl.Allow(tt.tokens); got != tt.want {
+			t.Errorf("%s: Allow(%d) = %v, want %v", tt.name, tt.tokens, got, tt.want)
+		}
+	}
+	if got := perSecond.Tokens(); got != 1 {
+		t.Errorf("per-second Tokens() = %d, want 1", got)
+	}
+}
+
+func TestEffectiveRate(t *testing.T) {
+	clock := newFakeClock()
+	tests := []struct {
+		name string
+		rl   func() RateLimiter
+		want float64
+	}{
+		{"Compose 10/s and 2/s, expect 2/s", func() RateLimiter {
+			return All(NewTokenBucket(10, 10, 10, WithClock(clock)), NewLeakyBucket(2, 2, WithClock(clock)))
+		}, 2},
+		{"Compose 10/s and 60/min, expect 1/s", func() RateLimiter {
+			return All(NewTokenBucket(10, 10, 10, WithClock(clock)), NewSlidingWindow(60, time.Minute, WithClock(clock)))
+		}, 1},
+		{"Nest 5/s inside a composite with 10/s, expect 5/s", func() RateLimiter {
+			return All(NewTokenBucket(10, 10, 10, WithClock(clock)), All(NewMinInterval(200*time.Millisecond, WithClock(clock))))
+		}, 5},
+		{"A single 4/s limiter, expect 4/s", func() RateLimiter { return NewTokenBucket(4, 4, 4, WithClock(clock)) }, 4},
+		{"A limiter with no known rate, expect +Inf", func() RateLimiter { return stubLimiter{allow: true} }, math.Inf(1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl := tt.rl()
+			defer rl.Stop()
+			if got := EffectiveRate(rl); math.Abs(got-tt.want) > 1e-9 && got != tt.want {
+				t.Errorf("EffectiveRate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Config describes a limiter's algorithm and parameters in a portable form that can be serialized, compared and
+// used as a map key. Which fields are used depends on the algorithm:
+//
+//   - "token_bucket": Capacity tokens, refilling Rate tokens every Period (a second if zero)
+//   - "leaky_bucket": Capacity tokens, leaking Rate tokens every second
+//   - "fixed_window": Capacity tokens per Window
+//   - "sliding_window": Capacity requests per Window
+//   - "min_interval": at least Window between requests
+//   - "decaying_window": Capacity tokens, with the count of admitted tokens halving every Window
+type Config struct {
+	Algorithm string        `json:"algorithm"`
+	Capacity  int           `json:"capacity,omitempty"`
+	Rate      int           `json:"rate,omitempty"`
+	Period    time.Duration `json:"period,omitempty"`
+	Window    time.Duration `json:"window,omitempty"`
+}
+
+// ConfigOf returns the Config of one of the built-in limiters, with its current parameters. Options such as
+// WithClock aren't part of it, and only the Algorithm is known once the limiter has been stopped. Other
+// limiters get the zero Config
+func ConfigOf(rl RateLimiter) Config {
+	var cfg Config
+	switch rl := rl.(type) {
+	case *TokenBucket:
+		cfg.Algorithm = "token_bucket"
+		rl.exec(func() {
+			cfg.Capacity, cfg.Rate, cfg.Period = rl.capacity, rl.refillTokens, rl.refillPeriod
+		})
+	case *LeakyBucket:
+		cfg.Algorithm = "leaky_bucket"
+		rl.exec(func() {
+			cfg.Capacity, cfg.Rate = rl.capacity, rl.leakRate
+		})
+	case *FixedWindow:
+		cfg.Algorithm = "fixed_window"
+		rl.exec(func() {
+			cfg.Capacity, cfg.Window = rl.capacity, rl.windowSize
+		})
+	case *SlidingWindow:
+		cfg.Algorithm = "sliding_window"
+		rl.exec(func() {
+			cfg.Capacity, cfg.Window = rl.limit, rl.windowSize
+		})
+	case *MinInterval:
+		cfg.Algorithm = "min_interval"
+		rl.exec(func() {
+			cfg.Window = rl.interval
+		})
+	case *DecayingWindow:
+		cfg.Algorithm = "decaying_window"
+		rl.exec(func() {
+			cfg.Capacity, cfg.Window = rl.limit, rl.halfLife
+		})
+	}
+	return cfg
+}
+
+// FromConfig builds a new limiter from cfg, which starts out the way its constructor creates it, with a token
+// bucket full of tokens. It returns an error wrapping ErrUnknownAlgorithm for algorithms it doesn't know
+func FromConfig(cfg Config, opts ...Option) (RateLimiter, error) {
+	switch cfg.Algorithm {
+	case "token_bucket":
+		period := cfg.Period
+		if period == 0 {
+			period = time.Second
+		}
+		return newTokenBucket(cfg.Capacity, cfg.Rate, period, cfg.Capacity, opts), nil
+	case "leaky_bucket":
+		return NewLeakyBucket(cfg.Capacity, cfg.Rate, opts...), nil
+	case "fixed_window":
+		return NewFixedWindowDuration(cfg.Window, cfg.Capacity, opts...), nil
+	case "sliding_window":
+		return NewSlidingWindow(cfg.Capacity, cfg.Window, opts...), nil
+	case "min_interval":
+		return NewMinInterval(cfg.Window, opts...), nil
+	case "decaying_window":
+		return NewDecayingWindow(cfg.Capacity, cfg.Window, opts...), nil
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnknownAlgorithm, cfg.Algorithm)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestConfig_RoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		newRL func(clock Clock) RateLimiter
+		want  Config
+	}{
+		{
+			"TokenBucket",
+			func(clock Clock) RateLimiter { return NewTokenBucket(10, 5, 10, WithClock(clock)) },
+			Config{Algorithm: "token_bucket", Capacity: 10, Rate: 5, Period: time.Second},
+		},
+		{
+			"TokenBucketPerDuration",
+			func(clock Clock) RateLimiter { return NewTokenBucketPerDuration(90, time.Minute, 6, WithClock(clock)) },
+			Config{Algorithm: "token_bucket", Capacity: 6, Rate: 90, Period: time.Minute},
+		},
+		{
+			"LeakyBucket",
+			func(clock Clock) RateLimiter { return NewLeakyBucket(10, 4, WithClock(clock)) },
+			Config{Algorithm: "leaky_bucket", Capacity: 10, Rate: 4},
+		},
+		{
+			"FixedWindow",
+			func(clock Clock) RateLimiter { return NewFixedWindow(2, 7, WithClock(clock)) },
+			Config{Algorithm: "fixed_window", Capacity: 7, Window: 2 * time.Second},
+		},
+		{
+			"FixedWindowDuration",
+			func(clock Clock) RateLimiter {
+				return NewFixedWindowDuration(1500*time.Millisecond, 7, WithClock(clock))
+			},
+			Config{Algorithm: "fixed_window", Capacity: 7, Window: 1500 * time.Millisecond},
+		},
+		{
+			"SlidingWindow",
+			func(clock Clock) RateLimiter { return NewSlidingWindow(6, 1500*time.Millisecond, WithClock(clock)) },
+			Config{Algorithm: "sliding_window", Capacity: 6, Window: 1500 * time.Millisecond},
+		},
+		{
+			"MinInterval",
+			func(clock Clock) RateLimiter { return NewMinInterval(300*time.Millisecond, WithClock(clock)) },
+			Config{Algorithm: "min_interval", Window: 300 * time.Millisecond},
+		},
+		{
+			"DecayingWindow",
+			func(clock Clock) RateLimiter { return NewDecayingWindow(8, 700*time.Millisecond, WithClock(clock)) },
+			Config{Algorithm: "decaying_window", Capacity: 8, Window: 700 * time.Millisecond},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			origClock, copyClock := newFakeClock(), newFakeClock()
+			orig := tt.newRL(origClock)
+			defer orig.Stop()
+
+			cfg := ConfigOf(orig)
+			if cfg != tt.want {
+				t.Fatalf("ConfigOf() = %+v, want %+v", cfg, tt.want)
+			}
+			copied, err := FromConfig(cfg, WithClock(copyClock))
+			if err != nil {
+				t.Fatalf("FromConfig(%+v) error = %v", cfg, err)
+			}
+			defer copied.Stop()
+			if got := ConfigOf(copied); got != cfg {
+				t.Errorf("ConfigOf(FromConfig()) = %+v, want %+v", got, cfg)
+			}
+
+			// both limiters make the same decisions for the same requests over time
+			for i := 0; i < 60; i++ {
+				tokens := 1 + i%4
+				if got, want := copied.Allow(tokens), orig.Allow(tokens); got != want {
+					t.Fatalf("request %d: Allow(%d) = %v on the copy, %v on the original", i, tokens, got, want)
+				}
+				step := time.Duration(i%7) * 100 * time.Millisecond
+				origClock.Advance(step)
+				copyClock.Advance(step)
+			}
+		})
+	}
+}
+
+func TestFromConfig_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr error
+	}{
+		{"Unknown algorithm, expect ErrUnknownAlgorithm", Config{Algorithm: "gcra"}, ErrUnknownAlgorithm},
+		{"No algorithm, expect ErrUnknownAlgorithm", Config{Capacity: 5}, ErrUnknownAlgorithm},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl, err := FromConfig(tt.cfg)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("FromConfig(%+v) error = %v, want %v", tt.cfg, err, tt.wantErr)
+			}
+			if rl != nil {
+				rl.Stop()
+				t.Errorf("FromConfig(%+v) returned a limiter alongside an error", tt.cfg)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import "errors"
+
+// FallbackLimiter asks a primary limiter, such as one backed by a shared store, and falls back to a secondary one,
+// such as a local in-memory limiter, whenever the primary can't decide because it's stopped or its backend fails
+type FallbackLimiter struct {
+	primary    RateLimiter
+	secondary  RateLimiter
+	onFallback func(err error)
+}
+
+// NewFallback creates a FallbackLimiter in front of primary and secondary. Of the options only WithOnFallback
+// applies
+func NewFallback(primary, secondary RateLimiter, opts ...Option) *FallbackLimiter {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &FallbackLimiter{primary: primary, secondary: secondary, onFallback: o.onFallback}
+}
+
+// AllowE asks the primary and returns its decision, a nil error or one of ErrRateLimited, ErrCircuitOpen and
+// ErrNeverAvailable. Any other error, such as ErrStopped, means the primary couldn't decide and the secondary is
+// asked instead. A limiter without an AllowE method is asked through Allow and its denials are ErrRateLimited
+func (f *FallbackLimiter) AllowE(tokens int) error {
+	err := allowE(f.primary, tokens)
+	if err == nil || errors.Is(err, ErrRateLimited) || errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrNeverAvailable) {
+		return err
+	}
+	if f.onFallback != nil {
+		f.onFallback(err)
+	}
+	return allowE(f.secondary, tokens)
+}
+
+// Allow is AllowE reporting only whether the tokens were admitted
+func (f *FallbackLimiter) Allow(tokens int) bool {
+	return f.AllowE(tokens) == nil
+}
+
+// Stop stops both limiters
+func (f *FallbackLimiter) Stop() {
+	f.primary.Stop()
+	f.secondary.Stop()
+}
+
+func allowE(rl RateLimiter, tokens int) error {
+	if e, ok := rl.(interface{ AllowE(tokens int) error }); ok {
+		return e.AllowE(tokens)
+	}
+	if !rl.Allow(tokens) {
+		return ErrRateLimited
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// failingLimiter is a primary whose backend is down
+type failingLimiter struct {
+	err error
+}
+
+func (f failingLimiter) Allow(tokens int) bool   { return false }
+func (f failingLimiter) AllowE(tokens int) error { return f.err }
+func (f failingLimiter) Stop()                   {}
+
+func TestFallbackLimiter(t *testing.T) {
+	clock := newFakeClock()
+	primary := NewTokenBucket(2, 1, 2, WithClock(clock))
+	secondary := NewTokenBucket(3, 1, 3, WithClock(clock)).(*TokenBucket)
+	var fallbacks []error
+	f := NewFallback(primary, secondary, WithOnFallback(func(err error) { fallbacks = append(fallbacks, err) }))
+	defer f.Stop()
+
+	// while the primary is up its decisions stand, denials included
+	for i, want := range []error{nil, nil, ErrRateLimited} {
+		if err := f.AllowE(1); err != want {
+			t.Errorf("AllowE(1) #%d with the primary up = %v, want %v", i, err, want)
+		}
+	}
+	if len(fallbacks) != 0 || secondary.Tokens() != 3 {
+		t.Errorf("secondary used %d times with %d tokens left, want untouched", len(fallbacks), secondary.Tokens())
+	}
+
+	// once the primary is stopped the secondary decides
+	primary.Stop()
+	for i, want := range []error{nil, nil, nil, ErrRateLimited} {
+		if err := f.AllowE(1); err != want {
+			t.Errorf("AllowE(1) #%d with the primary stopped = %v, want %v", i, err, want)
+		}
+	}
+	if secondary.Tokens() != 0 {
+		t.Errorf("secondary Tokens() = %d, want 0", secondary.Tokens())
+	}
+	if len(fallbacks) != 4 {
+		t.Fatalf("WithOnFallback called %d times, want 4", len(fallbacks))
+	}
+	for _, err := range fallbacks {
+		if err != ErrStopped {
+			t.Errorf("WithOnFallback got %v, want %v", err, ErrStopped)
+		}
+	}
+}
+
+func TestFallbackLimiter_BackendError(t *testing.T) {
+	errBackend := errors.New("redis: connection refused")
+	var got error
+	f := NewFallback(failingLimiter{errBackend}, NewTokenBucket(1, 1, 1, WithClock(newFakeClock())), WithOnFallback(func(err error) { got = err }))
+	defer f.Stop()
+
+	if !f.Allow(1) {
+		t.Error("Allow(1) with the backend down = false, want true from the secondary")
+	}
+	if got != errBackend {
+		t.Errorf("WithOnFallback got %v, want %v", got, errBackend)
+	}
+	if f.Allow(1) {
+		t.Error("Allow(1) with the secondary empty = true, want false")
+	}
+}
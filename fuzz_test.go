@@ -0,0 +1,113 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// limiterLevel reads the current level and capacity of one of the built-in limiters from its own goroutine,
+// ok is false once the limiter has been stopped
+func limiterLevel(rl RateLimiter) (level, capacity int, ok bool) {
+	switch rl := rl.(type) {
+	case *TokenBucket:
+		ok = rl.exec(func() { level, capacity = rl.tokens, rl.capacity })
+	case *LeakyBucket:
+		ok = rl.exec(func() { level, capacity = rl.tokens, rl.capacity })
+	case *FixedWindow:
+		ok = rl.exec(func() { level, capacity = rl.tokens, rl.capacity })
+	case *SlidingWindow:
+		ok = rl.exec(func() { level, capacity = rl.timeStamps.len(), rl.limit })
+	}
+	return level, capacity, ok
+}
+
+// fuzzLimiter replays ops against rl, each byte is decoded into an Allow, a clock advance or a Stop, and checks
+// after every step that the limiter's level stays within [0, capacity]
+func fuzzLimiter(t *testing.T, rl RateLimiter, clock *fakeClock, ops []byte) {
+	defer rl.Stop()
+
+	stopped := false
+	for i, op := range ops {
+		arg := int(op >> 2)
+		switch op % 4 {
+		case 0, 1:
+			// small requests, including zero and negative ones
+			tokens := arg - 8
+			if got := rl.Allow(tokens); got && (stopped || tokens <= 0) {
+				t.Fatalf("op %d: Allow(%d) = %v after stopped=%v, want false", i, tokens, got, stopped)
+			}
+		case 2:
+			clock.Advance(time.Duration(arg) * 50 * time.Millisecond)
+		case 3:
+			if arg%16 == 0 {
+				rl.Stop()
+				stopped = true
+				continue
+			}
+			// large requests, well past most capacities
+			if got := rl.Allow(arg * 8); got && stopped {
+				t.Fatalf("op %d: Allow(%d) = %v after Stop, want false", i, arg*8, got)
+			}
+		}
+
+		level, capacity, ok := limiterLevel(rl)
+		if !ok {
+			if !stopped {
+				t.Fatalf("op %d: limiter state unavailable before Stop", i)
+			}
+			continue
+		}
+		if level < 0 || level > capacity {
+			t.Fatalf("op %d: level %d outside [0, %d]", i, level, capacity)
+		}
+	}
+}
+
+func FuzzTokenBucket(f *testing.F) {
+	f.Add(uint8(10), uint8(5), uint8(5), []byte{0, 4, 8, 2, 6, 60, 3, 255, 1})
+	f.Add(uint8(0), uint8(0), uint8(0), []byte{40, 2, 40})
+	f.Add(uint8(3), uint8(200), uint8(250), []byte{62, 62, 254, 41, 0})
+
+	f.Fuzz(func(t *testing.T, capacity, rate, tokens uint8, ops []byte) {
+		clock := newFakeClock()
+		rl := NewTokenBucket(int(capacity), int(rate), int(tokens), WithClock(clock))
+		fuzzLimiter(t, rl, clock, ops)
+	})
+}
+
+func FuzzLeakyBucket(f *testing.F) {
+	f.Add(uint8(10), uint8(5), []byte{0, 4, 8, 2, 6, 60, 3, 255, 1})
+	f.Add(uint8(0), uint8(0), []byte{40, 2, 40})
+	f.Add(uint8(3), uint8(200), []byte{62, 62, 254, 41, 0})
+
+	f.Fuzz(func(t *testing.T, capacity, leakRate uint8, ops []byte) {
+		clock := newFakeClock()
+		rl := NewLeakyBucket(int(capacity), int(leakRate), WithClock(clock))
+		fuzzLimiter(t, rl, clock, ops)
+	})
+}
+
+func FuzzFixedWindow(f *testing.F) {
+	f.Add(uint8(1), uint8(15), []byte{0, 4, 8, 2, 6, 60, 3, 255, 1})
+	f.Add(uint8(0), uint8(0), []byte{40, 2, 40})
+	f.Add(uint8(3), uint8(200), []byte{62, 62, 254, 41, 0})
+
+	f.Fuzz(func(t *testing.T, windowSize, capacity uint8, ops []byte) {
+		clock := newFakeClock()
+		rl := NewFixedWindow(int(windowSize), int(capacity), WithClock(clock))
+		fuzzLimiter(t, rl, clock, ops)
+	})
+}
+
+func FuzzSlidingWindow(f *testing.F) {
+	f.Add(uint8(15), uint8(10), []byte{0, 4, 8, 2, 6, 60, 3, 255, 1})
+	f.Add(uint8(0), uint8(0), []byte{40, 2, 40})
+	f.Add(uint8(3), uint8(200), []byte{62, 62, 254, 41, 0})
+
+	f.Fuzz(func(t *testing.T, limit, windowSize uint8, ops []byte) {
+		clock := newFakeClock()
+		// window sizes range from 0 to roughly 13 seconds in 50 millisecond steps
+		rl := NewSlidingWindow(int(limit), time.Duration(windowSize)*50*time.Millisecond, WithClock(clock))
+		fuzzLimiter(t, rl, clock, ops)
+	})
+}
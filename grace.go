@@ -0,0 +1,77 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// gracePool is the WithGraceBurst allowance of a TokenBucket, a second, slowly regenerating bucket that only
+// covers what the main bucket can't. It's disabled with a capacity of zero
+type gracePool struct {
+	capacity int
+	// regen is how long the pool takes to regenerate from empty to full
+	regen    time.Duration
+	tokens   int
+	lastTime time.Time
+}
+
+func newGracePool(capacity int, regen time.Duration, now time.Time) gracePool {
+	capacity = max(capacity, 0)
+	return gracePool{capacity: capacity, regen: regen, tokens: capacity, lastTime: now}
+}
+
+// refilled returns the tokens and lastTime after regenerating until currentTime, carrying over the time spent
+// towards a token that hasn't regenerated yet like TokenBucket.refilled does
+func (g *gracePool) refilled(currentTime time.Time) (int, time.Time) {
+	elapsed := currentTime.Sub(g.lastTime)
+	if g.tokens >= g.capacity || elapsed >= g.regenDuration(g.capacity-g.tokens) {
+		return g.capacity, currentTime
+	}
+	if elapsed <= 0 || g.regen <= 0 {
+		return g.tokens, g.lastTime
+	}
+	added := int(mulDiv(int64(elapsed), int64(g.capacity), int64(g.regen)))
+	return g.tokens + added, g.lastTime.Add(time.Duration(mulDiv(int64(added), int64(g.regen), int64(g.capacity))))
+}
+
+// regenDuration returns how long it takes to regenerate n tokens, a pool with no regen never does
+func (g *gracePool) regenDuration(n int) time.Duration {
+	if g.regen <= 0 {
+		return math.MaxInt64
+	}
+	return time.Duration(mulDivCeil(int64(n), int64(g.regen), int64(g.capacity)))
+}
+
+func (g *gracePool) available(currentTime time.Time) int {
+	tokens, _ := g.refilled(currentTime)
+	return tokens
+}
+
+// take regenerates the pool until currentTime and takes n of its tokens, the caller has checked they're there
+func (g *gracePool) take(currentTime time.Time, n int) {
+	g.tokens, g.lastTime = g.refilled(currentTime)
+	g.tokens -= n
+}
+
+// heldAt returns when the pool holds n tokens, the zero time if it never does
+func (g *gracePool) heldAt(currentTime time.Time, n int) time.Time {
+	tokens, lastTime := g.refilled(currentTime)
+	if n <= tokens {
+		return currentTime
+	}
+	if g.regen <= 0 || n > g.capacity {
+		return time.Time{}
+	}
+	return lastTime.Add(g.regenDuration(n - tokens))
+}
+
+// budget is how many tokens the pool can give from currentTime until horizon has passed
+func (g *gracePool) budget(currentTime time.Time, horizon time.Duration) int {
+	tokens, lastTime := g.refilled(currentTime)
+	accrued := 0
+	if g.capacity > 0 && g.regen > 0 {
+		elapsed := saturatingAddDuration(currentTime.Sub(lastTime), horizon)
+		accrued = int(mulDiv(int64(elapsed), int64(g.capacity), int64(g.regen)))
+	}
+	return saturatingAdd(tokens, accrued)
+}
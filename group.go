@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrStopTimeout is returned by Group.StopWithTimeout when some limiters didn't stop in time
+var ErrStopTimeout = errors.New("ratelimitters: limiters didn't stop in time")
+
+// Group stops many limiters at once, it satisfies io.Closer so it can sit alongside other resources a server
+// tears down on shutdown. The zero value is ready to use
+type Group struct {
+	mu       sync.Mutex
+	limiters []RateLimiter
+}
+
+// Add registers rl with the group so it's stopped along with the others
+func (g *Group) Add(rl RateLimiter) {
+	g.mu.Lock()
+	g.limiters = append(g.limiters, rl)
+	g.mu.Unlock()
+}
+
+// Stop stops every registered limiter
+func (g *Group) Stop() {
+	_ = g.Close()
+}
+
+// Close stops every registered limiter and returns the errors of those that are themselves io.Closers joined
+// together, limiters that only have Stop can't fail
+func (g *Group) Close() error {
+	var errs []error
+	for _, rl := range g.take() {
+		errs = append(errs, stopMember(rl))
+	}
+	return errors.Join(errs...)
+}
+
+// StopWithTimeout stops every registered limiter in parallel and waits up to d for them. Like Close it returns
+// the errors of the io.Closer members, joined with an error wrapping ErrStopTimeout that lists the limiters
+// still stopping after d by their position in the group and their WithName name or type. Those limiters are
+// left to finish stopping in the background
+func (g *Group) StopWithTimeout(d time.Duration) error {
+	limiters := g.take()
+	type result struct {
+		i   int
+		err error
+	}
+	results := make(chan result, len(limiters))
+	for i, rl := range limiters {
+		go func() {
+			results <- result{i, stopMember(rl)}
+		}()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	stopped := make([]bool, len(limiters))
+	var errs []error
+wait:
+	for range limiters {
+		select {
+		case r := <-results:
+			stopped[r.i] = true
+			errs = append(errs, r.err)
+		case <-timer.C:
+			break wait
+		}
+	}
+
+	var pending []string
+	for i, ok := range stopped {
+		if !ok {
+			pending = append(pending, memberLabel(i, limiters[i]))
+		}
+	}
+	if len(pending) > 0 {
+		errs = append(errs, fmt.Errorf("%w after %v: %s", ErrStopTimeout, d, strings.Join(pending, ", ")))
+	}
+	return errors.Join(errs...)
+}
+
+// take removes and returns the registered limiters
+func (g *Group) take() []RateLimiter {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	limiters := g.limiters
+	g.limiters = nil
+	return limiters
+}
+
+// stopMember closes rl if it's an io.Closer and stops it otherwise
+func stopMember(rl RateLimiter) error {
+	if c, ok := rl.(io.Closer); ok {
+		return c.Close()
+	}
+	rl.Stop()
+	return nil
+}
+
+// memberLabel names the i-th limiter of a group in errors, by its WithName name if it has one and its type otherwise
+func memberLabel(i int, rl RateLimiter) string {
+	if s, ok := rl.(interface{ Stats() Stats }); ok {
+		if name := s.Stats().Name; name != "" {
+			return fmt.Sprintf("#%d %q", i, name)
+		}
+	}
+	return fmt.Sprintf("#%d %T", i, rl)
+}
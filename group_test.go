@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// closingLimiter is a RateLimiter that is also an io.Closer failing with err
+type closingLimiter struct {
+	stubLimiter
+	err    error
+	closed bool
+}
+
+func (c *closingLimiter) Close() error {
+	c.closed = true
+	return c.err
+}
+
+func TestGroup_Stop(t *testing.T) {
+	clock := newFakeClock()
+	limiters := []RateLimiter{
+		NewTokenBucket(10, 5, 10),
+		NewLeakyBucket(10, 5, WithClock(clock)),
+		NewFixedWindow(1, 10),
+		NewSlidingWindow(10, time.Second),
+	}
+
+	// drain the leaky bucket so every limiter would admit a request if it was still running
+	clock.Advance(2 * time.Second)
+
+	g := &Group{}
+	for _, rl := range limiters {
+		g.Add(rl)
+	}
+	g.Stop()
+
+	for i, rl := range limiters {
+		if rl.Allow(1) {
+			t.Errorf("limiter %d: Allow() should return false after Group.Stop() is called", i)
+		}
+	}
+
+	// stopping again has nothing left to do
+	g.Stop()
+}
+
+func TestGroup_Close(t *testing.T) {
+	errBoom := errors.New("boom")
+	failing := &closingLimiter{err: errBoom}
+	clean := &closingLimiter{}
+	rl := NewTokenBucket(10, 5, 10)
+
+	g := &Group{}
+	g.Add(failing)
+	g.Add(rl)
+	g.Add(clean)
+
+	if err := g.Close(); !errors.Is(err, errBoom) {
+		t.Errorf("Close() error = %v, want %v", err, errBoom)
+	}
+	if !failing.closed || !clean.closed {
+		t.Errorf("Close() should close every io.Closer member")
+	}
+	if rl.Allow(1) {
+		t.Error("Allow() should return false after Group.Close() is called")
+	}
+	if err := g.Close(); err != nil {
+		t.Errorf("second Close() error = %v, want nil", err)
+	}
+}
+
+// slowLimiter is a RateLimiter whose Stop blocks until release is closed
+type slowLimiter struct {
+	stubLimiter
+	release chan struct{}
+}
+
+func (s *slowLimiter) Stop() {
+	<-s.release
+}
+
+func TestGroup_StopWithTimeout(t *testing.T) {
+	errBoom := errors.New("boom")
+	slow := &slowLimiter{release: make(chan struct{})}
+	defer close(slow.release)
+	named := NewTokenBucket(10, 5, 10, WithName("api"))
+
+	g := &Group{}
+	g.Add(named)
+	g.Add(slow)
+	g.Add(&closingLimiter{err: errBoom})
+
+	start := time.Now()
+	err := g.StopWithTimeout(50 * time.Millisecond)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("StopWithTimeout took %v, want about 50ms", elapsed)
+	}
+	if !errors.Is(err, ErrStopTimeout) {
+		t.Fatalf("StopWithTimeout() error = %v, want %v", err, ErrStopTimeout)
+	}
+	if !errors.Is(err, errBoom) {
+		t.Errorf("StopWithTimeout() error = %v, want it to include %v", err, errBoom)
+	}
+	if msg := err.Error(); !strings.Contains(msg, "#1 *main.slowLimiter") || strings.Contains(msg, "api") {
+		t.Errorf("StopWithTimeout() error = %q, want it to list only #1 *main.slowLimiter", msg)
+	}
+	if named.Allow(1) {
+		t.Error("Allow() should return false after Group.StopWithTimeout() is called")
+	}
+}
+
+func TestGroup_StopWithTimeout_AllStopped(t *testing.T) {
+	g := &Group{}
+	g.Add(NewTokenBucket(10, 5, 10))
+	g.Add(NewSlidingWindow(10, time.Second))
+
+	if err := g.StopWithTimeout(time.Second); err != nil {
+		t.Errorf("StopWithTimeout() error = %v, want nil", err)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// histogramBuckets is the number of regular Histogram buckets, their upper bounds double from histogramBase so
+// the last one ends after about 3.5 minutes and anything longer lands in an extra overflow bucket
+const (
+	histogramBase    = 100 * time.Microsecond
+	histogramBuckets = 22
+)
+
+// Histogram is a bucketed histogram of durations with buckets doubling in width, which keeps percentiles within
+// a factor of two of the true value at a fixed, tiny cost per sample. The zero value is an empty histogram
+type Histogram struct {
+	counts [histogramBuckets + 1]uint64
+	total  uint64
+}
+
+// observe adds a sample of d to the histogram
+func (h *Histogram) observe(d time.Duration) {
+	h.counts[histogramBucket(d)]++
+	h.total++
+}
+
+// histogramBucket returns the index of the bucket holding d, bucket i holds durations up to histogramBase<<i
+func histogramBucket(d time.Duration) int {
+	if d <= histogramBase {
+		return 0
+	}
+	// the number of doublings of histogramBase it takes to reach d
+	i := bits.Len64(uint64((d - 1) / histogramBase))
+	return min(i, histogramBuckets)
+}
+
+// Count returns the number of samples in the histogram
+func (h Histogram) Count() uint64 {
+	return h.total
+}
+
+// Percentile returns the upper bound of the bucket holding the p-th percentile sample, p ranging from 0 to 100,
+// or 0 if the histogram is empty. Samples in the overflow bucket are reported as the maximum duration
+func (h Histogram) Percentile(p float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	// nearest rank: the smallest sample with at least p percent of the samples at or below it
+	rank := uint64(math.Ceil(p * float64(h.total) / 100))
+	rank = min(max(rank, 1), h.total)
+	seen := uint64(0)
+	for i, count := range h.counts {
+		seen += count
+		if seen >= rank {
+			if i == histogramBuckets {
+				return time.Duration(1<<63 - 1)
+			}
+			return histogramBase << i
+		}
+	}
+	return 0
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHistogram_Percentile(t *testing.T) {
+	var h Histogram
+	if got := h.Percentile(99); got != 0 {
+		t.Errorf("Percentile(99) of an empty histogram = %v, want 0", got)
+	}
+
+	// 90 samples of 1ms, 9 of 30ms and 1 of an hour
+	for i := 0; i < 90; i++ {
+		h.observe(time.Millisecond)
+	}
+	for i := 0; i < 9; i++ {
+		h.observe(30 * time.Millisecond)
+	}
+	h.observe(time.Hour)
+
+	tests := []struct {
+		p    float64
+		want time.Duration
+	}{
+		{0, 1600 * time.Microsecond},
+		{50, 1600 * time.Microsecond},
+		{90, 1600 * time.Microsecond},
+		{95, 51200 * time.Microsecond},
+		{99, 51200 * time.Microsecond},
+		{100, time.Duration(1<<63 - 1)},
+	}
+	for _, tt := range tests {
+		if got := h.Percentile(tt.p); got != tt.want {
+			t.Errorf("Percentile(%v) = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+	if got := h.Count(); got != 100 {
+		t.Errorf("Count() = %d, want 100", got)
+	}
+}
+
+func TestHistogram_PercentileNearestRank(t *testing.T) {
+	// 9 samples of 1ms and 1 of 30ms, the 99th percentile is the slow sample
+	var h Histogram
+	for i := 0; i < 9; i++ {
+		h.observe(time.Millisecond)
+	}
+	h.observe(30 * time.Millisecond)
+
+	tests := []struct {
+		p    float64
+		want time.Duration
+	}{
+		{50, 1600 * time.Microsecond},
+		{90, 1600 * time.Microsecond},
+		{90.1, 51200 * time.Microsecond},
+		{99, 51200 * time.Microsecond},
+	}
+	for _, tt := range tests {
+		if got := h.Percentile(tt.p); got != tt.want {
+			t.Errorf("Percentile(%v) = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestHistogramBucket(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want int
+	}{
+		{-time.Second, 0},
+		{0, 0},
+		{100 * time.Microsecond, 0},
+		{100*time.Microsecond + 1, 1},
+		{200 * time.Microsecond, 1},
+		{400 * time.Microsecond, 2},
+		{400*time.Microsecond + 1, 3},
+		{time.Hour, histogramBuckets},
+	}
+	for _, tt := range tests {
+		if got := histogramBucket(tt.d); got != tt.want {
+			t.Errorf("histogramBucket(%v) = %d, want %d", tt.d, got, tt.want)
+		}
+	}
+}
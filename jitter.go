@@ -0,0 +1,14 @@
+package main
+
+import "time"
+
+// Jitter returns a random delay in [0, maxDelay) drawn from the limiter's source of randomness, callers add it to
+// their retry delays so that clients denied together don't all come back at the same instant
+func (rlb *RateLimiterBase) Jitter(maxDelay time.Duration) time.Duration {
+	if maxDelay <= 0 {
+		return 0
+	}
+	rlb.randMu.Lock()
+	defer rlb.randMu.Unlock()
+	return time.Duration(rlb.rand.Int63n(int64(maxDelay)))
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestWithRand_Jitter(t *testing.T) {
+	first := NewTokenBucket(10, 5, 10, WithRand(rand.New(rand.NewSource(42)))).(*TokenBucket)
+	defer first.Stop()
+	second := NewSlidingWindow(10, time.Second, WithRand(rand.New(rand.NewSource(42)))).(*SlidingWindow)
+	defer second.Stop()
+	other := NewTokenBucket(10, 5, 10, WithRand(rand.New(rand.NewSource(7)))).(*TokenBucket)
+	defer other.Stop()
+
+	maxDelay := time.Second
+	diverged := false
+	for i := 0; i < 100; i++ {
+		a, b, c := first.Jitter(maxDelay), second.Jitter(maxDelay), other.Jitter(maxDelay)
+		if a != b {
+			t.Fatalf("jitter %d: identically seeded limiters returned %v and %v", i, a, b)
+		}
+		if a < 0 || a >= maxDelay {
+			t.Fatalf("jitter %d: %v outside [0, %v)", i, a, maxDelay)
+		}
+		if a != c {
+			diverged = true
+		}
+	}
+	if !diverged {
+		t.Error("limiters seeded differently returned the same jitter sequence")
+	}
+}
+
+func TestJitter_NonPositiveMax(t *testing.T) {
+	rl := NewTokenBucket(10, 5, 10).(*TokenBucket)
+	defer rl.Stop()
+
+	for _, maxDelay := range []time.Duration{0, -time.Second} {
+		if got := rl.Jitter(maxDelay); got != 0 {
+			t.Errorf("Jitter(%v) = %v, want 0", maxDelay, got)
+		}
+	}
+}
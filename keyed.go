@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// KeyedLimiter keeps a separate limiter per key, such as per client or per API token, so each key is limited
+// independently of the others
+type KeyedLimiter struct {
+	mu         sync.Mutex
+	limiters   map[string]RateLimiter
+	newLimiter func(key string) RateLimiter
+	fallback   RateLimiter
+	stopped    bool
+}
+
+// KeyedOption configures optional behaviour of a KeyedLimiter
+type KeyedOption func(*KeyedLimiter)
+
+// WithDefaultLimiter makes every key that wasn't registered share rl instead of getting a limiter of its own,
+// which suits anonymous traffic that should be limited as a whole
+func WithDefaultLimiter(rl RateLimiter) KeyedOption {
+	return func(k *KeyedLimiter) {
+		k.fallback = rl
+	}
+}
+
+// NewKeyedLimiter creates a KeyedLimiter calling newLimiter to create the limiter of a key the first time it's
+// seen, which may pick a different algorithm for different keys. newLimiter may be nil when WithDefaultLimiter is
+// given or all keys are registered up front, requests for other keys are then denied
+func NewKeyedLimiter(newLimiter func(key string) RateLimiter, opts ...KeyedOption) *KeyedLimiter {
+	k := &KeyedLimiter{
+		limiters:   map[string]RateLimiter{},
+		newLimiter: newLimiter,
+	}
+	for _, opt := range opts {
+		opt(k)
+	}
+	return k
+}
+
+// Register gives key a dedicated limiter, replacing and stopping the one it had. Once the keyed limiter has been
+// stopped rl is stopped straight away instead
+func (k *KeyedLimiter) Register(key string, rl RateLimiter) {
+	k.mu.Lock()
+	if k.stopped {
+		k.mu.Unlock()
+		rl.Stop()
+		return
+	}
+	old := k.limiters[key]
+	k.limiters[key] = rl
+	k.mu.Unlock()
+	if old != nil && old != rl {
+		old.Stop()
+	}
+}
+
+// Warm creates the limiters of keys up front so their first requests don't pay for creating them, warmed keys
+// get a dedicated limiter even when WithDefaultLimiter is given. Keys that already have a limiter are left
+// alone, and Warm does nothing without a factory
+func (k *KeyedLimiter) Warm(keys ...string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.stopped || k.newLimiter == nil {
+		return
+	}
+	for _, key := range keys {
+		if _, ok := k.limiters[key]; !ok {
+			k.limiters[key] = k.newLimiter(key)
+		}
+	}
+}
+
+// Evict stops and forgets the dedicated limiter of key, if it has one, so the key starts afresh with a new
+// limiter from the factory on its next request
+func (k *KeyedLimiter) Evict(key string) {
+	k.mu.Lock()
+	rl, ok := k.limiters[key]
+	delete(k.limiters, key)
+	k.mu.Unlock()
+	if ok {
+		rl.Stop()
+	}
+}
+
+// Allow admits tokens for key through the key's limiter, checking and taking the tokens in one step
+func (k *KeyedLimiter) Allow(key string, tokens int) bool {
+	rl := k.limiter(key)
+	if rl == nil {
+		return false
+	}
+	return rl.Allow(tokens)
+}
+
+// AllowKey is Allow for a composite key such as a user and an endpoint, see RateKey
+func (k *KeyedLimiter) AllowKey(key RateKey, tokens int) bool {
+	return k.Allow(key.String(), tokens)
+}
+
+// limiter returns the limiter for key, creating it if need be, or nil if there's none
+func (k *KeyedLimiter) limiter(key string) RateLimiter {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.stopped {
+		return nil
+	}
+	if rl, ok := k.limiters[key]; ok {
+		return rl
+	}
+	if k.fallback != nil {
+		return k.fallback
+	}
+	if k.newLimiter == nil {
+		return nil
+	}
+	rl := k.newLimiter(key)
+	k.limiters[key] = rl
+	return rl
+}
+
+// Range calls fn with every key that has a dedicated limiter and that limiter, in no particular order, until fn
+// returns false. It ranges over a snapshot taken up front without holding any lock, so fn may call the keyed
+// limiter, including Allow and Evict, and keys added or evicted meanwhile may or may not be visited
+func (k *KeyedLimiter) Range(fn func(key string, rl RateLimiter) bool) {
+	type entry struct {
+		key string
+		rl  RateLimiter
+	}
+	k.mu.Lock()
+	entries := make([]entry, 0, len(k.limiters))
+	for key, rl := range k.limiters {
+		entries = append(entries, entry{key, rl})
+	}
+	k.mu.Unlock()
+
+	for _, e := range entries {
+		if !fn(e.key, e.rl) {
+			return
+		}
+	}
+}
+
+// Stop stops the limiter of every key along with the default limiter, later requests are denied
+func (k *KeyedLimiter) Stop() {
+	k.mu.Lock()
+	limiters := k.limiters
+	k.limiters = map[string]RateLimiter{}
+	k.stopped = true
+	k.mu.Unlock()
+
+	for _, rl := range limiters {
+		rl.Stop()
+	}
+	if k.fallback != nil {
+		k.fallback.Stop()
+	}
+}
+
+// RateKey is a composite key made of several fields, such as a user and an endpoint, for KeyedLimiter.AllowKey.
+// It's comparable, and unlike fields joined by hand with a separator two different tuples never come out the
+// same, whatever characters the fields hold
+type RateKey struct {
+	// encoded prefixes every field with its length, which keeps the encoding unambiguous
+	encoded string
+}
+
+// Key builds the RateKey of fields
+func Key(fields ...string) RateKey {
+	var b strings.Builder
+	for _, f := range fields {
+		b.WriteString(strconv.Itoa(len(f)))
+		b.WriteByte(':')
+		b.WriteString(f)
+	}
+	return RateKey{encoded: b.String()}
+}
+
+// String returns the encoded key, which is the key the KeyedLimiter's factory is called with. Composite and
+// plain string keys shouldn't be mixed in the same KeyedLimiter, as a plain key could match an encoded one
+func (k RateKey) String() string {
+	return k.encoded
+}
+
+// Fields returns the fields the key was built from
+func (k RateKey) Fields() []string {
+	fields, _ := splitKey(k.encoded)
+	return fields
+}
+
+// ParseRateKey turns a key encoded by RateKey.String back into a RateKey, so a KeyedLimiter's factory can pick a
+// limiter by the key's fields. It returns an error wrapping ErrInvalidParameter if s isn't an encoded key
+func ParseRateKey(s string) (RateKey, error) {
+	if _, err := splitKey(s); err != nil {
+		return RateKey{}, err
+	}
+	return RateKey{encoded: s}, nil
+}
+
+func splitKey(s string) ([]string, error) {
+	var fields []string
+	for s != "" {
+		n, rest, ok := strings.Cut(s, ":")
+		size, err := strconv.Atoi(n)
+		if !ok || err != nil || size < 0 || size > len(rest) {
+			return nil, fmt.Errorf("%w: malformed rate key %q", ErrInvalidParameter, s)
+		}
+		fields = append(fields, rest[:size])
+		s = rest[size:]
+	}
+	return fields, nil
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyedLimiter(t *testing.T) {
+	clock := newFakeClock()
+	created := 0
+	k := NewKeyedLimiter(func(key string) RateLimiter {
+		created++
+		return NewTokenBucket(3, 1, 3, WithClock(clock))
+	})
+	defer k.Stop()
+
+	tests := []struct {
+		name   string
+		key    string
+		tokens int
+		want   bool
+	}{
+		{"Request 3 tokens for alice, expect allowed", "alice", 3, true},
+		{"Request 1 token for alice, expect denied (alice's bucket empty)", "alice", 1, false},
+		{"Request 3 tokens for bob, expect allowed (bob gets a separate bucket)", "bob", 3, true},
+		{"Request 1 token for bob, expect denied", "bob", 1, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := k.Allow(tt.key, tt.tokens); got != tt.want {
+				t.Errorf("Allow(%q, %d) = %v, want %v", tt.key, tt.tokens, got, tt.want)
+			}
+		})
+	}
+	if created != 2 {
+		t.Errorf("created %d limiters, want one per key", created)
+	}
+}
+
+func TestKeyedLimiter_WithDefaultLimiter(t *testing.T) {
+	clock := newFakeClock()
+	fallback := NewTokenBucket(5, 1, 5, WithClock(clock))
+	k := NewKeyedLimiter(nil, WithDefaultLimiter(fallback))
+	k.Register("premium", NewTokenBucket(3, 1, 3, WithClock(clock)))
+
+	tests := []struct {
+		name   string
+		key    string
+		tokens int
+		want   bool
+	}{
+		{"Request 3 tokens for an unknown key, expect allowed (2 left in the default)", "anon-1", 3, true},
+		{"Request 3 tokens for another unknown key, expect denied (shares the default's budget)", "anon-2", 3, false},
+		{"Request 2 tokens for the other unknown key, expect allowed (default empty)", "anon-2", 2, true},
+		{"Request 3 tokens for the registered key, expect allowed (independent of the default)", "premium", 3, true},
+		{"Request 1 token for the registered key, expect denied", "premium", 1, false},
+		{"Request 1 token for a third unknown key, expect denied (default empty)", "anon-3", 1, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := k.Allow(tt.key, tt.tokens); got != tt.want {
+				t.Errorf("Allow(%q, %d) = %v, want %v", tt.key, tt.tokens, got, tt.want)
+			}
+		})
+	}
+
+	k.Stop()
+	if fallback.Allow(1) {
+		t.Errorf("default limiter Allow(1) after Stop() = true, want false")
+	}
+	if k.Allow("premium", 1) {
+		t.Errorf("Allow(%q, 1) after Stop() = true, want false", "premium")
+	}
+}
+
+func TestKeyedLimiter_NoFactory(t *testing.T) {
+	k := NewKeyedLimiter(nil)
+	defer k.Stop()
+
+	if k.Allow("unknown", 1) {
+		t.Errorf("Allow(%q, 1) without a factory or default = true, want false", "unknown")
+	}
+}
+
+func TestKeyedLimiter_PerKeyAlgorithms(t *testing.T) {
+	clock := newFakeClock()
+	var created []RateLimiter
+	k := NewKeyedLimiter(func(key string) RateLimiter {
+		var rl RateLimiter
+		if strings.HasPrefix(key, "vip") {
+			rl = NewTokenBucket(4, 2, 4, WithClock(clock))
+		} else {
+			rl = NewFixedWindow(2, 4, WithClock(clock))
+		}
+		created = append(created, rl)
+		return rl
+	})
+
+	tests := []struct {
+		name    string
+		advance time.Duration
+		key     string
+		tokens  int
+		want    bool
+	}{
+		{"Request 4 tokens for vip-1, expect allowed (token bucket full)", 0, "vip-1", 4, true},
+		{"Request 4 tokens for guest, expect allowed (fixed window fresh)", 0, "guest", 4, true},
+		{"Request 1 token for vip-1 a second later, expect allowed (2 tokens refilled)", time.Second, "vip-1", 1, true},
+		{"Request 1 token for guest a second later, expect denied (same window)", 0, "guest", 1, false},
+		{"Request 4 tokens for guest another second later, expect allowed (new window)", time.Second, "guest", 4, true},
+		{"Request 4 tokens for vip-1, expect denied (only 3 tokens in the bucket)", 0, "vip-1", 4, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock.Advance(tt.advance)
+			if got := k.Allow(tt.key, tt.tokens); got != tt.want {
+				t.Errorf("Allow(%q, %d) = %v, want %v", tt.key, tt.tokens, got, tt.want)
+			}
+		})
+	}
+
+	if _, ok := created[0].(*TokenBucket); !ok {
+		t.Errorf("vip-1 got a %T, want a *TokenBucket", created[0])
+	}
+	if _, ok := created[1].(*FixedWindow); !ok {
+		t.Errorf("guest got a %T, want a *FixedWindow", created[1])
+	}
+
+	// an evicted key is stopped and starts afresh with a new limiter of its type
+	k.Evict("guest")
+	if created[1].Allow(1) {
+		t.Errorf("evicted limiter Allow(1) = true, want false")
+	}
+	if !k.Allow("guest", 4) {
+		t.Errorf("Allow(%q, 4) after Evict = false, want true", "guest")
+	}
+	if _, ok := created[2].(*FixedWindow); !ok || len(created) != 3 {
+		t.Errorf("guest got a %T after Evict, want a new *FixedWindow", created[len(created)-1])
+	}
+
+	k.Stop()
+	for _, rl := range created {
+		if rl.Allow(1) {
+			t.Errorf("%T Allow(1) after Stop() = true, want false", rl)
+		}
+	}
+}
+
+func TestKeyedLimiter_Warm(t *testing.T) {
+	created := 0
+	k := NewKeyedLimiter(func(key string) RateLimiter {
+		created++
+		return NewTokenBucket(1_000_000, 1_000_000_000, 1_000_000)
+	}, WithDefaultLimiter(NewTokenBucket(1, 1, 0)))
+	defer k.Stop()
+
+	k.Warm("api-1", "api-2", "api-1")
+	if created != 2 {
+		t.Fatalf("Warm created %d limiters, want 2", created)
+	}
+
+	// the first request for a warmed key finds its limiter in place, allocating nothing
+	allocs := testing.AllocsPerRun(1000, func() {
+		if !k.Allow("api-2", 1) {
+			t.Fatalf("Allow(%q, 1) = false, want true", "api-2")
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("Allow on a warmed key allocates %v times per call, want 0", allocs)
+	}
+
+	// warmed keys get dedicated limiters even with a default in place
+	if !k.Allow("api-1", 1) {
+		t.Errorf("Allow(%q, 1) = false, want true", "api-1")
+	}
+	if k.Allow("cold", 1) {
+		t.Errorf("Allow(%q, 1) on the empty default = true, want false", "cold")
+	}
+	if created != 2 {
+		t.Errorf("created %d limiters after Allow, want the 2 warmed ones", created)
+	}
+}
+
+func TestKeyedLimiter_Range(t *testing.T) {
+	k := NewKeyedLimiter(func(key string) RateLimiter {
+		return NewTokenBucket(4, 1, 4, WithClock(newFakeClock()))
+	}, WithDefaultLimiter(NewTokenBucket(1, 1, 1)))
+	defer k.Stop()
+
+	k.Warm("a", "b")
+	k.Register("c", NewFixedWindow(1, 2))
+	k.Register("d", NewSlidingWindow(3, time.Second))
+
+	// the callback calls back into the keyed limiter, which would deadlock if Range held its lock
+	visited := map[string]RateLimiter{}
+	k.Range(func(key string, rl RateLimiter) bool {
+		visited[key] = rl
+		k.Allow(key, 1)
+		return true
+	})
+	if len(visited) != 4 {
+		t.Fatalf("Range visited %d keys, want 4", len(visited))
+	}
+	for _, key := range []string{"a", "b", "c", "d"} {
+		rl, ok := visited[key]
+		if !ok {
+			t.Errorf("Range didn't visit %q", key)
+			continue
+		}
+		if got, want := rl.(Introspector).Tokens(), rl.(Introspector).Capacity()-1; got != want {
+			t.Errorf("key %q has %d tokens after Allow in Range, want %d", key, got, want)
+		}
+	}
+
+	calls := 0
+	k.Range(func(string, RateLimiter) bool {
+		calls++
+		return false
+	})
+	if calls != 1 {
+		t.Errorf("Range called fn %d times after it returned false, want 1", calls)
+	}
+}
+
+func TestKeyedLimiter_RegisterAfterStop(t *testing.T) {
+	k := NewKeyedLimiter(nil)
+	k.Stop()
+
+	rl := NewTokenBucket(3, 1, 3)
+	k.Register("late", rl)
+	// the late limiter is stopped rather than kept running behind a stopped keyed limiter
+	if rl.Allow(1) {
+		t.Error("Allow(1) on a limiter registered after Stop() = true, want false")
+	}
+	if k.Allow("late", 1) {
+		t.Error("Allow(late, 1) after Stop() = true, want false")
+	}
+	k.Range(func(key string, rl RateLimiter) bool {
+		t.Errorf("Range() visited %q after Stop(), want no keys", key)
+		return true
+	})
+}
+
+func TestKeyedLimiter_AllowKey(t *testing.T) {
+	clock := newFakeClock()
+	var created []RateKey
+	k := NewKeyedLimiter(func(key string) RateLimiter {
+		rk, err := ParseRateKey(key)
+		if err != nil {
+			t.Fatalf("ParseRateKey(%q) error = %v", key, err)
+		}
+		created = append(created, rk)
+		return NewTokenBucket(2, 1, 2, WithClock(clock))
+	})
+	defer k.Stop()
+
+	tests := []struct {
+		name   string
+		key    RateKey
+		tokens int
+		want   bool
+	}{
+		{"Request 2 tokens for (alice, /search), expect allowed", Key("alice", "/search"), 2, true},
+		{"Request 1 token for (alice, /search) again, expect denied (same tuple shares a bucket)", Key("alice", "/search"), 1, false},
+		{"Request 2 tokens for (alice, /upload), expect allowed (separate bucket)", Key("alice", "/upload"), 2, true},
+		{"Request 2 tokens for (bob, /search), expect allowed (separate bucket)", Key("bob", "/search"), 2, true},
+		{"Request 2 tokens for (a:b, c), expect allowed (not confused with (a, b:c))", Key("a:b", "c"), 2, true},
+		{"Request 2 tokens for (a, b:c), expect allowed", Key("a", "b:c"), 2, true},
+		{"Request 2 tokens for (a|b, c), expect allowed (not confused with (a, b|c))", Key("a|b", "c"), 2, true},
+		{"Request 2 tokens for (a, b|c), expect allowed", Key("a", "b|c"), 2, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := k.AllowKey(tt.key, tt.tokens); got != tt.want {
+				t.Errorf("AllowKey(%v, %d) = %v, want %v", tt.key.Fields(), tt.tokens, got, tt.want)
+			}
+		})
+	}
+	if len(created) != 7 {
+		t.Errorf("created %d limiters, want one per distinct tuple (7)", len(created))
+	}
+	if got := created[0].Fields(); len(got) != 2 || got[0] != "alice" || got[1] != "/search" {
+		t.Errorf("first key Fields() = %q, want [alice /search]", got)
+	}
+	if Key("alice", "/search") != Key("alice", "/search") {
+		t.Error("identical tuples built different keys")
+	}
+}
+
+func TestParseRateKey_Invalid(t *testing.T) {
+	for _, s := range []string{"alice", "5:abc", "x:abc", "-1:a"} {
+		if _, err := ParseRateKey(s); !errors.Is(err, ErrInvalidParameter) {
+			t.Errorf("ParseRateKey(%q) error = %v, want %v", s, err, ErrInvalidParameter)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// mulDiv returns a*b/c for non-negative a, b and positive c, the intermediate product is computed in 128 bits so
+// it can't overflow and the result saturates at math.MaxInt64
+func mulDiv(a, b, c int64) int64 {
+	q, _ := mulDivRem(a, b, c)
+	return q
+}
+
+// mulDivCeil is mulDiv rounding up instead of down
+func mulDivCeil(a, b, c int64) int64 {
+	q, r := mulDivRem(a, b, c)
+	if r > 0 && q < math.MaxInt64 {
+		q++
+	}
+	return q
+}
+
+func mulDivRem(a, b, c int64) (int64, uint64) {
+	hi, lo := bits.Mul64(uint64(a), uint64(b))
+	if hi >= uint64(c) {
+		return math.MaxInt64, 0
+	}
+	q, r := bits.Div64(hi, lo, uint64(c))
+	if q > math.MaxInt64 {
+		return math.MaxInt64, 0
+	}
+	return int64(q), r
+}
+
+// saturatingMul returns a*b for non-negative a and b, saturating at math.MaxInt instead of wrapping
+func saturatingMul(a, b int) int {
+	if a != 0 && b > math.MaxInt/a {
+		return math.MaxInt
+	}
+	return a * b
+}
+
+// saturatingAdd returns a+b for non-negative a and b, saturating at math.MaxInt instead of wrapping
+func saturatingAdd(a, b int) int {
+	if a > math.MaxInt-b {
+		return math.MaxInt
+	}
+	return a + b
+}
+
+// saturatingAddDuration is saturatingAdd for durations, saturating at the longest representable duration
+func saturatingAddDuration(a, b time.Duration) time.Duration {
+	if a > math.MaxInt64-b {
+		return math.MaxInt64
+	}
+	return a + b
+}
+
+// ceilDiv returns a/b rounded up for non-negative a and positive b, without the overflow of (a+b-1)/b
+func ceilDiv(a, b int) int {
+	q := a / b
+	if a%b != 0 {
+		q++
+	}
+	return q
+}
+
+// seconds converts n whole seconds to a time.Duration, saturating at the longest representable duration
+func seconds(n int) time.Duration {
+	if n > int(math.MaxInt64/time.Second) {
+		return math.MaxInt64
+	}
+	return time.Duration(n) * time.Second
+}
+
+// perSecond returns n per period as a rate per second, which is infinite for a period of zero or less
+func perSecond(n float64, period time.Duration) float64 {
+	if period <= 0 {
+		return math.Inf(1)
+	}
+	return n / period.Seconds()
+}
+
+// quantize rounds d up to a multiple of the positive quantum, saturating at the longest representable multiple
+func quantize(d, quantum time.Duration) time.Duration {
+	q := d / quantum
+	if d%quantum != 0 {
+		q++
+	}
+	if q > math.MaxInt64/quantum {
+		return math.MaxInt64 / quantum * quantum
+	}
+	return q * quantum
+}
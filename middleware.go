@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Middleware admits every request to next through rl at the cost of one token and answers denied ones with
+// 429 Too Many Requests. If rl is an Introspector the draft IETF RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset headers are set on every response, and denied responses carry a Retry-After header whenever
+// rl can tell when the next token frees up
+func Middleware(rl RateLimiter, next http.Handler, opts ...MiddlewareOption) http.Handler {
+	return limitHandler(rl, next, false, opts)
+}
+
+// ProxyLimiter throttles the requests a reverse proxy forwards to one upstream, such as an
+// httputil.ReverseProxy passed as next, at the cost of one token per proxied request. It rejects requests like
+// Middleware, with 429 Too Many Requests and a Retry-After header, unless WithBlocking makes them wait their turn
+// first. Only the responses it answers itself carry the RateLimit headers, proxied responses are left to the
+// upstream's own headers apart from the X-System-Pressure header of WithPressureHeader
+func ProxyLimiter(rl RateLimiter, next http.Handler, opts ...MiddlewareOption) http.Handler {
+	return limitHandler(rl, next, true, opts)
+}
+
+// limitHandler is Middleware, setting the RateLimit headers on denied responses only when proxied is set. The
+// pressure header goes on every response either way
+func limitHandler(rl RateLimiter, next http.Handler, proxied bool, opts []MiddlewareOption) http.Handler {
+	var o middlewareOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		allowed := o.admit(r.Context(), rl)
+
+		if in, ok := rl.(Introspector); ok {
+			capacity, tokens := in.Capacity(), in.Tokens()
+			if !proxied || !allowed {
+				w.Header().Set("RateLimit-Limit", strconv.Itoa(capacity))
+				w.Header().Set("RateLimit-Remaining", strconv.Itoa(tokens))
+				if reset := in.NextReset(); !reset.IsZero() {
+					w.Header().Set("RateLimit-Reset", strconv.Itoa(secondsUntil(reset)))
+				}
+			}
+			if o.pressureHeader {
+				pressure := Stats{Capacity: capacity, Tokens: tokens}.Utilization()
+				w.Header().Set("X-System-Pressure", strconv.FormatFloat(pressure, 'f', 2, 64))
+			}
+		}
+
+		if !allowed {
+			if p, ok := rl.(interface{ NextAvailable(int) time.Time }); ok {
+				if next := p.NextAvailable(1); !next.IsZero() {
+					w.Header().Set("Retry-After", strconv.Itoa(secondsUntil(next)))
+				}
+			}
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ReadinessHandler answers readiness probes, such as Kubernetes', with 200 OK while rl could admit at least
+// minFree tokens right away and 503 Service Unavailable once it's saturated beyond that, so traffic is steered
+// away from saturated instances. A limiter that isn't an Introspector is always reported ready
+func ReadinessHandler(rl RateLimiter, minFree int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if in, ok := rl.(Introspector); ok && in.Tokens() < minFree {
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// MiddlewareOption configures optional behaviour of Middleware
+type MiddlewareOption func(*middlewareOptions)
+
+type middlewareOptions struct {
+	pressureHeader bool
+	maxWait        time.Duration
+}
+
+// admit takes one token for a request, waiting up to maxWait for it with WithBlocking
+func (o middlewareOptions) admit(ctx context.Context, rl RateLimiter) bool {
+	w, ok := rl.(interface {
+		Wait(ctx context.Context, tokens int) error
+	})
+	if o.maxWait <= 0 || !ok {
+		return rl.Allow(1)
+	}
+	ctx, cancel := context.WithTimeout(ctx, o.maxWait)
+	defer cancel()
+	return w.Wait(ctx, 1) == nil
+}
+
+// WithPressureHeader makes Middleware set an X-System-Pressure header on every response, allowed or denied,
+// holding the limiter's utilization after the request as a number from 0.00 to 1.00, so load balancers upstream
+// can shed load before requests start being denied. It needs rl to be an Introspector and is ignored otherwise
+func WithPressureHeader() MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.pressureHeader = true
+	}
+}
+
+// WithBlocking makes a request that would be denied wait up to maxWait for its token instead, or until the client
+// goes away, before it's rejected, which smooths bursts in front of an upstream rather than failing them. It
+// needs rl to have a Wait method like the limiters of this package and is ignored otherwise
+func WithBlocking(maxWait time.Duration) MiddlewareOption {
+	return func(o *middlewareOptions) {
+		o.maxWait = maxWait
+	}
+}
+
+// secondsUntil returns the whole seconds left until t, rounded up so clients never come back too early
+func secondsUntil(t time.Time) int {
+	return max(int(math.Ceil(time.Until(t).Seconds())), 0)
+}
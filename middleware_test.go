@@ -0,0 +1,325 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stubLimiter is a RateLimiter without any of the optional introspection methods
+type stubLimiter struct {
+	allow bool
+}
+
+func (s stubLimiter) Allow(int) bool { return s.allow }
+
+func (s stubLimiter) Stop() {}
+
+func TestMiddleware_RateLimitHeaders(t *testing.T) {
+	rl := NewTokenBucket(3, 1, 3)
+	defer rl.Stop()
+	handler := Middleware(rl, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name           string
+		wantStatus     int
+		wantRemaining  string
+		wantReset      string
+		wantRetryAfter string
+	}{
+		{"Request 1, expect allowed with 2 remaining", http.StatusOK, "2", "1", ""},
+		{"Request 2, expect allowed with 1 remaining", http.StatusOK, "1", "2", ""},
+		{"Request 3, expect allowed with 0 remaining", http.StatusOK, "0", "3", ""},
+		{"Request 4, expect denied with a retry after 1 second", http.StatusTooManyRequests, "0", "3", "1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			headers := []struct{ name, want string }{
+				{"RateLimit-Limit", "3"},
+				{"RateLimit-Remaining", tt.wantRemaining},
+				{"RateLimit-Reset", tt.wantReset},
+				{"Retry-After", tt.wantRetryAfter},
+			}
+			for _, h := range headers {
+				if got := rec.Header().Get(h.name); got != h.want {
+					t.Errorf("%s = %q, want %q", h.name, got, h.want)
+				}
+			}
+		})
+	}
+}
+
+func TestMiddleware_WithoutIntrospection(t *testing.T) {
+	tests := []struct {
+		name       string
+		allow      bool
+		wantStatus int
+	}{
+		{"Allowed request, expect passed through", true, http.StatusOK},
+		{"Denied request, expect 429", false, http.StatusTooManyRequests},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := Middleware(stubLimiter{allow: tt.allow}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			for _, name := range []string{"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"} {
+				if got := rec.Header().Get(name); got != "" {
+					t.Errorf("%s = %q, want no header", name, got)
+				}
+			}
+		})
+	}
+}
+
+func TestMiddleware_PressureHeader(t *testing.T) {
+	rl := NewTokenBucket(4, 1, 4)
+	defer rl.Stop()
+	handler := Middleware(rl, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}), WithPressureHeader())
+
+	tests := []struct {
+		name         string
+		wantStatus   int
+		wantPressure string
+	}{
+		{"Request 1, expect pressure 0.25", http.StatusOK, "0.25"},
+		{"Request 2, expect pressure 0.50", http.StatusOK, "0.50"},
+		{"Request 3, expect pressure 0.75", http.StatusOK, "0.75"},
+		{"Request 4, expect pressure 1.00", http.StatusOK, "1.00"},
+		{"Request 5, expect denied with pressure 1.00", http.StatusTooManyRequests, "1.00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("X-System-Pressure"); got != tt.wantPressure {
+				t.Errorf("X-System-Pressure = %q, want %q", got, tt.wantPressure)
+			}
+		})
+	}
+}
+
+func TestMiddleware_GraceBurstHeaders(t *testing.T) {
+	// the grace pool doesn't push the remaining tokens above the limit
+	rl := NewTokenBucket(10, 1, 10, WithClock(newFakeClock()), WithGraceBurst(5, time.Hour))
+	defer rl.Stop()
+	handler := Middleware(rl, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}), WithPressureHeader())
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	for header, want := range map[string]string{"RateLimit-Limit": "10", "RateLimit-Remaining": "9", "X-System-Pressure": "0.10"} {
+		if got := rec.Header().Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestMiddleware_NoPressureHeaderByDefault(t *testing.T) {
+	rl := NewTokenBucket(4, 1, 4)
+	defer rl.Stop()
+	handler := Middleware(rl, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got := rec.Header().Get("X-System-Pressure"); got != "" {
+		t.Errorf("X-System-Pressure = %q, want no header", got)
+	}
+}
+
+func TestProxyLimiter(t *testing.T) {
+	var forwarded atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded.Add(1)
+		w.Header().Set("RateLimit-Remaining", "upstream")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+	target, err := url.Parse(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// without refills under the fake clock the upstream sees exactly the capacity
+	rl := NewTokenBucket(5, 1, 5, WithClock(newFakeClock()))
+	defer rl.Stop()
+	proxy := httptest.NewServer(ProxyLimiter(rl, httputil.NewSingleHostReverseProxy(target)))
+	defer proxy.Close()
+
+	const numRequests = 20
+	var wg sync.WaitGroup
+	var ok, throttled atomic.Int32
+	for i := 0; i < numRequests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := http.Get(proxy.URL)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer resp.Body.Close()
+			switch resp.StatusCode {
+			case http.StatusOK:
+				ok.Add(1)
+				// proxied responses keep the upstream's own headers
+				if got := resp.Header.Values("RateLimit-Remaining"); len(got) != 1 || got[0] != "upstream" {
+					t.Errorf("proxied RateLimit-Remaining = %q, want only the upstream's", got)
+				}
+			case http.StatusTooManyRequests:
+				throttled.Add(1)
+				if resp.Header.Get("Retry-After") == "" {
+					t.Errorf("throttled response without a Retry-After header")
+				}
+			default:
+				t.Errorf("status = %d, want %d or %d", resp.StatusCode, http.StatusOK, http.StatusTooManyRequests)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := forwarded.Load(); got != 5 {
+		t.Errorf("upstream saw %d requests, want 5", got)
+	}
+	if got := ok.Load(); got != 5 {
+		t.Errorf("%d requests proxied, want 5", got)
+	}
+	if got := throttled.Load(); got != numRequests-5 {
+		t.Errorf("%d requests throttled, want %d", got, numRequests-5)
+	}
+}
+
+func TestProxyLimiter_PressureHeader(t *testing.T) {
+	backend := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	rl := NewTokenBucket(2, 1, 2, WithClock(newFakeClock()))
+	defer rl.Stop()
+	handler := ProxyLimiter(rl, backend, WithPressureHeader())
+
+	tests := []struct {
+		name         string
+		wantStatus   int
+		wantPressure string
+	}{
+		{"Request 1, expect proxied with pressure 0.50", http.StatusOK, "0.50"},
+		{"Request 2, expect proxied with pressure 1.00", http.StatusOK, "1.00"},
+		{"Request 3, expect throttled with pressure 1.00", http.StatusTooManyRequests, "1.00"},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.wantStatus)
+		}
+		if got := rec.Header().Get("X-System-Pressure"); got != tt.wantPressure {
+			t.Errorf("%s: X-System-Pressure = %q, want %q", tt.name, got, tt.wantPressure)
+		}
+		// proxied responses still leave the RateLimit headers to the upstream
+		if got := rec.Header().Get("RateLimit-Remaining"); (got == "") != (tt.wantStatus == http.StatusOK) {
+			t.Errorf("%s: RateLimit-Remaining = %q", tt.name, got)
+		}
+	}
+}
+
+func TestProxyLimiter_WithBlocking(t *testing.T) {
+	backend := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name       string
+		maxWait    time.Duration
+		wantStatus int
+	}{
+		{"Request past the limit waiting long enough, expect proxied", time.Second, http.StatusOK},
+		{"Request past the limit waiting too little, expect throttled", 10 * time.Millisecond, http.StatusTooManyRequests},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// one token every 100ms
+			rl := NewTokenBucketPerDuration(1, 100*time.Millisecond, 1)
+			defer rl.Stop()
+			handler := ProxyLimiter(rl, backend, WithBlocking(tt.maxWait))
+
+			first := httptest.NewRecorder()
+			handler.ServeHTTP(first, httptest.NewRequest(http.MethodGet, "/", nil))
+			if first.Code != http.StatusOK {
+				t.Fatalf("first status = %d, want %d", first.Code, http.StatusOK)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestReadinessHandler(t *testing.T) {
+	rl := NewTokenBucket(10, 1, 10, WithClock(newFakeClock())).(*TokenBucket)
+	defer rl.Stop()
+	handler := ReadinessHandler(rl, 3)
+
+	tests := []struct {
+		name       string
+		op         func()
+		wantStatus int
+	}{
+		{"Full bucket, expect ready", func() {}, http.StatusOK},
+		{"Drain to 3 tokens, expect still ready", func() { rl.Allow(7) }, http.StatusOK},
+		{"Drain to 2 tokens, expect not ready", func() { rl.Allow(1) }, http.StatusServiceUnavailable},
+		{"Drain the rest, expect not ready", func() { rl.DrainAll() }, http.StatusServiceUnavailable},
+		{"Restore 5 tokens, expect ready again", func() { rl.Refund(5) }, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.op()
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	ReadinessHandler(stubLimiter{}, 3).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status without introspection = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Option configures optional behaviour of a rate limiter and is passed to any of the New* constructors
+type Option func(*options)
+
+type options struct {
+	name             string
+	clampToCapacity  bool
+	clock            Clock
+	rand             *rand.Rand
+	circuitOpen      func() bool
+	spinWait         time.Duration
+	registry         *Registry
+	withoutGoroutine bool
+	costScale        func() float64
+	metricsHook      func(allowed bool, tags map[string]string)
+	metricsFlush     time.Duration
+	batchedMetrics   func(allowed, denied uint64)
+	denialSink       RateLimiter
+	onSinkExhausted  func()
+	shadow           RateLimiter
+	onDivergence     func(real, shadow bool)
+	penaltyBase      time.Duration
+	penaltyMax       time.Duration
+	maxStarvation    int
+	tracer           Tracer
+	dryRun           bool
+	retryQuantum     time.Duration
+	overloadFactor   float64
+	onOverload       func(attemptedRate float64)
+
+	// token bucket only
+	reservedForHighPriority int
+	earlyDrop               bool
+	earlyDropMinUtil        float64
+	burstMultiplier         float64
+	refillUnit              time.Duration
+	graceAmount             int
+	graceRegen              time.Duration
+	leakDetection           bool
+
+	// leaky bucket only
+	strictFull bool
+
+	// window limiters only
+	onWindowReset func(windowStart time.Time)
+
+	// quota limited only
+	quotaReset time.Duration
+
+	// scheduled limiter only
+	fallback RateLimiter
+
+	// fallback limiter only
+	onFallback func(err error)
+}
+
+// WithName names the limiter in its Stats, which tells limiters apart when their stats are scraped together
+func WithName(name string) Option {
+	return func(o *options) {
+		o.name = name
+	}
+}
+
+// WithClampToCapacity treats a request for more tokens than the limiter's capacity as a request for exactly
+// the capacity instead of denying it outright, which is handy for callers asking for "as much as possible". A
+// limiter with a capacity of zero, such as after SetCapacity(0), still denies every request
+func WithClampToCapacity() Option {
+	return func(o *options) {
+		o.clampToCapacity = true
+	}
+}
+
+// WithClock makes the limiter read the current time from clock instead of the system clock
+func WithClock(clock Clock) Option {
+	return func(o *options) {
+		o.clock = clock
+	}
+}
+
+// WithReservedForHighPriority keeps the last n tokens of a TokenBucket for high priority requests made through
+// AllowPriority, low priority requests and plain Allow calls are denied once they would dig into them
+func WithReservedForHighPriority(n int) Option {
+	return func(o *options) {
+		o.reservedForHighPriority = max(n, 0)
+	}
+}
+
+// WithBurstMultiplier sizes a TokenBucket's capacity to m times its refill rate per second, rounded up, in place
+// of the capacity passed to the constructor, so "bursts of twice the rate" is WithBurstMultiplier(2). It panics
+// if m is less than 1
+func WithBurstMultiplier(m float64) Option {
+	if !(m >= 1) {
+		panic("ratelimitters: burst multiplier must be at least 1")
+	}
+	return func(o *options) {
+		o.burstMultiplier = m
+	}
+}
+
+// WithRefillUnit makes the integer refill rate passed to NewTokenBucket, and later to SetRate, apply per unit
+// instead of per second, so NewTokenBucket(10, 5, 5, WithRefillUnit(time.Minute)) refills 5 tokens a minute. It
+// panics if unit isn't positive
+func WithRefillUnit(unit time.Duration) Option {
+	if unit <= 0 {
+		panic("ratelimitters: refill unit must be positive")
+	}
+	return func(o *options) {
+		o.refillUnit = unit
+	}
+}
+
+// WithGraceBurst gives a TokenBucket a grace pool of amount extra tokens that covers requests once the bucket
+// itself runs short, such as a monthly allowance of overages on top of a steady rate. The pool starts full and
+// regenerates from empty to full over regen on its own schedule, with a regen of zero or less it never does. An
+// amount of zero or less, the default, disables it
+func WithGraceBurst(amount int, regen time.Duration) Option {
+	return func(o *options) {
+		o.graceAmount = amount
+		o.graceRegen = regen
+	}
+}
+
+// WithOnFallback calls fn with the primary's error every time a FallbackLimiter hands a request to its secondary,
+// so the switch to the degraded path shows up in logs and metrics
+func WithOnFallback(fn func(err error)) Option {
+	return func(o *options) {
+		o.onFallback = fn
+	}
+}
+
+// WithQuotaReset sets the time of day, as an offset from midnight in the location of the clock's readings, at
+// which a QuotaLimited's daily quota starts over. It's taken modulo a day and defaults to midnight
+func WithQuotaReset(timeOfDay time.Duration) Option {
+	return func(o *options) {
+		o.quotaReset = (timeOfDay%(24*time.Hour) + 24*time.Hour) % (24 * time.Hour)
+	}
+}
+
+// WithFallback makes a ScheduledLimiter ask fallback outside its schedule instead of admitting every request
+func WithFallback(fallback RateLimiter) Option {
+	return func(o *options) {
+		o.fallback = fallback
+	}
+}
+
+// WithLeakDetection makes a RateAdapter keep count of the reservations that haven't been cancelled or consumed,
+// as reported by OutstandingReservations, and log those garbage collected in that state. A leaked reservation
+// silently holds on to its tokens, which is easy to miss without it
+func WithLeakDetection() Option {
+	return func(o *options) {
+		o.leakDetection = true
+	}
+}
+
+// WithAdmitWhenExactlyFull sets whether a LeakyBucket admits a request that fills it exactly to its capacity. By
+// default it does, so a bucket of capacity 10 holds 10 tokens, while WithAdmitWhenExactlyFull(false) is strict and
+// only admits requests that leave it below its capacity
+func WithAdmitWhenExactlyFull(admit bool) Option {
+	return func(o *options) {
+		o.strictFull = !admit
+	}
+}
+
+// WithEarlyDrop makes a TokenBucket drop requests at random as it nears empty instead of only once it runs out,
+// like random early detection in network queues. Once the bucket's utilization, the share of its capacity
+// that's been used up, exceeds minUtil, requests are denied with a probability rising linearly from 0 at minUtil
+// to 1 at full utilization even if enough tokens remain. minUtil is clamped to [0, 1]
+func WithEarlyDrop(minUtil float64) Option {
+	return func(o *options) {
+		o.earlyDrop = true
+		o.earlyDropMinUtil = min(max(minUtil, 0), 1)
+	}
+}
+
+// WithCircuitBreaker makes every admission path (Allow, AllowE, AllowDetailed, Wait, Do, AllowPriority, AllowAt
+// and RateAdapter) deny every request without taking any tokens while isOpen reports true, AllowE, Wait and Do
+// report those denials as ErrCircuitOpen. isOpen is called on the limiter's own
+// goroutine for each request, so it must be quick and must not call the limiter
+func WithCircuitBreaker(isOpen func() bool) Option {
+	return func(o *options) {
+		o.circuitOpen = isOpen
+	}
+}
+
+// WithSpinWait makes Allow wait up to maxWait for the tokens of a denied request to become available before
+// denying it, trading a little latency for admitting requests that only miss a refill by a few milliseconds.
+// Allow sleeps rather than busy loops, and denies straight away requests that can never be admitted
+func WithSpinWait(maxWait time.Duration) Option {
+	return func(o *options) {
+		o.spinWait = maxWait
+	}
+}
+
+// WithRegistry makes the limiter join r, which then includes its Stats in Report until the limiter is stopped
+func WithRegistry(r *Registry) Option {
+	return func(o *options) {
+		o.registry = r
+	}
+}
+
+// WithPenalty locks out a limiter that keeps getting requests over its limit: the first denial denies every
+// request for base, and each further denial, including those during a lockout, doubles the lockout up to
+// maxCooldown. Once no request has been denied for maxCooldown after a lockout ended the lockout starts over at
+// base. Given to the limiters of a KeyedLimiter it penalizes each key on its own. A base of zero or less disables
+// the penalty, and maxCooldown is raised to base if it's lower
+func WithPenalty(base, maxCooldown time.Duration) Option {
+	return func(o *options) {
+		o.penaltyBase = base
+		o.penaltyMax = max(base, maxCooldown)
+	}
+}
+
+// WithRetryAfterQuantum rounds the waits the limiter reports, through NextAvailable, Inspect and the Retry-After
+// header of Middleware, up to a multiple of quantum, so clients can't read precise internal timing from them. The
+// rounded wait is never shorter than the real one, and combined with Jitter it also spreads retries out. A
+// quantum of zero or less, the default, reports exact waits
+func WithRetryAfterQuantum(quantum time.Duration) Option {
+	return func(o *options) {
+		o.retryQuantum = quantum
+	}
+}
+
+// WithDryRun makes the limiter admit every request while it still decides and records each one as usual, so
+// Stats, WithMetricsHook and the other hooks report the denials it would have made. Wait and Do never block in a
+// dry run. It lets new limits be validated on real traffic before they're enforced
+func WithDryRun(enabled bool) Option {
+	return func(o *options) {
+		o.dryRun = enabled
+	}
+}
+
+// WithTracer makes Wait and Do start a span from tracer whenever a caller has to block for its tokens, recording
+// how many tokens it asked for, how long it waited and whether they were admitted. Callers admitted straight away
+// don't get a span, and without a tracer Wait does no tracing work at all
+func WithTracer(tracer Tracer) Option {
+	return func(o *options) {
+		o.tracer = tracer
+	}
+}
+
+// WithMaxStarvation keeps a stream of small requests from starving a larger one forever. Once requests of the same
+// size have been denied n consecutive times, requests of any other size are denied until one of that size is
+// admitted, so it gets the next tokens that become available. Smaller requests in between don't interrupt the
+// count. Requests beyond the capacity are never reserved for, and a reservation lapses once the limiter could have
+// refilled its whole capacity without a request of that size arriving. An n of zero or less, the default, disables
+// it
+func WithMaxStarvation(n int) Option {
+	return func(o *options) {
+		o.maxStarvation = n
+	}
+}
+
+// WithMetricsHook calls fn with every admission decision the limiter counts towards its Stats, along with the tags
+// passed to AllowTagged, which are nil for the other ways of asking for tokens. fn runs on the limiter's own
+// goroutine, so it must be quick, must not call the limiter and must not modify tags. Without a hook deciding a
+// request costs nothing extra
+func WithMetricsHook(fn func(allowed bool, tags map[string]string)) Option {
+	return func(o *options) {
+		o.metricsHook = fn
+	}
+}
+
+// WithBatchedMetrics calls fn every flushInterval with how many requests were admitted and denied since its last
+// call, and once more on Stop, in place of a WithMetricsHook call per decision on a very hot limiter. It reads the
+// counts kept for Stats, so deciding a request costs nothing extra, but the decisions lose their tags and their
+// exact timing. fn runs on a goroutine of its own and isn't called for an interval without decisions. A
+// flushInterval of zero or less disables it
+func WithBatchedMetrics(flushInterval time.Duration, fn func(allowed, denied uint64)) Option {
+	return func(o *options) {
+		o.metricsFlush = flushInterval
+		o.batchedMetrics = fn
+	}
+}
+
+// WithOverloadThreshold calls onOverload with the attempted rate in tokens per second, counting denied requests
+// too, once it goes over factor times the limiter's sustained rate, which tells being hammered apart from being
+// throttled as usual. The attempted rate is measured over windows of a second and onOverload fires at most once a
+// window, on the limiter's own goroutine, so it must be quick and must not call the limiter. Limiters without a
+// positive, finite sustained rate, such as an EventBucket, are never overloaded. It panics if factor isn't
+// positive
+func WithOverloadThreshold(factor float64, onOverload func(attemptedRate float64)) Option {
+	if !(factor > 0) {
+		panic("ratelimitters: overload factor must be positive")
+	}
+	return func(o *options) {
+		o.overloadFactor = factor
+		o.onOverload = onOverload
+	}
+}
+
+// WithDenialSink feeds every denial to sink by calling sink.Allow(1), so sink limits how often a client may be
+// denied and runs out under scanning or abuse. Each denial that sink denies in turn calls onExhausted, if given,
+// which can trip a ban. Both run on the limiter's own goroutine, so onExhausted must be quick and must not call
+// the limiter, and sink must not be the limiter itself or feed its own denials back to it
+func WithDenialSink(sink RateLimiter, onExhausted func()) Option {
+	return func(o *options) {
+		o.denialSink = sink
+		o.onSinkExhausted = onExhausted
+	}
+}
+
+// WithShadow runs candidate alongside the limiter to try out a new limit before enforcing it. Every request the
+// limiter decides on is also asked of candidate through its Allow, and whenever the two disagree onDivergence, if
+// given, is called with the limiter's real decision and candidate's. candidate never changes the real decision.
+// onDivergence runs on the limiter's own goroutine, so it must be quick and must not call the limiter
+func WithShadow(candidate RateLimiter, onDivergence func(real, shadow bool)) Option {
+	return func(o *options) {
+		o.shadow = candidate
+		o.onDivergence = onDivergence
+	}
+}
+
+// WithoutGoroutine runs the limiter without a background goroutine, for environments such as WASM or restricted
+// runtimes that discourage them. Every algorithm already computes its refills, leaks and window rollovers on
+// demand, so each call instead does its work on the caller's goroutine under a mutex, and Stop has no goroutine
+// to wait for. Limits are enforced exactly as with the goroutine
+func WithoutGoroutine() Option {
+	return func(o *options) {
+		o.withoutGoroutine = true
+	}
+}
+
+// WithCostScale makes every request cost its tokens times scale(), rounded up, so limits can be tightened during
+// peak hours without changing the capacity: a scale of 2 doubles the cost of each request. It applies to Allow,
+// AllowE, AllowDetailed, Wait, NextAvailable and Inspect. scale is called on the limiter's own goroutine for
+// each request, so it must be quick and must not call the limiter, and a scale that isn't positive counts as 1
+func WithCostScale(scale func() float64) Option {
+	return func(o *options) {
+		o.costScale = scale
+	}
+}
+
+// WithRand makes the limiter draw its randomness, such as the delays returned by Jitter, from r so that it can be
+// reproduced with a fixed seed. By default each limiter gets its own source seeded from the current time
+func WithRand(r *rand.Rand) Option {
+	return func(o *options) {
+		o.rand = r
+	}
+}
+
+// WithOnWindowReset calls fn with the start of each new window of a FixedWindow or SlidingWindow. Rollovers are
+// detected lazily by the first request of the new window, a fixed window starts at that request and a sliding
+// window starts over at the first request arriving after every earlier one has slid out. fn runs on the
+// limiter's own goroutine before the request is decided, so it must be quick and must not call the limiter
+func WithOnWindowReset(fn func(windowStart time.Time)) Option {
+	return func(o *options) {
+		o.onWindowReset = fn
+	}
+}
+
+// unit is the period the integer refill rate of a TokenBucket applies to, a second unless set by WithRefillUnit
+func (o *options) unit() time.Duration {
+	if o.refillUnit > 0 {
+		return o.refillUnit
+	}
+	return time.Second
+}
@@ -0,0 +1,1071 @@
+package main
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWithClampToCapacity(t *testing.T) {
+	tests := []struct {
+		name     string
+		newRL    func(opts ...Option) RateLimiter
+		tokens   int
+		waitTime time.Duration
+	}{
+		{"TokenBucket", func(opts ...Option) RateLimiter { return NewTokenBucket(10, 5, 10, opts...) }, 16, 0},
+		{"LeakyBucket", func(opts ...Option) RateLimiter { return NewLeakyBucket(10, 10, opts...) }, 16, time.Second},
+		{"FixedWindow", func(opts ...Option) RateLimiter { return NewFixedWindow(1, 10, opts...) }, 16, 0},
+		{"SlidingWindow", func(opts ...Option) RateLimiter { return NewSlidingWindow(10, time.Second, opts...) }, 16, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name+", expect over-capacity request denied by default", func(t *testing.T) {
+			rl := tt.newRL()
+			defer rl.Stop()
+			if tt.waitTime > 0 {
+				time.Sleep(tt.waitTime)
+			}
+
+			if got := rl.Allow(tt.tokens); got {
+				t.Errorf("Allow(%d) = %v, want %v", tt.tokens, got, false)
+			}
+		})
+
+		t.Run(tt.name+", expect over-capacity request clamped to capacity", func(t *testing.T) {
+			rl := tt.newRL(WithClampToCapacity())
+			defer rl.Stop()
+			if tt.waitTime > 0 {
+				time.Sleep(tt.waitTime)
+			}
+
+			if got := rl.Allow(tt.tokens); !got {
+				t.Errorf("Allow(%d) = %v, want %v", tt.tokens, got, true)
+			}
+			// the clamped request consumed the whole capacity
+			if got := rl.Allow(1); got {
+				t.Errorf("Allow(1) = %v, want %v", got, false)
+			}
+		})
+	}
+}
+
+func TestWithClampToCapacity_ZeroCapacity(t *testing.T) {
+	clock := newFakeClock()
+	tb := NewTokenBucket(10, 5, 10, WithClock(clock), WithClampToCapacity()).(*TokenBucket)
+	tb.SetCapacity(0)
+
+	tests := []struct {
+		name string
+		rl   RateLimiter
+	}{
+		{"TokenBucket after SetCapacity(0)", tb},
+		{"FixedWindow of 0", NewFixedWindow(1, 0, WithClock(clock), WithClampToCapacity())},
+		{"SlidingWindow of 0", NewSlidingWindow(0, time.Second, WithClock(clock), WithClampToCapacity())},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+", expect every request denied", func(t *testing.T) {
+			defer tt.rl.Stop()
+			for _, tokens := range []int{1, 3, 100} {
+				if tt.rl.Allow(tokens) {
+					t.Errorf("Allow(%d) = true, want false", tokens)
+				}
+			}
+		})
+	}
+}
+
+func TestWithOnWindowReset(t *testing.T) {
+	tests := []struct {
+		name  string
+		newRL func(opts ...Option) RateLimiter
+		// requests are made at these offsets from the start, 1 token each
+		at   []time.Duration
+		want []time.Duration
+	}{
+		{
+			"FixedWindow of 1 second, expect a reset at the first request of each later window",
+			func(opts ...Option) RateLimiter { return NewFixedWindow(1, 5, opts...) },
+			[]time.Duration{0, 500 * time.Millisecond, 999 * time.Millisecond, time.Second, 1500 * time.Millisecond, 2 * time.Second, 5200 * time.Millisecond, 5300 * time.Millisecond},
+			[]time.Duration{time.Second, 2 * time.Second, 5200 * time.Millisecond},
+		},
+		{
+			"SlidingWindow of 200 milliseconds, expect a reset once every request has slid out",
+			func(opts ...Option) RateLimiter { return NewSlidingWindow(5, 200*time.Millisecond, opts...) },
+			// at 250ms the window still holds the request from 100ms and at 600ms the one from 450ms+1ns
+			[]time.Duration{0, 100 * time.Millisecond, 250 * time.Millisecond, 450*time.Millisecond + 1, 600 * time.Millisecond, 900 * time.Millisecond},
+			[]time.Duration{450*time.Millisecond + 1, 900 * time.Millisecond},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			start := clock.Now()
+			var resets []time.Duration
+			rl := tt.newRL(WithClock(clock), WithOnWindowReset(func(windowStart time.Time) {
+				resets = append(resets, windowStart.Sub(start))
+			}))
+			defer rl.Stop()
+
+			for _, at := range tt.at {
+				clock.Advance(start.Add(at).Sub(clock.Now()))
+				rl.Allow(1)
+			}
+
+			// the callback runs on the limiter's goroutine, Capacity syncs with it before resets is read
+			rl.(Introspector).Capacity()
+			if len(resets) != len(tt.want) {
+				t.Fatalf("window resets at %v, want %v", resets, tt.want)
+			}
+			for i := range tt.want {
+				if resets[i] != tt.want[i] {
+					t.Fatalf("window resets at %v, want %v", resets, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestWithEarlyDrop(t *testing.T) {
+	clock := newFakeClock()
+	rl := NewTokenBucket(100, 5, 100, WithClock(clock), WithEarlyDrop(0.5), WithRand(rand.New(rand.NewSource(1)))).(*TokenBucket)
+	defer rl.Stop()
+
+	tests := []struct {
+		name     string
+		tokens   int
+		wantDrop float64
+	}{
+		{"Bucket 60% full, expect no drops (utilization below the threshold)", 60, 0},
+		{"Bucket 50% full, expect no drops (utilization at the threshold)", 50, 0},
+		{"Bucket 40% full, expect 20% dropped", 40, 0.2},
+		{"Bucket 20% full, expect 60% dropped", 20, 0.6},
+		{"Bucket 5% full, expect 90% dropped", 5, 0.9},
+	}
+
+	const trials = 5000
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dropped := 0
+			for i := 0; i < trials; i++ {
+				// put the bucket back to the same level before each request
+				rl.exec(func() {
+					rl.tokens = tt.tokens
+					if !rl.allow(clock.Now(), 1) {
+						dropped++
+					}
+				})
+			}
+			if got := float64(dropped) / trials; math.Abs(got-tt.wantDrop) > 0.03 {
+				t.Errorf("dropped %.3f of requests, want %.3f", got, tt.wantDrop)
+			}
+		})
+	}
+}
+
+func TestWithCircuitBreaker(t *testing.T) {
+	var open atomic.Bool
+	rl := NewTokenBucket(5, 1, 5, WithClock(newFakeClock()), WithCircuitBreaker(open.Load)).(*TokenBucket)
+	defer rl.Stop()
+
+	tests := []struct {
+		name    string
+		open    bool
+		tokens  int
+		wantErr error
+	}{
+		{"Request 2 tokens with the breaker closed, expect allowed (3 left)", false, 2, nil},
+		{"Request 2 tokens with the breaker open, expect ErrCircuitOpen", true, 2, ErrCircuitOpen},
+		{"Request 1 token with the breaker open, expect ErrCircuitOpen", true, 1, ErrCircuitOpen},
+		{"Request 3 tokens with the breaker closed again, expect allowed (no tokens taken while open)", false, 3, nil},
+		{"Request 1 token with the breaker closed, expect ErrRateLimited", false, 1, ErrRateLimited},
+		{"Request 0 tokens, expect ErrNeverAvailable", false, 0, ErrNeverAvailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			open.Store(tt.open)
+			if err := rl.AllowE(tt.tokens); err != tt.wantErr {
+				t.Errorf("AllowE(%d) = %v, want %v", tt.tokens, err, tt.wantErr)
+			}
+		})
+	}
+
+	rl.Refund(5)
+	open.Store(true)
+	if rl.Allow(1) {
+		t.Errorf("Allow(1) with the breaker open = true, want false")
+	}
+	if got := rl.Tokens(); got != 5 {
+		t.Errorf("Tokens() after requests denied by the breaker = %d, want 5", got)
+	}
+
+	rl.Stop()
+	if err := rl.AllowE(1); err != ErrStopped {
+		t.Errorf("AllowE(1) after Stop() = %v, want %v", err, ErrStopped)
+	}
+}
+
+func TestWithCircuitBreaker_EveryPath(t *testing.T) {
+	clock := newFakeClock()
+	var open atomic.Bool
+	open.Store(true)
+	tb := NewTokenBucket(5, 1, 5, WithClock(clock), WithCircuitBreaker(open.Load)).(*TokenBucket)
+	defer tb.Stop()
+	lb := NewLeakyBucket(5, 1, WithClock(clock), WithCircuitBreaker(open.Load)).(*LeakyBucket)
+	defer lb.Stop()
+	adapter := NewRateAdapter(1, 5, WithClock(clock), WithCircuitBreaker(open.Load))
+	defer adapter.Stop()
+	// the leaky bucket starts full
+	clock.Advance(10 * time.Second)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := tb.Wait(ctx, 1); err != ErrCircuitOpen {
+		t.Errorf("Wait(1) with the breaker open = %v, want %v", err, ErrCircuitOpen)
+	}
+	ran := false
+	if err := tb.Do(ctx, 1, func() error { ran = true; return nil }); err != ErrCircuitOpen || ran {
+		t.Errorf("Do(1) with the breaker open = %v and ran %v, want %v without running", err, ran, ErrCircuitOpen)
+	}
+	if tb.AllowPriority(1, PriorityHigh) {
+		t.Error("AllowPriority(1, PriorityHigh) with the breaker open = true, want false")
+	}
+	if lb.AllowAt(clock.Now(), 1) {
+		t.Error("AllowAt(now, 1) with the breaker open = true, want false")
+	}
+	if adapter.AllowN(clock.Now(), 1) {
+		t.Error("RateAdapter.AllowN(now, 1) with the breaker open = true, want false")
+	}
+	if got := tb.Tokens(); got != 5 {
+		t.Errorf("Tokens() after requests denied by the breaker = %d, want 5", got)
+	}
+
+	open.Store(false)
+	if err := tb.Wait(ctx, 1); err != nil {
+		t.Errorf("Wait(1) with the breaker closed = %v, want nil", err)
+	}
+	if !lb.AllowAt(clock.Now(), 1) || !adapter.AllowN(clock.Now(), 1) {
+		t.Error("AllowAt and AllowN with the breaker closed, want both allowed")
+	}
+}
+
+func TestWithBurstMultiplier(t *testing.T) {
+	tests := []struct {
+		name         string
+		newRL        func(opts ...Option) RateLimiter
+		m            float64
+		wantCapacity int
+	}{
+		{"5 tokens per second times 2, expect a capacity of 10", func(opts ...Option) RateLimiter { return NewTokenBucket(1, 5, 1, opts...) }, 2, 10},
+		{"10 tokens per second times 1.2, expect a capacity of 12", func(opts ...Option) RateLimiter { return NewTokenBucket(1, 10, 1, opts...) }, 1.2, 12},
+		{"5 tokens per second times 1.1, expect a capacity of 6 (rounded up)", func(opts ...Option) RateLimiter { return NewTokenBucket(1, 5, 1, opts...) }, 1.1, 6},
+		{"3 tokens per second times 1, expect a capacity of 3", func(opts ...Option) RateLimiter { return NewTokenBucket(100, 3, 1, opts...) }, 1, 3},
+		{"120 tokens per minute times 2.5, expect a capacity of 5", func(opts ...Option) RateLimiter { return NewTokenBucketPerDuration(120, time.Minute, 1, opts...) }, 2.5, 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl := tt.newRL(WithClock(newFakeClock()), WithBurstMultiplier(tt.m))
+			defer rl.Stop()
+			if got := rl.(Introspector).Capacity(); got != tt.wantCapacity {
+				t.Errorf("Capacity() = %d, want %d", got, tt.wantCapacity)
+			}
+		})
+	}
+}
+
+func TestWithBurstMultiplier_Invalid(t *testing.T) {
+	for _, m := range []float64{0.5, 0, -1, math.NaN()} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("WithBurstMultiplier(%v) didn't panic", m)
+				}
+			}()
+			WithBurstMultiplier(m)
+		}()
+	}
+}
+
+func TestWithGraceBurst(t *testing.T) {
+	clock := newFakeClock()
+	// 5 tokens refilling at 1 a second, plus 3 grace tokens regenerating over an hour
+	rl := NewTokenBucket(5, 1, 5, WithClock(clock), WithGraceBurst(3, time.Hour)).(*TokenBucket)
+	defer rl.Stop()
+
+	tests := []struct {
+		name       string
+		advance    time.Duration
+		tokens     int
+		want       bool
+		wantTokens int
+		wantGrace  int
+	}{
+		{"Request 5 tokens, expect allowed from the bucket", 0, 5, true, 0, 3},
+		{"Request 2 tokens, expect allowed from the grace pool", 0, 2, true, 0, 1},
+		{"Request 2 tokens, expect denied (1 grace token left)", 0, 2, false, 0, 1},
+		{"Request 1 token, expect allowed from the grace pool", 0, 1, true, 0, 0},
+		{"Request 1 token, expect denied (both empty)", 0, 1, false, 0, 0},
+		{"Request 2 tokens after 1s, expect denied (the bucket refilled 1, grace none)", time.Second, 2, false, 1, 0},
+		{"Request 1 token, expect allowed from the bucket", 0, 1, true, 0, 0},
+		{"Request 6 tokens after 20m, expect allowed (bucket full, 1 grace token regenerated)", 20 * time.Minute, 6, true, 0, 0},
+		{"Request 1 token after 40m, expect allowed (2 grace tokens regenerated on top of the bucket)", 40 * time.Minute, 1, true, 4, 2},
+		{"Request 6 tokens, expect allowed across the bucket and the grace pool", 0, 6, true, 0, 0},
+	}
+
+	for _, tt := range tests {
+		clock.Advance(tt.advance)
+		if got := rl.Allow(tt.tokens); got != tt.want {
+			t.Errorf("%s: Allow(%d) = %v, want %v", tt.name, tt.tokens, got, tt.want)
+		}
+		if got := rl.Tokens(); got != tt.wantTokens {
+			t.Errorf("%s: Tokens() = %d, want %d", tt.name, got, tt.wantTokens)
+		}
+		if got := rl.GraceTokens(); got != tt.wantGrace {
+			t.Errorf("%s: GraceTokens() = %d, want %d", tt.name, got, tt.wantGrace)
+		}
+	}
+}
+
+func TestWithGraceBurst_NextAvailable(t *testing.T) {
+	clock := newFakeClock()
+	rl := NewTokenBucket(5, 1, 0, WithClock(clock), WithGraceBurst(3, time.Hour)).(*TokenBucket)
+	defer rl.Stop()
+	// use up the grace pool
+	rl.Allow(3)
+	start := clock.Now()
+
+	tests := []struct {
+		name   string
+		tokens int
+		want   time.Time
+	}{
+		{"Request 5 tokens, expect the bucket to refill in 5s", 5, start.Add(5 * time.Second)},
+		{"Request 6 tokens, expect the grace pool to regenerate a token in 20m", 6, start.Add(20 * time.Minute)},
+		{"Request 8 tokens, expect the grace pool to regenerate fully in 1h", 8, start.Add(time.Hour)},
+		{"Request 9 tokens, expect never (beyond the bucket and the grace pool)", 9, time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rl.NextAvailable(tt.tokens); !got.Equal(tt.want) {
+				t.Errorf("NextAvailable(%d) = %v, want %v", tt.tokens, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithRefillUnit(t *testing.T) {
+	tests := []struct {
+		name    string
+		unit    time.Duration
+		advance time.Duration
+		want    int
+	}{
+		{"5 tokens per minute after 11s, expect 0 tokens", time.Minute, 11 * time.Second, 0},
+		{"5 tokens per minute after 12s, expect 1 token", time.Minute, 12 * time.Second, 1},
+		{"5 tokens per minute after 1m, expect 5 tokens", time.Minute, time.Minute, 5},
+		{"5 tokens per 100ms after 19ms, expect 0 tokens", 100 * time.Millisecond, 19 * time.Millisecond, 0},
+		{"5 tokens per 100ms after 20ms, expect 1 token", 100 * time.Millisecond, 20 * time.Millisecond, 1},
+		{"5 tokens per 100ms after 1s, expect 10 tokens (capped)", 100 * time.Millisecond, time.Second, 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			rl := NewTokenBucket(10, 5, 0, WithClock(clock), WithRefillUnit(tt.unit))
+			defer rl.Stop()
+			clock.Advance(tt.advance)
+			if got := rl.(Introspector).Tokens(); got != tt.want {
+				t.Errorf("Tokens() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithRefillUnit_SetRate(t *testing.T) {
+	clock := newFakeClock()
+	rl := NewTokenBucket(10, 5, 0, WithClock(clock), WithRefillUnit(time.Minute))
+	defer rl.Stop()
+	rl.(*TokenBucket).SetRate(2)
+	clock.Advance(30 * time.Second)
+	if got := rl.(Introspector).Tokens(); got != 1 {
+		t.Errorf("Tokens() after SetRate(2) and 30s = %d, want 1", got)
+	}
+}
+
+func TestWithRefillUnit_Invalid(t *testing.T) {
+	for _, unit := range []time.Duration{0, -time.Second} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("WithRefillUnit(%v) didn't panic", unit)
+				}
+			}()
+			WithRefillUnit(unit)
+		}()
+	}
+}
+
+func TestWithSpinWait(t *testing.T) {
+	tests := []struct {
+		name        string
+		rate        int
+		spinWait    time.Duration
+		want        bool
+		minDuration time.Duration
+		maxDuration time.Duration
+	}{
+		{"Token due in 20ms with a 60ms spin window, expect allowed after about 20ms", 50, 60 * time.Millisecond, true, 10 * time.Millisecond, 50 * time.Millisecond},
+		{"Token due in 100ms with a 20ms spin window, expect denied after about 20ms", 10, 20 * time.Millisecond, false, 15 * time.Millisecond, 80 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl := NewTokenBucket(1, tt.rate, 0, WithSpinWait(tt.spinWait))
+			defer rl.Stop()
+
+			start := time.Now()
+			if got := rl.Allow(1); got != tt.want {
+				t.Errorf("Allow(1) = %v, want %v", got, tt.want)
+			}
+			if elapsed := time.Since(start); elapsed < tt.minDuration || elapsed > tt.maxDuration {
+				t.Errorf("Allow(1) returned after %v, want between %v and %v", elapsed, tt.minDuration, tt.maxDuration)
+			}
+		})
+	}
+}
+
+func TestWithSpinWait_NeverAvailable(t *testing.T) {
+	rl := NewTokenBucket(1, 1, 0, WithSpinWait(time.Second))
+	defer rl.Stop()
+
+	start := time.Now()
+	if rl.Allow(2) {
+		t.Errorf("Allow(2) beyond the capacity = true, want false")
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("Allow(2) beyond the capacity returned after %v, want it denied straight away", elapsed)
+	}
+}
+
+func TestWithPenalty(t *testing.T) {
+	clock := newFakeClock()
+	// a token every millisecond, so every denial after the first request of a round is down to the penalty
+	rl := NewTokenBucket(1, 1000, 1, WithClock(clock), WithPenalty(100*time.Millisecond, time.Second)).(*TokenBucket)
+	defer rl.Stop()
+
+	// each round waits quiet, takes the token, is denied straight after and then sits out the lockout
+	tests := []struct {
+		name         string
+		quiet        time.Duration
+		wantCooldown time.Duration
+	}{
+		{"First denial, expect a lockout of base", 0, 100 * time.Millisecond},
+		{"Second denial, expect the lockout doubled", 0, 200 * time.Millisecond},
+		{"Third denial, expect the lockout doubled again", 0, 400 * time.Millisecond},
+		{"Fourth denial, expect the lockout doubled again", 0, 800 * time.Millisecond},
+		{"Fifth denial, expect the lockout capped at max", 0, time.Second},
+		{"Denial after less than max of quiet, expect the lockout still capped", 999 * time.Millisecond, time.Second},
+		{"Denial after max of quiet, expect the lockout back at base", time.Second, 100 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock.Advance(tt.quiet)
+			if !rl.Allow(1) {
+				t.Fatalf("Allow(1) after the lockout = false, want true")
+			}
+			if rl.Allow(1) {
+				t.Fatalf("Allow(1) on an empty bucket = true, want false")
+			}
+			if got := rl.NextAvailable(1).Sub(clock.Now()); got != tt.wantCooldown {
+				t.Errorf("lockout = %v, want %v", got, tt.wantCooldown)
+			}
+			clock.Advance(tt.wantCooldown)
+		})
+	}
+}
+
+func TestWithPenalty_DeniesDuringLockout(t *testing.T) {
+	clock := newFakeClock()
+	rl := NewTokenBucket(5, 1000, 0, WithClock(clock), WithPenalty(100*time.Millisecond, time.Second)).(*TokenBucket)
+	defer rl.Stop()
+
+	if err := rl.AllowE(1); err != ErrRateLimited {
+		t.Fatalf("AllowE(1) on an empty bucket = %v, want %v", err, ErrRateLimited)
+	}
+	// the bucket has refilled, but the lockout runs until 100ms and the attempt extends it to 50ms+200ms
+	clock.Advance(50 * time.Millisecond)
+	if err := rl.AllowE(1); err != ErrRateLimited {
+		t.Errorf("AllowE(1) during the lockout = %v, want %v", err, ErrRateLimited)
+	}
+	clock.Advance(150 * time.Millisecond)
+	if rl.Allow(1) {
+		t.Errorf("Allow(1) during the extended lockout = true, want false")
+	}
+	if got := rl.Stats(); got.Allowed != 0 || got.Denied != 3 {
+		t.Errorf("Stats() = %+v, want 0 allowed and 3 denied", got)
+	}
+}
+
+func TestWithMaxStarvation(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      []Option
+		wantAfter int
+	}{
+		{"Request 5 among a stream of 1s by default, expect it starved", nil, -1},
+		// 3 denials to start the reservation, then 5 refills of a token each
+		{"Request 5 among a stream of 1s with a bound of 3, expect it admitted within 8 rounds", []Option{WithMaxStarvation(3)}, 8},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			// refills one token per round, which the small requests take straight away
+			rl := NewTokenBucket(10, 5, 0, append(tt.opts, WithClock(clock))...)
+			defer rl.Stop()
+
+			admittedAfter := -1
+			for round := 1; round <= 100 && admittedAfter < 0; round++ {
+				clock.Advance(200 * time.Millisecond)
+				rl.Allow(1)
+				if rl.Allow(5) {
+					admittedAfter = round
+				}
+			}
+			if admittedAfter != tt.wantAfter {
+				t.Errorf("large request admitted after %d rounds, want %d", admittedAfter, tt.wantAfter)
+			}
+		})
+	}
+}
+
+func TestWithMaxStarvation_OverCapacity(t *testing.T) {
+	clock := newFakeClock()
+	rl := NewTokenBucket(10, 5, 10, WithClock(clock), WithMaxStarvation(3))
+	defer rl.Stop()
+
+	// a request beyond the capacity can never be admitted, so nothing is reserved for it
+	for i := 0; i < 5; i++ {
+		if rl.Allow(100) {
+			t.Fatalf("Allow(100) #%d on a capacity of 10 = true, want false", i)
+		}
+	}
+	if !rl.Allow(1) {
+		t.Error("Allow(1) after repeated over-capacity requests = false, want true")
+	}
+}
+
+func TestWithMaxStarvation_Abandoned(t *testing.T) {
+	clock := newFakeClock()
+	// refilling the capacity of 10 at 5 a second takes 2s
+	rl := NewTokenBucket(10, 5, 0, WithClock(clock), WithMaxStarvation(3))
+	defer rl.Stop()
+
+	for i := 0; i < 3; i++ {
+		rl.Allow(5)
+	}
+	// the starved caller gives up, the reservation holds for a refill period and then lapses
+	clock.Advance(time.Second)
+	if rl.Allow(1) {
+		t.Error("Allow(1) 1s after the starved request = true, want false (reserved)")
+	}
+	clock.Advance(1100 * time.Millisecond)
+	if !rl.Allow(1) {
+		t.Error("Allow(1) 2.1s after the starved request was abandoned = false, want true")
+	}
+	if !rl.Allow(1) {
+		t.Error("Allow(1) after the reservation lapsed = false, want true")
+	}
+}
+
+func TestWithRetryAfterQuantum(t *testing.T) {
+	const quantum = 250 * time.Millisecond
+	clock := newFakeClock()
+	// a token every 333.33ms, which never lines up with the quantum
+	exact := NewTokenBucket(10, 3, 0, WithClock(clock)).(*TokenBucket)
+	defer exact.Stop()
+	rl := NewTokenBucket(10, 3, 0, WithClock(clock), WithRetryAfterQuantum(quantum)).(*TokenBucket)
+	defer rl.Stop()
+
+	for step := 0; step < 20; step++ {
+		for tokens := 1; tokens <= 10; tokens++ {
+			want := exact.NextAvailable(tokens).Sub(clock.Now())
+			got := rl.NextAvailable(tokens).Sub(clock.Now())
+			if got%quantum != 0 {
+				t.Errorf("NextAvailable(%d) waits %v, want a multiple of %v", tokens, got, quantum)
+			}
+			if got < want || got >= want+quantum {
+				t.Errorf("NextAvailable(%d) waits %v, want the exact %v rounded up", tokens, got, want)
+			}
+			if _, _, retryAfter := rl.Inspect(tokens); retryAfter != got {
+				t.Errorf("Inspect(%d) retryAfter = %v, want %v", tokens, retryAfter, got)
+			}
+		}
+		clock.Advance(70 * time.Millisecond)
+	}
+
+	// a request that could be admitted right away has nothing to round
+	clock.Advance(10 * time.Second)
+	if got := rl.NextAvailable(1); !got.Equal(clock.Now()) {
+		t.Errorf("NextAvailable(1) on a full bucket = %v, want %v", got, clock.Now())
+	}
+}
+
+func TestWithMetricsHook(t *testing.T) {
+	type datapoint struct {
+		allowed  bool
+		endpoint string
+	}
+	var got []datapoint
+	hook := func(allowed bool, tags map[string]string) {
+		got = append(got, datapoint{allowed, tags["endpoint"]})
+	}
+	rl := NewTokenBucket(2, 1, 2, WithClock(newFakeClock()), WithMetricsHook(hook)).(*TokenBucket)
+	defer rl.Stop()
+
+	rl.AllowTagged(1, map[string]string{"endpoint": "/login"})
+	rl.AllowTagged(1, map[string]string{"endpoint": "/search"})
+	rl.AllowTagged(1, map[string]string{"endpoint": "/search"})
+	rl.Allow(1)
+	rl.AllowE(1)
+
+	// the hook runs on the limiter's goroutine, Stop waits for it to finish before got is read
+	rl.Stop()
+	want := []datapoint{{true, "/login"}, {true, "/search"}, {false, "/search"}, {false, ""}, {false, ""}}
+	if len(got) != len(want) {
+		t.Fatalf("hook got %d datapoints, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("datapoint %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestWithBatchedMetrics(t *testing.T) {
+	var allowed, denied, flushes atomic.Uint64
+	hook := func(a, d uint64) {
+		allowed.Add(a)
+		denied.Add(d)
+		flushes.Add(1)
+	}
+	rl := NewTokenBucket(5000, 1, 5000, WithClock(newFakeClock()), WithBatchedMetrics(5*time.Millisecond, hook))
+	defer rl.Stop()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				rl.Allow(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// the flushes catch up with the decisions without waiting for Stop
+	deadline := time.Now().Add(time.Second)
+	for allowed.Load()+denied.Load() != 10000 {
+		if time.Now().After(deadline) {
+			t.Fatalf("hook got %d allowed and %d denied, want 10000 in total", allowed.Load(), denied.Load())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if allowed.Load() != 5000 || denied.Load() != 5000 {
+		t.Errorf("hook got %d allowed and %d denied, want 5000 each", allowed.Load(), denied.Load())
+	}
+	if n := flushes.Load(); n >= 10000 {
+		t.Errorf("hook called %d times, want fewer calls than decisions", n)
+	}
+
+	// the decisions made since the last flush are flushed by Stop
+	rl.Allow(1)
+	rl.Stop()
+	if denied.Load() != 5001 {
+		t.Errorf("hook got %d denied after Stop(), want 5001", denied.Load())
+	}
+}
+
+func TestWithOverloadThreshold(t *testing.T) {
+	clock := newFakeClock()
+	var reported []float64
+	rl := NewTokenBucket(10, 10, 10, WithClock(clock), WithOverloadThreshold(3, func(attemptedRate float64) {
+		reported = append(reported, attemptedRate)
+	}))
+	defer rl.Stop()
+
+	tests := []struct {
+		name         string
+		advance      time.Duration
+		requests     int
+		wantReported []float64
+	}{
+		{"Request 20 tokens in a second, expect throttled but not overloaded", 0, 20, nil},
+		{"Request 10 more tokens, expect exactly 3 times the rate, not overloaded", 0, 10, nil},
+		{"Request 1 more token, expect overloaded at 31 tokens per second", 0, 1, []float64{31}},
+		{"Request 50 more tokens, expect no more reports in the same window", 0, 50, []float64{31}},
+		{"Request 25 tokens in the next second, expect not overloaded", time.Second, 25, []float64{31}},
+		{"Request 100 tokens in the next second, expect overloaded again", time.Second, 100, []float64{31, 31}},
+	}
+
+	for _, tt := range tests {
+		clock.Advance(tt.advance)
+		for i := 0; i < tt.requests; i++ {
+			rl.Allow(1)
+		}
+		if !slices.Equal(reported, tt.wantReported) {
+			t.Errorf("%s: reported %v, want %v", tt.name, reported, tt.wantReported)
+		}
+	}
+}
+
+func TestWithOverloadThreshold_NoRate(t *testing.T) {
+	// an EventBucket has no sustained rate to be overloaded against
+	reported := 0
+	rl := NewEventBucket(5, WithClock(newFakeClock()), WithOverloadThreshold(3, func(float64) {
+		reported++
+	}))
+	defer rl.Stop()
+	for i := 0; i < 20; i++ {
+		rl.Allow(1)
+	}
+	if reported != 0 {
+		t.Errorf("EventBucket reported overloaded %d times, want 0", reported)
+	}
+}
+
+func TestWithOverloadThreshold_Invalid(t *testing.T) {
+	for _, factor := range []float64{0, -1, math.NaN()} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("WithOverloadThreshold(%v) didn't panic", factor)
+				}
+			}()
+			WithOverloadThreshold(factor, func(float64) {})
+		}()
+	}
+}
+
+func TestWithDryRun(t *testing.T) {
+	var decisions []bool
+	hook := func(allowed bool, tags map[string]string) {
+		decisions = append(decisions, allowed)
+	}
+	rl := NewTokenBucket(2, 1, 2, WithClock(newFakeClock()), WithMetricsHook(hook), WithDryRun(true)).(*TokenBucket)
+	defer rl.Stop()
+
+	tests := []struct {
+		name      string
+		wantHook  bool
+		wantStats Stats
+	}{
+		{"Request 1, expect allowed and recorded as allowed", true, Stats{Allowed: 1}},
+		{"Request 2, expect allowed and recorded as allowed", true, Stats{Allowed: 2}},
+		{"Request 3, expect allowed but recorded as denied", false, Stats{Allowed: 2, Denied: 1}},
+		{"Request 4, expect allowed but recorded as denied", false, Stats{Allowed: 2, Denied: 2}},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !rl.Allow(1) {
+				t.Errorf("Allow(1) = false, want true")
+			}
+			// Stats goes through the limiter's goroutine, so the hook has run by the time it returns
+			s := rl.Stats()
+			if s.Allowed != tt.wantStats.Allowed || s.Denied != tt.wantStats.Denied {
+				t.Errorf("Stats() = %d allowed, %d denied, want %d, %d", s.Allowed, s.Denied, tt.wantStats.Allowed, tt.wantStats.Denied)
+			}
+			if len(decisions) != i+1 || decisions[i] != tt.wantHook {
+				t.Errorf("hook decisions = %v, want %v last", decisions, tt.wantHook)
+			}
+		})
+	}
+
+	// an empty bucket doesn't block Wait in a dry run either
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := rl.Wait(ctx, 1); err != nil {
+		t.Errorf("Wait(1) = %v, want nil", err)
+	}
+	if got := rl.Stats().Denied; got != 3 {
+		t.Errorf("Stats().Denied after Wait = %d, want 3", got)
+	}
+}
+
+func TestAllowTagged_NoHook(t *testing.T) {
+	rl := NewTokenBucket(1_000_000, 1_000_000_000, 1_000_000)
+	defer rl.Stop()
+	tags := map[string]string{"endpoint": "/login"}
+
+	allocs := testing.AllocsPerRun(1000, func() {
+		rl.(*TokenBucket).AllowTagged(1, tags)
+	})
+	if allocs != 0 {
+		t.Errorf("AllowTagged without a hook allocates %v times per call, want 0", allocs)
+	}
+}
+
+func TestWithDenialSink(t *testing.T) {
+	clock := newFakeClock()
+	// the sink tolerates 3 denials, refilling one per second
+	sink := NewTokenBucket(3, 1, 3, WithClock(clock))
+	defer sink.Stop()
+	var exhausted atomic.Int32
+	rl := NewTokenBucket(2, 1, 2, WithClock(clock), WithDenialSink(sink, func() { exhausted.Add(1) }))
+	defer rl.Stop()
+
+	tests := []struct {
+		name          string
+		want          bool
+		wantExhausted int32
+	}{
+		{"Request 1, expect allowed", true, 0},
+		{"Request 2, expect allowed", true, 0},
+		{"Request 3, expect denied and fed to the sink", false, 0},
+		{"Request 4, expect denied and fed to the sink", false, 0},
+		{"Request 5, expect denied and the last denial the sink tolerates", false, 0},
+		{"Request 6, expect denied and the sink exhausted", false, 1},
+		{"Request 7, expect denied with the sink exhausted again", false, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rl.Allow(1); got != tt.want {
+				t.Errorf("Allow(1) = %v, want %v", got, tt.want)
+			}
+			if got := exhausted.Load(); got != tt.wantExhausted {
+				t.Errorf("onExhausted called %d times, want %d", got, tt.wantExhausted)
+			}
+		})
+	}
+}
+
+func TestWithShadow(t *testing.T) {
+	clock := newFakeClock()
+	// the candidate under test halves the real limit
+	candidate := NewTokenBucket(2, 1, 2, WithClock(clock))
+	defer candidate.Stop()
+	var divergences [][2]bool
+	rl := NewTokenBucket(4, 1, 4, WithClock(clock), WithShadow(candidate, func(real, shadow bool) {
+		divergences = append(divergences, [2]bool{real, shadow})
+	}))
+	defer rl.Stop()
+
+	tests := []struct {
+		name            string
+		want            bool
+		wantDivergences int
+	}{
+		{"Request 1, expect allowed by both", true, 0},
+		{"Request 2, expect allowed by both", true, 0},
+		{"Request 3, expect allowed while the shadow would deny", true, 1},
+		{"Request 4, expect allowed while the shadow would deny", true, 2},
+		{"Request 5, expect denied by both", false, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rl.Allow(1); got != tt.want {
+				t.Errorf("Allow(1) = %v, want %v", got, tt.want)
+			}
+			if got := len(divergences); got != tt.wantDivergences {
+				t.Errorf("onDivergence called %d times, want %d", got, tt.wantDivergences)
+			}
+		})
+	}
+
+	for i, d := range divergences {
+		if d != [2]bool{true, false} {
+			t.Errorf("divergence %d = (real %v, shadow %v), want (real true, shadow false)", i, d[0], d[1])
+		}
+	}
+}
+
+func TestWithoutGoroutine(t *testing.T) {
+	tests := []struct {
+		name  string
+		newRL func(opts ...Option) RateLimiter
+	}{
+		{"TokenBucket", func(opts ...Option) RateLimiter { return NewTokenBucket(10, 5, 10, opts...) }},
+		{"LeakyBucket", func(opts ...Option) RateLimiter { return NewLeakyBucket(10, 5, opts...) }},
+		{"FixedWindow", func(opts ...Option) RateLimiter { return NewFixedWindowDuration(500*time.Millisecond, 10, opts...) }},
+		{"SlidingWindow", func(opts ...Option) RateLimiter { return NewSlidingWindow(10, 500*time.Millisecond, opts...) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			want := tt.newRL(WithClock(clock))
+			defer want.Stop()
+			got := tt.newRL(WithClock(clock), WithoutGoroutine())
+
+			// both limiters see the same requests at the same times and have to decide them alike
+			r := rand.New(rand.NewSource(1))
+			for i := 0; i < 1000; i++ {
+				clock.Advance(time.Duration(r.Intn(100)) * time.Millisecond)
+				tokens := r.Intn(6) - 1
+				if g, w := got.Allow(tokens), want.Allow(tokens); g != w {
+					t.Fatalf("request %d: Allow(%d) = %v without the goroutine, want %v", i, tokens, g, w)
+				}
+				gotIn, wantIn := got.(Introspector), want.(Introspector)
+				if g, w := gotIn.Tokens(), wantIn.Tokens(); g != w {
+					t.Fatalf("request %d: Tokens() = %d without the goroutine, want %d", i, g, w)
+				}
+				if g, w := gotIn.NextReset(), wantIn.NextReset(); !g.Equal(w) {
+					t.Fatalf("request %d: NextReset() = %v without the goroutine, want %v", i, g, w)
+				}
+			}
+
+			got.Stop()
+			if got.Allow(1) {
+				t.Errorf("Allow(1) after Stop() = true, want false")
+			}
+		})
+	}
+}
+
+func TestWithoutGoroutine_NoGoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+	limiters := make([]RateLimiter, 100)
+	for i := range limiters {
+		limiters[i] = NewTokenBucket(10, 5, 10, WithoutGoroutine())
+	}
+	// other tests' goroutines may still be winding down, but 100 limiters with goroutines would stand out
+	if started := runtime.NumGoroutine() - before; started >= 50 {
+		t.Errorf("creating 100 limiters started %d goroutines, want none", started)
+	}
+
+	for _, rl := range limiters {
+		rl.Stop()
+	}
+
+	// concurrent callers share the mutex instead of the goroutine, the frozen clock admits exactly the capacity
+	rl := NewTokenBucket(50, 5, 50, WithClock(newFakeClock()), WithoutGoroutine())
+	defer rl.Stop()
+	var allowed atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if rl.Allow(1) {
+					allowed.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if got := allowed.Load(); got != 50 {
+		t.Errorf("concurrent callers were allowed %d tokens, want 50", got)
+	}
+}
+
+func TestWithCostScale(t *testing.T) {
+	scale := 1.0
+	rl := NewTokenBucket(10, 1, 10, WithClock(newFakeClock()), WithCostScale(func() float64 { return scale })).(*TokenBucket)
+	defer rl.Stop()
+
+	tests := []struct {
+		name       string
+		scale      float64
+		tokens     int
+		want       bool
+		wantTokens int
+	}{
+		{"Request 2 tokens at scale 1, expect a cost of 2", 1, 2, true, 8},
+		{"Request 2 tokens at scale 2, expect a cost of 4", 2, 2, true, 4},
+		{"Request 3 tokens at scale 2, expect a cost of 6 to be denied", 2, 3, false, 4},
+		{"Request 1 token at scale 1.5, expect a cost rounded up to 2", 1.5, 1, true, 2},
+		{"Request 2 tokens at scale 0, expect the scale ignored", 0, 2, true, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scale = tt.scale
+			if got := rl.Allow(tt.tokens); got != tt.want {
+				t.Errorf("Allow(%d) = %v, want %v", tt.tokens, got, tt.want)
+			}
+			if got := rl.Tokens(); got != tt.wantTokens {
+				t.Errorf("Tokens() = %d, want %d", got, tt.wantTokens)
+			}
+		})
+	}
+
+	// an unscaled request for the whole bucket fits, a doubled one never can
+	scale = 2
+	if got := rl.NextAvailable(6); !got.IsZero() {
+		t.Errorf("NextAvailable(6) at scale 2 = %v, want zero time", got)
+	}
+	if err := rl.Wait(context.Background(), 6); err != ErrNeverAvailable {
+		t.Errorf("Wait(6) at scale 2 = %v, want %v", err, ErrNeverAvailable)
+	}
+}
+
+func TestWithAdmitWhenExactlyFull(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []Option
+		fill     int
+		tokens   int
+		want     bool
+		wantFree int
+	}{
+		{"Request the last token by default, expect the bucket filled exactly", nil, 9, 1, true, 0},
+		{"Request the last token when admitting exactly full, expect the bucket filled exactly", []Option{WithAdmitWhenExactlyFull(true)}, 9, 1, true, 0},
+		{"Request the last token in strict mode, expect denial", []Option{WithAdmitWhenExactlyFull(false)}, 9, 1, false, 0},
+		{"Request one below the last token in strict mode, expect admission", []Option{WithAdmitWhenExactlyFull(false)}, 8, 1, true, 0},
+		{"Request the whole capacity in strict mode, expect denial", []Option{WithAdmitWhenExactlyFull(false)}, 0, 10, false, 9},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			rl := NewLeakyBucket(10, 1, append(tt.opts, WithClock(clock))...).(*LeakyBucket)
+			defer rl.Stop()
+			// a leaky bucket starts full
+			clock.Advance(10 * time.Second)
+			if tt.fill > 0 && !rl.Allow(tt.fill) {
+				t.Fatalf("Allow(%d) = false, want true", tt.fill)
+			}
+
+			if got := rl.Allow(tt.tokens); got != tt.want {
+				t.Errorf("Allow(%d) = %v, want %v", tt.tokens, got, tt.want)
+			}
+			if got := rl.Tokens(); got != tt.wantFree {
+				t.Errorf("Tokens() = %d, want %d", got, tt.wantFree)
+			}
+		})
+	}
+}
+
+func TestWithAdmitWhenExactlyFull_NextAvailable(t *testing.T) {
+	clock := newFakeClock()
+	rl := NewLeakyBucket(10, 1, WithClock(clock), WithAdmitWhenExactlyFull(false)).(*LeakyBucket)
+	defer rl.Stop()
+	clock.Advance(10 * time.Second)
+
+	if !rl.Allow(9) {
+		t.Fatalf("Allow(9) = false, want true")
+	}
+	// the bucket has to drain one token below the level that would fill it exactly
+	if got, want := rl.NextAvailable(1), clock.Now().Add(time.Second); !got.Equal(want) {
+		t.Errorf("NextAvailable(1) = %v, want %v", got, want)
+	}
+	if got := rl.NextAvailable(10); !got.IsZero() {
+		t.Errorf("NextAvailable(10) = %v, want zero time", got)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// overloadWindow is how long the attempted rate is measured over for WithOverloadThreshold
+const overloadWindow = time.Second
+
+// overload measures the attempted rate WithOverloadThreshold watches, it's only touched from the limiter's
+// goroutine
+type overload struct {
+	// attempted counts the tokens requested, admitted or not, in the window starting at start
+	attempted int
+	start     time.Time
+	// reported is set once the window has fired the callback, so it fires at most once per window
+	reported bool
+}
+
+// trackOverload counts a request for tokens towards the attempted rate and calls the WithOverloadThreshold
+// callback the first time in a window that the rate goes over the threshold. Limiters without a positive, finite
+// sustained rate, such as a BaselineLimiter or an EventBucket, are never overloaded
+func (rlb *RateLimiterBase) trackOverload(currentTime time.Time, tokens int) {
+	if rlb.onOverload == nil {
+		return
+	}
+	o := &rlb.overload
+	if currentTime.Sub(o.start) >= overloadWindow {
+		*o = overload{start: currentTime}
+	}
+	o.attempted = saturatingAdd(o.attempted, tokens)
+	limit := rlb.overloadFactor * rlb.algo.sustainedRate()
+	attemptedRate := perSecond(float64(o.attempted), overloadWindow)
+	if o.reported || !(limit > 0) || math.IsInf(limit, 1) || attemptedRate <= limit {
+		return
+	}
+	o.reported = true
+	rlb.onOverload(attemptedRate)
+}
@@ -0,0 +1,42 @@
+package main
+
+import "time"
+
+// penalty tracks the lockout of WithPenalty, it's only touched from the limiter's goroutine
+type penalty struct {
+	// strikes counts the denials since the limiter was last quiet
+	strikes int
+	// until is when the current lockout ends
+	until time.Time
+}
+
+// locked reports whether the WithPenalty lockout is still running at currentTime
+func (rlb *RateLimiterBase) locked(currentTime time.Time) bool {
+	return rlb.penaltyBase > 0 && currentTime.Before(rlb.penalty.until)
+}
+
+// penalize extends the lockout after a denial at currentTime. The strikes start over once no request has been
+// denied for penaltyMax after the last lockout ended
+func (rlb *RateLimiterBase) penalize(currentTime time.Time) {
+	if rlb.penaltyBase <= 0 {
+		return
+	}
+	p := &rlb.penalty
+	if currentTime.Sub(p.until) >= rlb.penaltyMax {
+		p.strikes = 0
+	}
+	p.strikes++
+	p.until = currentTime.Add(penaltyCooldown(rlb.penaltyBase, rlb.penaltyMax, p.strikes))
+}
+
+// penaltyCooldown returns base doubled for every strike after the first, capped at maxCooldown
+func penaltyCooldown(base, maxCooldown time.Duration, strikes int) time.Duration {
+	cooldown := base
+	for i := 1; i < strikes; i++ {
+		if cooldown > maxCooldown/2 {
+			return maxCooldown
+		}
+		cooldown *= 2
+	}
+	return min(cooldown, maxCooldown)
+}
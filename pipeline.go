@@ -0,0 +1,51 @@
+package main
+
+import "sync"
+
+// Request is a request for tokens sent into a Pipeline
+type Request struct {
+	Tokens int
+}
+
+// Pipeline decouples producers from a limiter for streaming pipelines: requests sent into In are decided one at a
+// time by a worker, in the order they were sent, and each decision comes out of Out as a Result in that same order
+type Pipeline struct {
+	In  chan<- Request
+	Out <-chan Result
+
+	in       chan Request
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewPipeline creates a Pipeline deciding requests through rl, with In and Out each buffering up to buffer
+// requests and results. Limiters with AllowDetailed, like those of this package, fill in the whole Result, any
+// other only Allowed
+func NewPipeline(rl RateLimiter, buffer int) *Pipeline {
+	in := make(chan Request, max(buffer, 0))
+	out := make(chan Result, max(buffer, 0))
+	p := &Pipeline{In: in, Out: out, in: in, done: make(chan struct{})}
+
+	allow := func(tokens int) Result { return Result{Allowed: rl.Allow(tokens)} }
+	if d, ok := rl.(interface{ AllowDetailed(int) Result }); ok {
+		allow = d.AllowDetailed
+	}
+	go func() {
+		defer close(p.done)
+		defer close(out)
+		for req := range in {
+			out <- allow(req.Tokens)
+		}
+	}()
+	return p
+}
+
+// Stop closes In, waits for the worker to decide the requests still buffered and then closes Out. Nothing may be
+// sent into In once Stop is called, and Out has to keep being read until it's closed for Stop to return. It
+// doesn't stop the limiter
+func (p *Pipeline) Stop() {
+	p.stopOnce.Do(func() {
+		close(p.in)
+	})
+	<-p.done
+}
@@ -0,0 +1,60 @@
+package main
+
+import "testing"
+
+func TestPipeline(t *testing.T) {
+	rl := NewTokenBucket(5, 1, 5, WithClock(newFakeClock()))
+	defer rl.Stop()
+	p := NewPipeline(rl, 4)
+
+	tests := []struct {
+		name          string
+		tokens        int
+		want          bool
+		wantRemaining int
+	}{
+		{"Request 2, expect allowed with 3 remaining", 2, true, 3},
+		{"Request 4, expect denied with 3 remaining", 4, false, 3},
+		{"Request 3, expect allowed with 0 remaining", 3, true, 0},
+		{"Request 1, expect denied with the bucket empty", 1, false, 0},
+		{"Request 0, expect denied as invalid", 0, false, 0},
+	}
+
+	// the producer feeds every request before any result is read, Stop then drains the rest
+	go func() {
+		for _, tt := range tests {
+			p.In <- Request{Tokens: tt.tokens}
+		}
+		p.Stop()
+	}()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, ok := <-p.Out
+			if !ok {
+				t.Fatalf("Out closed early")
+			}
+			if res.Allowed != tt.want || res.RemainingTokens != tt.wantRemaining {
+				t.Errorf("Result = %+v, want Allowed %v with %d remaining", res, tt.want, tt.wantRemaining)
+			}
+		})
+	}
+	if res, ok := <-p.Out; ok {
+		t.Errorf("Out yielded %+v after every request, want it closed", res)
+	}
+}
+
+func TestPipeline_WithoutAllowDetailed(t *testing.T) {
+	p := NewPipeline(stubLimiter{allow: true}, 0)
+	go func() {
+		p.In <- Request{Tokens: 1}
+		p.Stop()
+	}()
+
+	if res := <-p.Out; !res.Allowed {
+		t.Errorf("Result = %+v, want Allowed", res)
+	}
+	if _, ok := <-p.Out; ok {
+		t.Errorf("Out still open after Stop")
+	}
+}
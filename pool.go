@@ -0,0 +1,51 @@
+package main
+
+import "sync"
+
+// Pool shares one limiter between every user of the same Config, so limiters created for the same resource in
+// different parts of an app share a single budget instead of each granting the full limit
+type Pool struct {
+	mu       sync.Mutex
+	limiters map[Config]RateLimiter
+	opts     []Option
+}
+
+// NewPool creates an empty Pool whose limiters are built with opts
+func NewPool(opts ...Option) *Pool {
+	return &Pool{limiters: map[Config]RateLimiter{}, opts: opts}
+}
+
+// GetOrCreate returns the pool's limiter for cfg, building it with FromConfig the first time cfg is asked for.
+// It returns FromConfig's error for a Config that can't be built
+func (p *Pool) GetOrCreate(cfg Config) (RateLimiter, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if rl, ok := p.limiters[cfg]; ok {
+		return rl, nil
+	}
+	rl, err := FromConfig(cfg, p.opts...)
+	if err != nil {
+		return nil, err
+	}
+	p.limiters[cfg] = rl
+	return rl, nil
+}
+
+// Len returns how many distinct limiters the pool holds
+func (p *Pool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.limiters)
+}
+
+// Stop stops every limiter in the pool and empties it, later calls to GetOrCreate build new limiters
+func (p *Pool) Stop() {
+	p.mu.Lock()
+	limiters := p.limiters
+	p.limiters = map[Config]RateLimiter{}
+	p.mu.Unlock()
+
+	for _, rl := range limiters {
+		rl.Stop()
+	}
+}
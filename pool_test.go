@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPool_GetOrCreate(t *testing.T) {
+	p := NewPool(WithClock(newFakeClock()))
+	defer p.Stop()
+
+	api := Config{Algorithm: "token_bucket", Capacity: 10, Rate: 5}
+	first, err := p.GetOrCreate(api)
+	if err != nil {
+		t.Fatalf("GetOrCreate(%+v) error = %v", api, err)
+	}
+	second, _ := p.GetOrCreate(Config{Algorithm: "token_bucket", Capacity: 10, Rate: 5})
+	if first != second {
+		t.Errorf("GetOrCreate returned different limiters for the same config")
+	}
+
+	// the shared limiter has a single budget
+	if !first.Allow(6) || second.Allow(6) {
+		t.Errorf("Allow(6) on both handles should be allowed once, the budget of 10 is shared")
+	}
+
+	others := []Config{
+		{Algorithm: "token_bucket", Capacity: 10, Rate: 6},
+		{Algorithm: "sliding_window", Capacity: 10, Window: time.Second},
+	}
+	for _, cfg := range others {
+		rl, err := p.GetOrCreate(cfg)
+		if err != nil {
+			t.Fatalf("GetOrCreate(%+v) error = %v", cfg, err)
+		}
+		if rl == first {
+			t.Errorf("GetOrCreate(%+v) returned the limiter of %+v", cfg, api)
+		}
+	}
+	if got := p.Len(); got != 3 {
+		t.Errorf("Len() = %d, want 3", got)
+	}
+
+	if _, err := p.GetOrCreate(Config{Algorithm: "gcra"}); !errors.Is(err, ErrUnknownAlgorithm) {
+		t.Errorf("GetOrCreate of an unknown algorithm error = %v, want %v", err, ErrUnknownAlgorithm)
+	}
+
+	p.Stop()
+	if first.Allow(1) {
+		t.Errorf("Allow(1) after Pool.Stop() = true, want false")
+	}
+	if got := p.Len(); got != 0 {
+		t.Errorf("Len() after Stop() = %d, want 0", got)
+	}
+}
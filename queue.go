@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// ErrQueueFull is returned by BoundedQueue.Submit when the queue is already at its maximum depth
+var ErrQueueFull = errors.New("ratelimitters: queue full")
+
+// BoundedQueue blocks callers until their tokens are admitted by a base limiter like Wait does, but only up to a
+// maximum wait and with at most a maximum number of callers queued, so it fails fast when overwhelmed instead of
+// piling up blocked goroutines
+type BoundedQueue struct {
+	base     RateLimiter
+	maxWait  time.Duration
+	maxDepth int64
+	depth    atomic.Int64
+}
+
+// NewBoundedQueue creates a BoundedQueue in front of base that waits up to maxWait for admission and queues at
+// most maxDepth callers at a time
+func NewBoundedQueue(base RateLimiter, maxWait time.Duration, maxDepth int) *BoundedQueue {
+	return &BoundedQueue{base: base, maxWait: maxWait, maxDepth: int64(maxDepth)}
+}
+
+// Submit blocks until tokens are admitted by the base limiter and returns nil, or returns ErrQueueFull straight
+// away if maxDepth callers are already queued and context.DeadlineExceeded once maxWait has passed. It returns
+// Wait's other errors as they are. A base limiter without a Wait method, unlike those of this package, is asked
+// once through Allow and denials are reported as ErrRateLimited
+func (q *BoundedQueue) Submit(tokens int) error {
+	if !q.acquire() {
+		return ErrQueueFull
+	}
+	defer q.release()
+	ctx, cancel := context.WithTimeout(context.Background(), q.maxWait)
+	defer cancel()
+	return q.wait(ctx, tokens)
+}
+
+// Enqueue is Submit without blocking, it queues the request and returns a Ticket to wait on or cancel it with
+func (q *BoundedQueue) Enqueue(tokens int) *Ticket {
+	t := &Ticket{done: make(chan struct{})}
+	if !q.acquire() {
+		t.err, t.cancel = ErrQueueFull, func() {}
+		close(t.done)
+		return t
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), q.maxWait)
+	t.cancel = cancel
+	go func() {
+		// the slot is freed before done is closed, so it's back by the time Wait or Cancel return
+		defer close(t.done)
+		defer q.release()
+		defer cancel()
+		t.err = q.wait(ctx, tokens)
+	}()
+	return t
+}
+
+// acquire takes a slot in the queue, it reports false if maxDepth callers are already queued
+func (q *BoundedQueue) acquire() bool {
+	if q.depth.Add(1) > q.maxDepth {
+		q.depth.Add(-1)
+		return false
+	}
+	return true
+}
+
+func (q *BoundedQueue) release() {
+	q.depth.Add(-1)
+}
+
+func (q *BoundedQueue) wait(ctx context.Context, tokens int) error {
+	w, ok := q.base.(interface {
+		Wait(ctx context.Context, tokens int) error
+	})
+	if !ok {
+		if !q.base.Allow(tokens) {
+			return ErrRateLimited
+		}
+		return nil
+	}
+	return w.Wait(ctx, tokens)
+}
+
+// Allow is Submit reporting only whether the tokens were admitted, so a BoundedQueue can stand in for a RateLimiter
+func (q *BoundedQueue) Allow(tokens int) bool {
+	return q.Submit(tokens) == nil
+}
+
+// Stop stops the base limiter, callers still queued are released with ErrStopped
+func (q *BoundedQueue) Stop() {
+	q.base.Stop()
+}
+
+// Ticket is a request queued by BoundedQueue.Enqueue, which its caller can wait on or abandon
+type Ticket struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	// err is Submit's error for the request, it's set before done is closed
+	err error
+}
+
+// Wait blocks until the request is decided and reports whether its tokens were admitted
+func (t *Ticket) Wait() bool {
+	return t.Err() == nil
+}
+
+// Err blocks until the request is decided and returns the error Submit would have, context.Canceled if the ticket
+// was cancelled
+func (t *Ticket) Err() error {
+	<-t.done
+	return t.err
+}
+
+// Cancel gives up the request's place in line and returns once its queue slot is free again. It has no effect on
+// a request already decided, whose tokens stay admitted
+func (t *Ticket) Cancel() {
+	t.cancel()
+	<-t.done
+}
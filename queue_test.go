@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBoundedQueue_Full(t *testing.T) {
+	// the clock never moves, so the empty bucket keeps every caller queued until Stop
+	base := NewTokenBucket(1, 1, 0, WithClock(newFakeClock()))
+	q := NewBoundedQueue(base, time.Minute, 2)
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() { errs <- q.Submit(1) }()
+	}
+	deadline := time.Now().Add(time.Second)
+	for q.depth.Load() != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d callers queued, want 2", q.depth.Load())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	start := time.Now()
+	if err := q.Submit(1); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Submit(1) with 2 callers queued = %v, want %v", err, ErrQueueFull)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("Submit(1) on a full queue took %v, want it to fail fast", elapsed)
+	}
+
+	q.Stop()
+	for i := 0; i < 2; i++ {
+		if err := <-errs; !errors.Is(err, ErrStopped) {
+			t.Errorf("queued Submit(1) after Stop() = %v, want %v", err, ErrStopped)
+		}
+	}
+	if got := q.depth.Load(); got != 0 {
+		t.Errorf("%d callers queued after they were released, want 0", got)
+	}
+}
+
+func TestBoundedQueue_MaxWait(t *testing.T) {
+	base := NewTokenBucket(2, 1, 2, WithClock(newFakeClock()))
+	q := NewBoundedQueue(base, 30*time.Millisecond, 10)
+	defer q.Stop()
+
+	tests := []struct {
+		name    string
+		tokens  int
+		wantErr error
+	}{
+		{"Request 2 of 2 tokens, expect admitted straight away", 2, nil},
+		{"Request 1 token from the empty bucket, expect the max wait to run out", 1, context.DeadlineExceeded},
+		{"Request 3 tokens beyond the capacity, expect ErrNeverAvailable", 3, ErrNeverAvailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := q.Submit(tt.tokens); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Submit(%d) = %v, want %v", tt.tokens, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBoundedQueue_Enqueue(t *testing.T) {
+	// the bucket stays empty until the clock is advanced, so the tickets stay queued until then
+	clock := newFakeClock()
+	base := NewTokenBucket(3, 1, 0, WithClock(clock))
+	q := NewBoundedQueue(base, time.Minute, 3)
+	defer q.Stop()
+
+	tickets := []*Ticket{q.Enqueue(1), q.Enqueue(1), q.Enqueue(1)}
+	if err := q.Enqueue(1).Err(); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Enqueue(1) with 3 tickets queued, Err() = %v, want %v", err, ErrQueueFull)
+	}
+
+	tickets[1].Cancel()
+	if err := tickets[1].Err(); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled ticket Err() = %v, want %v", err, context.Canceled)
+	}
+	if got := q.depth.Load(); got != 2 {
+		t.Errorf("%d callers queued after Cancel(), want 2", got)
+	}
+	// the cancelled ticket's slot is free for another
+	tickets[1] = q.Enqueue(1)
+	if got := q.depth.Load(); got != 3 {
+		t.Errorf("%d callers queued after reusing the slot, want 3", got)
+	}
+
+	clock.Advance(time.Hour)
+	for i, ticket := range tickets {
+		if !ticket.Wait() {
+			t.Errorf("ticket #%d Wait() = false, want true (%v)", i, ticket.Err())
+		}
+	}
+	if got := base.(*TokenBucket).Tokens(); got != 0 {
+		t.Errorf("base Tokens() = %d, want 0 (the cancelled ticket took none)", got)
+	}
+	if got := q.depth.Load(); got != 0 {
+		t.Errorf("%d callers queued after every ticket was decided, want 0", got)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// QuotaLimited puts an absolute daily quota, such as a million requests a day, on top of a rate limiter smoothing
+// the traffic within the day. Once the quota is used up every request is denied until it resets
+type QuotaLimited struct {
+	mu    sync.Mutex
+	rate  RateLimiter
+	quota int
+	clock Clock
+	// resetAt is the time of day the quota starts over, as an offset from midnight
+	resetAt time.Duration
+	// used is the tokens admitted since the last reset, which happens at periodEnd
+	used      int
+	periodEnd time.Time
+}
+
+// NewQuotaLimited creates a QuotaLimited admitting up to dailyQuota tokens a day through rate. Of the options only
+// WithClock and WithQuotaReset apply, WithClock should be the clock rate reads
+func NewQuotaLimited(rate RateLimiter, dailyQuota int, opts ...Option) *QuotaLimited {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.clock == nil {
+		o.clock = realClock{}
+	}
+	return &QuotaLimited{rate: rate, quota: dailyQuota, clock: o.clock, resetAt: o.quotaReset}
+}
+
+// Allow reports whether a request of tokens fits both the quota left for the day and the rate limiter. A request
+// the quota denies doesn't take any tokens from the rate limiter
+func (q *QuotaLimited) Allow(tokens int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.roll(q.clock.Now())
+	if tokens <= 0 || tokens > q.quota-q.used || !q.rate.Allow(tokens) {
+		return false
+	}
+	q.used += tokens
+	return true
+}
+
+// Remaining returns the tokens left in the day's quota
+func (q *QuotaLimited) Remaining() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.roll(q.clock.Now())
+	return max(q.quota-q.used, 0)
+}
+
+// NextReset returns when the quota starts over next
+func (q *QuotaLimited) NextReset() time.Time {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.roll(q.clock.Now())
+	return q.periodEnd
+}
+
+// Stop stops the rate limiter
+func (q *QuotaLimited) Stop() {
+	q.rate.Stop()
+}
+
+// roll starts the quota over if its period has ended by now
+func (q *QuotaLimited) roll(now time.Time) {
+	if now.Before(q.periodEnd) {
+		return
+	}
+	q.used = 0
+	year, month, day := now.Date()
+	q.periodEnd = time.Date(year, month, day, 0, 0, 0, 0, now.Location()).Add(q.resetAt)
+	if !q.periodEnd.After(now) {
+		q.periodEnd = time.Date(year, month, day+1, 0, 0, 0, 0, now.Location()).Add(q.resetAt)
+	}
+}
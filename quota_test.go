@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuotaLimited(t *testing.T) {
+	// the fake clock starts at midnight, the quota resets at 6 in the morning
+	clock := newFakeClock()
+	q := NewQuotaLimited(NewTokenBucket(2, 2, 2, WithClock(clock)), 5, WithClock(clock), WithQuotaReset(6*time.Hour))
+	defer q.Stop()
+
+	tests := []struct {
+		name    string
+		advance time.Duration
+		tokens  int
+		want    bool
+	}{
+		{"Request 2 tokens, expect allowed", 0, 2, true},
+		{"Request 1 token right after, expect denied by the rate", 0, 1, false},
+		{"Request 2 tokens after 1s, expect allowed", time.Second, 2, true},
+		{"Request 2 tokens after 1s, expect denied by the quota (1 left)", time.Second, 2, false},
+		{"Request 1 token, expect allowed (quota used up)", 0, 1, true},
+		{"Request 1 token after 1h, expect denied by the quota", time.Hour, 1, false},
+		{"Request 1 token just before the reset, expect denied", 6*time.Hour - time.Hour - 2*time.Second - time.Nanosecond, 1, false},
+		{"Request 2 tokens at the reset, expect allowed", time.Nanosecond, 2, true},
+		{"Request 0 tokens, expect denied", time.Second, 0, false},
+	}
+
+	for _, tt := range tests {
+		clock.Advance(tt.advance)
+		if got := q.Allow(tt.tokens); got != tt.want {
+			t.Errorf("%s: Allow(%d) = %v, want %v", tt.name, tt.tokens, got, tt.want)
+		}
+	}
+	if got := q.Remaining(); got != 3 {
+		t.Errorf("Remaining() = %d, want 3", got)
+	}
+	if got, want := q.NextReset(), time.Date(2024, time.January, 2, 6, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("NextReset() = %v, want %v", got, want)
+	}
+}
+
+func TestQuotaLimited_QuotaDenialKeepsRate(t *testing.T) {
+	clock := newFakeClock()
+	rate := NewTokenBucket(10, 1, 10, WithClock(clock)).(*TokenBucket)
+	q := NewQuotaLimited(rate, 3, WithClock(clock))
+	defer q.Stop()
+
+	for i := 0; i < 5; i++ {
+		q.Allow(1)
+	}
+	if got := rate.Tokens(); got != 7 {
+		t.Errorf("rate Tokens() after exhausting the quota = %d, want 7", got)
+	}
+	// the default reset is midnight
+	clock.Advance(24 * time.Hour)
+	if !q.Allow(1) {
+		t.Error("Allow(1) after midnight = false, want true")
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"log"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// RateAdapter exposes a TokenBucket through the method set of golang.org/x/time/rate.Limiter, so code written
+// against x/time/rate can switch to this package by changing the constructor only. Unlike x/time/rate, requests
+// for zero or fewer tokens are never admitted
+type RateAdapter struct {
+	tb *TokenBucket
+	// outstanding counts the reservations neither cancelled nor consumed, with WithLeakDetection only
+	outstanding atomic.Int64
+}
+
+// NewRateAdapter creates a RateAdapter admitting tokensPerSecond events per second with bursts of up to burst
+// events, which starts full like rate.NewLimiter
+func NewRateAdapter(tokensPerSecond, burst int, opts ...Option) *RateAdapter {
+	return &RateAdapter{tb: newTokenBucket(burst, tokensPerSecond, time.Second, burst, opts)}
+}
+
+// Allow is AllowN(time.Now(), 1), with the time read from the adapter's clock
+func (a *RateAdapter) Allow() bool {
+	return a.tb.Allow(1)
+}
+
+// AllowN reports whether n events may happen at t and takes their tokens if so
+func (a *RateAdapter) AllowN(t time.Time, n int) bool {
+	if n <= 0 {
+		return false
+	}
+	allowed := false
+	a.tb.exec(func() {
+		allowed = a.tb.admit(t, n, nil) == nil
+	})
+	return allowed
+}
+
+// Wait is WaitN(ctx, 1)
+func (a *RateAdapter) Wait(ctx context.Context) error {
+	return a.tb.Wait(ctx, 1)
+}
+
+// WaitN blocks until n events are admitted, see TokenBucket.Wait
+func (a *RateAdapter) WaitN(ctx context.Context, n int) error {
+	return a.tb.Wait(ctx, n)
+}
+
+// Reserve is ReserveN(time.Now(), 1), with the time read from the adapter's clock
+func (a *RateAdapter) Reserve() *Reservation {
+	return a.ReserveN(a.tb.clock.Now(), 1)
+}
+
+// ReserveN takes n tokens at t, borrowing them from future refills if the bucket doesn't hold enough yet, and
+// returns a Reservation telling how long to wait before acting. The Reservation isn't OK if n tokens can never
+// be admitted or the limiter has been stopped
+func (a *RateAdapter) ReserveN(t time.Time, n int) *Reservation {
+	r := &Reservation{adapter: a, tokens: n}
+	if n <= 0 {
+		return r
+	}
+	a.tb.exec(func() {
+		r.timeToAct, r.borrowed, r.ok = a.tb.reserve(t, n)
+	})
+	if r.ok && a.tb.leakDetection {
+		a.outstanding.Add(1)
+		runtime.SetFinalizer(r, func(r *Reservation) {
+			if !r.finalized.Load() {
+				log.Printf("ratelimitters: a reservation of %d tokens was garbage collected without Cancel or Consume", r.tokens)
+			}
+		})
+	}
+	return r
+}
+
+// OutstandingReservations returns how many OK reservations haven't been cancelled or consumed yet, including
+// leaked ones that were garbage collected. It's only tracked with WithLeakDetection and is 0 otherwise
+func (a *RateAdapter) OutstandingReservations() int {
+	return int(a.outstanding.Load())
+}
+
+// Stop stops the underlying TokenBucket
+func (a *RateAdapter) Stop() {
+	a.tb.Stop()
+}
+
+// reserve takes tokens at currentTime and returns when they're actually available and how many of them were
+// borrowed, an empty bucket borrows the missing tokens from future refills by moving lastTime past the point
+// they accrue
+func (rl *TokenBucket) reserve(currentTime time.Time, tokens int) (time.Time, int, bool) {
+	next := rl.nextAvailable(currentTime, tokens)
+	if next.IsZero() {
+		return next, 0, false
+	}
+	rl.tokens, rl.lastTime = rl.refilled(currentTime)
+	usable := max(rl.tokens-rl.reservedFor(PriorityLow), 0)
+	if tokens <= usable {
+		rl.tokens -= tokens
+		return currentTime, 0, rl.record(true)
+	}
+	rl.tokens -= usable
+	rl.lastTime = next
+	return next, tokens - usable, rl.record(true)
+}
+
+// unreserve hands back the tokens of a reservation that was cancelled before its time to act, paying back the
+// borrowed ones by moving lastTime back
+func (rl *TokenBucket) unreserve(currentTime time.Time, tokens, borrowed int) {
+	rl.tokens, rl.lastTime = rl.refilled(currentTime)
+	if borrowed > 0 {
+		rl.lastTime = rl.lastTime.Add(-rl.refillDuration(borrowed))
+	}
+	rl.tokens = min(rl.tokens+tokens-borrowed, rl.capacity)
+}
+
+// Reservation holds tokens taken by RateAdapter.ReserveN, the caller has to wait Delay before acting on them
+// and then call Consume, or call Cancel if it doesn't act after all
+type Reservation struct {
+	ok        bool
+	timeToAct time.Time
+	tokens    int
+	borrowed  int
+	adapter   *RateAdapter
+	finalized atomic.Bool
+}
+
+// OK reports whether the tokens were reserved, callers must not act on a Reservation that isn't OK
+func (r *Reservation) OK() bool {
+	return r.ok
+}
+
+// Delay is DelayFrom(time.Now()), with the time read from the adapter's clock
+func (r *Reservation) Delay() time.Duration {
+	return r.DelayFrom(r.adapter.tb.clock.Now())
+}
+
+// DelayFrom returns how long after t the reserved tokens become available, 0 if they already are. A Reservation
+// that isn't OK never becomes available and returns InfDuration, like x/time/rate
+func (r *Reservation) DelayFrom(t time.Time) time.Duration {
+	if !r.ok {
+		return InfDuration
+	}
+	return max(r.timeToAct.Sub(t), 0)
+}
+
+// Cancel gives the reserved tokens back to the bucket, as far as they aren't due yet, for callers that won't
+// act on the reservation after all. Calling it again, or after Consume, does nothing
+func (r *Reservation) Cancel() {
+	if !r.ok || !r.finalize() {
+		return
+	}
+	tb := r.adapter.tb
+	tb.exec(func() {
+		currentTime := tb.now()
+		if r.timeToAct.Before(currentTime) {
+			return
+		}
+		tb.unreserve(currentTime, r.tokens, r.borrowed)
+	})
+}
+
+// Consume marks the reservation as acted on, which only matters to WithLeakDetection
+func (r *Reservation) Consume() {
+	if r.ok {
+		r.finalize()
+	}
+}
+
+// finalize takes the reservation off the outstanding ones, it reports false if it already was
+func (r *Reservation) finalize() bool {
+	if r.finalized.Swap(true) {
+		return false
+	}
+	if r.adapter.tb.leakDetection {
+		r.adapter.outstanding.Add(-1)
+	}
+	return true
+}
+
+// InfDuration is the delay of a Reservation that isn't OK
+const InfDuration = time.Duration(1<<63 - 1)
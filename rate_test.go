@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// The expectations below are what golang.org/x/time/rate.NewLimiter(5, 10) decides for the same calls, the
+// dependency isn't pulled in just to compare against it
+
+func TestRateAdapter_AllowN(t *testing.T) {
+	clock := newFakeClock()
+	start := clock.Now()
+	a := NewRateAdapter(5, 10, WithClock(clock))
+	defer a.Stop()
+
+	tests := []struct {
+		name string
+		at   time.Duration
+		n    int
+		want bool
+	}{
+		{"10 events at the start, expect allowed (burst of 10)", 0, 10, true},
+		{"1 event at the start, expect denied (burst spent)", 0, 1, false},
+		{"1 event at 100ms, expect denied (half a token accrued)", 100 * time.Millisecond, 1, false},
+		{"1 event at 200ms, expect allowed", 200 * time.Millisecond, 1, true},
+		{"5 events at 1.2s, expect allowed (5 tokens accrued)", 1200 * time.Millisecond, 5, true},
+		{"1 event at 1.2s, expect denied", 1200 * time.Millisecond, 1, false},
+		{"11 events at 1 minute, expect denied (more than the burst)", time.Minute, 11, false},
+		{"10 events at 1 minute, expect allowed", time.Minute, 10, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := a.AllowN(start.Add(tt.at), tt.n); got != tt.want {
+				t.Errorf("AllowN(start+%v, %d) = %v, want %v", tt.at, tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateAdapter_Allow(t *testing.T) {
+	clock := newFakeClock()
+	a := NewRateAdapter(5, 2, WithClock(clock))
+	defer a.Stop()
+
+	got := []bool{a.Allow(), a.Allow(), a.Allow()}
+	clock.Advance(200 * time.Millisecond)
+	got = append(got, a.Allow(), a.Allow())
+
+	want := []bool{true, true, false, true, false}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Allow() results = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestRateAdapter_Reserve(t *testing.T) {
+	clock := newFakeClock()
+	a := NewRateAdapter(5, 1, WithClock(clock))
+	defer a.Stop()
+
+	// each reservation borrows the next token from the future, 200 milliseconds after the previous one
+	for i, want := range []time.Duration{0, 200 * time.Millisecond, 400 * time.Millisecond} {
+		r := a.Reserve()
+		if !r.OK() || r.Delay() != want {
+			t.Fatalf("reservation %d: OK() = %v, Delay() = %v, want true, %v", i, r.OK(), r.Delay(), want)
+		}
+	}
+	if a.Allow() {
+		t.Errorf("Allow() with tokens reserved = true, want false")
+	}
+
+	clock.Advance(500 * time.Millisecond)
+	if r := a.Reserve(); !r.OK() || r.Delay() != 100*time.Millisecond {
+		t.Errorf("Reserve() 500ms later: OK() = %v, Delay() = %v, want true, 100ms", r.OK(), r.Delay())
+	}
+
+	if r := a.ReserveN(clock.Now(), 2); r.OK() || r.Delay() != InfDuration {
+		t.Errorf("ReserveN(2) beyond the burst: OK() = %v, Delay() = %v, want false, InfDuration", r.OK(), r.Delay())
+	}
+}
+
+func TestRateAdapter_WaitN(t *testing.T) {
+	a := NewRateAdapter(100, 2)
+	defer a.Stop()
+
+	start := time.Now()
+	if err := a.WaitN(context.Background(), 2); err != nil {
+		t.Fatalf("WaitN(2) = %v, want nil", err)
+	}
+	if err := a.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait() = %v, want nil", err)
+	}
+	// the burst goes through straight away and the third token takes 10 milliseconds to accrue
+	if elapsed := time.Since(start); elapsed < 5*time.Millisecond {
+		t.Errorf("Wait() returned after %v, want it to wait for the next token", elapsed)
+	}
+
+	a.Stop()
+	if err := a.Wait(context.Background()); err != ErrStopped {
+		t.Errorf("Wait() after Stop() = %v, want %v", err, ErrStopped)
+	}
+}
+
+func TestReservation_Cancel(t *testing.T) {
+	clock := newFakeClock()
+	a := NewRateAdapter(5, 2, WithClock(clock))
+	defer a.Stop()
+
+	// the first reservation takes the 2 tokens in the bucket, the second borrows 2 more from the next 400ms
+	now := a.ReserveN(clock.Now(), 2)
+	later := a.ReserveN(clock.Now(), 2)
+	if d := later.Delay(); d != 400*time.Millisecond {
+		t.Fatalf("Delay() of the borrowing reservation = %v, want 400ms", d)
+	}
+
+	later.Cancel()
+	probe := a.Reserve()
+	if d := probe.Delay(); d != 200*time.Millisecond {
+		t.Errorf("Delay() after cancelling the borrowing reservation = %v, want 200ms", d)
+	}
+	probe.Cancel()
+
+	now.Cancel()
+	if !a.AllowN(clock.Now(), 2) {
+		t.Errorf("AllowN(2) after cancelling the immediate reservation = false, want true")
+	}
+	if a.AllowN(clock.Now(), 1) {
+		t.Errorf("AllowN(1) with the returned tokens spent = true, want false")
+	}
+
+	// a reservation that's already due can't be cancelled
+	clock.Advance(time.Second)
+	due := a.Reserve()
+	clock.Advance(time.Millisecond)
+	due.Cancel()
+	if a.AllowN(clock.Now(), 2) {
+		t.Errorf("AllowN(2) after cancelling a due reservation = true, want false")
+	}
+}
+
+func TestWithLeakDetection(t *testing.T) {
+	clock := newFakeClock()
+	a := NewRateAdapter(5, 10, WithClock(clock), WithLeakDetection())
+	defer a.Stop()
+
+	var reservations []*Reservation
+	for i := 0; i < 3; i++ {
+		reservations = append(reservations, a.ReserveN(clock.Now(), 2))
+	}
+	// a reservation that isn't OK holds nothing and isn't tracked
+	a.ReserveN(clock.Now(), 11)
+	if got := a.OutstandingReservations(); got != 3 {
+		t.Fatalf("OutstandingReservations() = %d, want 3", got)
+	}
+
+	reservations[0].Cancel()
+	reservations[1].Consume()
+	reservations[1].Cancel()
+	if got := a.OutstandingReservations(); got != 1 {
+		t.Errorf("OutstandingReservations() after finalizing 2 = %d, want 1", got)
+	}
+	reservations[2].Cancel()
+	reservations[2].Cancel()
+	if got := a.OutstandingReservations(); got != 0 {
+		t.Errorf("OutstandingReservations() after finalizing all = %d, want 0", got)
+	}
+}
+
+func TestOutstandingReservations_Disabled(t *testing.T) {
+	a := NewRateAdapter(5, 10, WithClock(newFakeClock()))
+	defer a.Stop()
+
+	a.Reserve()
+	if got := a.OutstandingReservations(); got != 0 {
+		t.Errorf("OutstandingReservations() without WithLeakDetection = %d, want 0", got)
+	}
+}
@@ -2,320 +2,1750 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
+	"math/rand"
+	"sort"
 	"sync"
 	"time"
 )
 
 const LIMITER_CAPACITY = 1024
 
+var (
+	ErrRateLimited = errors.New("ratelimitters: rate limit exceeded")
+	ErrCircuitOpen = errors.New("ratelimitters: circuit breaker open")
+)
+
+// priorities accepted by TokenBucket.AllowPriority, anything at or above PriorityHigh counts as high priority
+const (
+	PriorityLow  = 0
+	PriorityHigh = 1
+)
+
 type RateLimiter interface {
 	Allow(int) bool
 	Stop()
 }
 
+// Introspector is implemented by limiters that can report their capacity and current state, all the built-in
+// limiters do
+type Introspector interface {
+	Capacity() int
+	Tokens() int
+	NextReset() time.Time
+}
+
+// Result describes the outcome of a single AllowDetailed call
+type Result struct {
+	// Allowed is whether the request was admitted
+	Allowed bool
+	// Refilled is how many tokens were credited back by the lazy refill or leak applied during the call, always
+	// 0 for the window limiters
+	Refilled int
+	// RemainingTokens is how many tokens could still be admitted after the call
+	RemainingTokens int
+}
+
+// resChPool recycles the result channels of Allow so the hot path doesn't allocate
+var resChPool = sync.Pool{
+	New: func() any {
+		return make(chan bool, 1)
+	},
+}
+
 type requestTokensCh struct {
 	tokens int
+	tags   map[string]string
 	resCh  chan bool
 }
 
+// algorithm is implemented by each limiter and is only ever called from the limiter's own goroutine
+type algorithm interface {
+	allow(currentTime time.Time, tokens int) bool
+	nextAvailable(currentTime time.Time, tokens int) time.Time
+	available(currentTime time.Time) int
+	nextReset(currentTime time.Time) time.Time
+	maxTokens() int
+	// sustainedRate is the long-run admission rate in tokens per second
+	sustainedRate() float64
+	// budget is how many tokens can be admitted from currentTime until horizon has passed
+	budget(currentTime time.Time, horizon time.Duration) int
+	// clone returns a limiter with the same configuration on top of rlb, its state is loaded separately
+	clone(rlb *RateLimiterBase) algorithm
+	snapshotter
+}
+
+// refiller is implemented by the algorithms that credit the tokens accrued since the last request lazily, refill
+// applies that credit and returns how many tokens it freed up
+type refiller interface {
+	refill(currentTime time.Time) int
+}
+
+// admitter is implemented by the algorithms whose largest admissible request isn't simply maxTokens, admits
+// reports whether a request costing tokens can ever be admitted, whatever the current state
+type admitter interface {
+	admits(tokens int) bool
+}
+
 type RateLimiterBase struct {
+	algo     algorithm
 	allowCh  chan requestTokensCh
+	execCh   chan func()
+	ctx      context.Context
 	stopFunc context.CancelFunc
 	wg       sync.WaitGroup
 	isClosed bool
 	mu       sync.RWMutex
+	// lastNow is the latest time read by now, only touched from the limiter's goroutine
+	lastNow time.Time
+	// randMu guards options.rand, which isn't safe for concurrent use
+	randMu sync.Mutex
+	// waiters queues the callers blocked in Wait, it's only touched from the limiter's goroutine
+	waiters []*waiter
+	// latency records how long the callers admitted by Wait were blocked, guarded by latencyMu
+	latency   Histogram
+	latencyMu sync.Mutex
+	// inlineMu serializes the calls that touch the algorithm's state with WithoutGoroutine, which has no
+	// goroutine to do that
+	inlineMu sync.Mutex
+	// penalty is the WithPenalty lockout, it's only touched from the limiter's goroutine
+	penalty penalty
+	// starvation is the request WithMaxStarvation protects, it's only touched from the limiter's goroutine
+	starvation starvation
+	// overload measures the attempted rate for WithOverloadThreshold, it's only touched from the limiter's goroutine
+	overload overload
+	counters
+	options
+}
+
+func newRateLimiterBase(opts []Option) (*RateLimiterBase, context.Context) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return newRateLimiterBaseWithOptions(o)
+}
+
+func newRateLimiterBaseWithOptions(o options) (*RateLimiterBase, context.Context) {
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	rlb := &RateLimiterBase{
+		ctx:      ctx,
+		stopFunc: cancelFunc,
+		options:  o,
+	}
+	if !rlb.withoutGoroutine {
+		rlb.allowCh = make(chan requestTokensCh, LIMITER_CAPACITY)
+		rlb.execCh = make(chan func())
+	}
+	if rlb.clock == nil {
+		rlb.clock = realClock{}
+	}
+	if rlb.rand == nil {
+		rlb.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	return rlb, ctx
+}
+
+// now reads the current time from the clock for the algorithm. Readings from time.Now carry a monotonic clock
+// reading, which all the arithmetic on them uses, so wall clock steps from DST changes or NTP don't affect the
+// real clock. An injected clock has no monotonic reading, so now never goes back past its latest reading and a
+// clock stepped backwards reads as standing still until it catches up. It's only called on the limiter's
+// goroutine, or before the goroutine is started
+func (rlb *RateLimiterBase) now() time.Time {
+	currentTime := rlb.clock.Now()
+	if currentTime.Before(rlb.lastNow) {
+		return rlb.lastNow
+	}
+	rlb.lastNow = currentTime
+	return currentTime
+}
+
+// cost returns how many tokens a request for tokens takes, scaled by WithCostScale and rounded up. A scale that
+// isn't positive counts as 1, and it's called on the limiter's goroutine
+func (rlb *RateLimiterBase) cost(tokens int) int {
+	if rlb.costScale == nil {
+		return tokens
+	}
+	scale := rlb.costScale()
+	if !(scale > 0) {
+		return tokens
+	}
+	// rounding off float noise first keeps 10 * 1.1 from costing 12
+	scaled := math.Ceil(math.Round(float64(tokens)*scale*1e6) / 1e6)
+	if scaled >= math.MaxInt {
+		return math.MaxInt
+	}
+	return int(scaled)
+}
+
+// clamp caps a request at the limiter's capacity when WithClampToCapacity is set. A limiter with no capacity
+// isn't clamped, capping at zero would turn the request into a free one
+func (rlb *RateLimiterBase) clamp(tokens, capacity int) int {
+	if rlb.clampToCapacity && capacity > 0 && tokens > capacity {
+		return capacity
+	}
+	return tokens
+}
+
+// windowReset reports the start of a new window to the WithOnWindowReset callback, if any
+func (rlb *RateLimiterBase) windowReset(windowStart time.Time) {
+	if rlb.onWindowReset != nil {
+		rlb.onWindowReset(windowStart)
+	}
+}
+
+func (rlb *RateLimiterBase) start(ctx context.Context, algo algorithm) {
+	rlb.algo = algo
+	if !rlb.withoutGoroutine {
+		rlb.wg.Add(1)
+		go rlb.run(ctx)
+	}
+	if rlb.metricsFlush > 0 && rlb.batchedMetrics != nil {
+		rlb.wg.Add(1)
+		go rlb.batchMetrics(ctx)
+	}
+	if rlb.registry != nil {
+		rlb.registry.add(rlb)
+	}
+}
+
+func (rlb *RateLimiterBase) run(ctx context.Context) {
+	// runs the limiter's algorithm in a separate goroutine and also checks for event(cancelling the context) to stop this goroutine
+	defer rlb.wg.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case fn := <-rlb.execCh:
+			fn()
+		case reqTokensCh := <-rlb.allowCh:
+			// resCh isn't closed since Allow hands it back to resChPool for reuse
+			reqTokensCh.resCh <- rlb.admit(rlb.now(), reqTokensCh.tokens, reqTokensCh.tags) == nil
+		}
+	}
+}
+
+// exec runs fn on the limiter's goroutine so it can safely touch the algorithm's state, it reports false if the limiter has been stopped
+func (rlb *RateLimiterBase) exec(fn func()) bool {
+	return rlb.execContext(context.Background(), fn) == nil
+}
+
+// execContext is exec giving up with ctx's error if ctx is done before fn gets its turn on the limiter's
+// goroutine, it returns ErrStopped if the limiter has been stopped. Once fn has started it runs to completion
+func (rlb *RateLimiterBase) execContext(ctx context.Context, fn func()) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if rlb.withoutGoroutine {
+		if !rlb.execInline(fn) {
+			return ErrStopped
+		}
+		return nil
+	}
+	rlb.mu.RLock()
+	isClosed := rlb.isClosed
+	rlb.mu.RUnlock()
+	if isClosed {
+		return ErrStopped
+	}
+
+	done := make(chan struct{})
+	select {
+	case rlb.execCh <- func() { fn(); close(done) }:
+		<-done
+		return nil
+	case <-rlb.ctx.Done():
+		return ErrStopped
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// execInline is exec for WithoutGoroutine, it runs fn on the caller's goroutine under inlineMu instead. The read
+// lock held meanwhile makes Stop wait for fn to finish, like it waits for the limiter's goroutine
+func (rlb *RateLimiterBase) execInline(fn func()) bool {
+	rlb.mu.RLock()
+	defer rlb.mu.RUnlock()
+	if rlb.isClosed {
+		return false
+	}
+	rlb.inlineMu.Lock()
+	defer rlb.inlineMu.Unlock()
+	fn()
+	return true
 }
 
 func (rlb *RateLimiterBase) Allow(tokens int) bool {
+	return rlb.AllowTagged(tokens, nil)
+}
+
+// AllowTagged is Allow passing tags, such as the endpoint or method of a request, along with the decision to the
+// WithMetricsHook hook so it can label its datapoints without a separate limiter per label
+func (rlb *RateLimiterBase) AllowTagged(tokens int, tags map[string]string) bool {
+	if rlb.tryAllow(tokens, tags) {
+		return true
+	}
+	if rlb.spinWait <= 0 || tokens <= 0 {
+		return false
+	}
+	return rlb.spin(tokens, tags)
+}
+
+// spin retries a denied request until the WithSpinWait budget runs out, sleeping until the tokens are due or
+// the budget ends, whichever comes first
+func (rlb *RateLimiterBase) spin(tokens int, tags map[string]string) bool {
+	deadline := time.Now().Add(rlb.spinWait)
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return false
+		}
+		next := rlb.NextAvailable(tokens)
+		if next.IsZero() {
+			// stopped or too large to ever be admitted
+			return false
+		}
+		time.Sleep(min(next.Sub(rlb.clock.Now()), remaining))
+		if rlb.tryAllow(tokens, tags) {
+			return true
+		}
+	}
+}
+
+// tryAllow asks the limiter's goroutine to decide a request once
+func (rlb *RateLimiterBase) tryAllow(tokens int, tags map[string]string) bool {
 	if tokens <= 0 {
 		return false
 	}
-	isClosed := false
+	if rlb.withoutGoroutine {
+		allowed := false
+		rlb.execInline(func() {
+			allowed = rlb.admit(rlb.now(), tokens, tags) == nil
+		})
+		return allowed
+	}
 	rlb.mu.RLock()
-	isClosed = rlb.isClosed
-	rlb.mu.RUnlock()
-	if isClosed {
+	if rlb.isClosed {
+		rlb.mu.RUnlock()
 		return false
 	}
 
 	reqTokensCh := requestTokensCh{
 		tokens: tokens,
-		resCh:  make(chan bool, 1),
+		tags:   tags,
+		resCh:  resChPool.Get().(chan bool),
 	}
 
+	// the send happens under the read lock so Stop can't close allowCh underneath it
 	rlb.allowCh <- reqTokensCh
-	return <-reqTokensCh.resCh
+	rlb.mu.RUnlock()
+
+	select {
+	case resp := <-reqTokensCh.resCh:
+		resChPool.Put(reqTokensCh.resCh)
+		return resp
+	case <-rlb.ctx.Done():
+		return false
+	}
 }
 
-func (rlb *RateLimiterBase) Stop() {
-	rlb.stopFunc()
-	rlb.wg.Wait()
-	rlb.mu.Lock()
-	rlb.isClosed = true
-	rlb.mu.Unlock()
-	close(rlb.allowCh)
+// AllowE is Allow returning why a request was denied: ErrRateLimited when the limit is reached, ErrCircuitOpen
+// when the WithCircuitBreaker breaker is open, ErrNeverAvailable for requests of zero or fewer tokens and
+// ErrStopped once the limiter has been stopped. It returns nil when the request is admitted
+func (rlb *RateLimiterBase) AllowE(tokens int) error {
+	if tokens <= 0 {
+		return ErrNeverAvailable
+	}
+	err := ErrStopped
+	rlb.exec(func() {
+		err = rlb.admit(rlb.now(), tokens, nil)
+	})
+	return err
 }
 
-type TokenBucket struct {
-	capacity        int
-	tokensPerSecond int
-	tokens          int
-	lastTime        time.Time
-	*RateLimiterBase
+// AllowContext is Allow that gives up with ctx's error if ctx is done before the request is decided, and also
+// returns the tokens remaining after the decision so callers can set a RateLimit-Remaining header without a
+// second call. Like AllowE it returns ErrNeverAvailable for requests of zero or fewer tokens and ErrStopped once
+// the limiter has been stopped, a request that is merely denied returns a nil error
+func (rlb *RateLimiterBase) AllowContext(ctx context.Context, tokens int) (allowed bool, remaining int, err error) {
+	if tokens <= 0 {
+		return false, 0, ErrNeverAvailable
+	}
+	err = rlb.execContext(ctx, func() {
+		currentTime := rlb.now()
+		allowed = rlb.admit(currentTime, tokens, nil) == nil
+		remaining = rlb.algo.available(currentTime)
+	})
+	return allowed, remaining, err
 }
 
-func NewTokenBucket(capacity, tokensPerSecond, tokens int) RateLimiter {
-	allowCh := make(chan requestTokensCh, LIMITER_CAPACITY)
-	ctx, cancelFunc := context.WithCancel(context.Background())
-	rlBase := &RateLimiterBase{
-		allowCh:  allowCh,
-		stopFunc: cancelFunc,
+// admit decides a request on the limiter's goroutine, denying it without taking any tokens while the circuit
+// breaker is open or the WithPenalty lockout runs, and counts the decision towards Stats and the metrics hook
+// with tags
+func (rlb *RateLimiterBase) admit(currentTime time.Time, tokens int, tags map[string]string) error {
+	return rlb.admitWith(currentTime, tokens, tags, rlb.algo.allow)
+}
+
+// admitWith is admit taking the tokens with allow instead of the algorithm's plain allow, for the ways of asking
+// for tokens that decide differently such as AllowPriority
+func (rlb *RateLimiterBase) admitWith(currentTime time.Time, tokens int, tags map[string]string, allow func(time.Time, int) bool) error {
+	err := rlb.verdict(currentTime, tokens, allow)
+	rlb.settle(currentTime, tokens, tags, err)
+	rlb.mirror(tokens, err == nil)
+	rlb.trackOverload(currentTime, tokens)
+	if rlb.dryRun {
+		return nil
 	}
-	rl := &TokenBucket{
-		RateLimiterBase: rlBase,
-		capacity:        capacity,
-		tokensPerSecond: tokensPerSecond,
-		tokens:          tokens,
-		lastTime:        time.Now(),
+	return err
+}
+
+// verdict decides a request without counting the decision anywhere, it returns ErrCircuitOpen or ErrRateLimited
+// for a denial and takes the tokens with allow otherwise
+func (rlb *RateLimiterBase) verdict(currentTime time.Time, tokens int, allow func(time.Time, int) bool) error {
+	if rlb.circuitOpen != nil && rlb.circuitOpen() {
+		return ErrCircuitOpen
 	}
+	if rlb.locked(currentTime) || rlb.starved(currentTime, tokens) || !allow(currentTime, rlb.cost(tokens)) {
+		return ErrRateLimited
+	}
+	return nil
+}
 
-	rl.wg.Add(1)
-	go rl.tokenBucketAlgorithm(ctx)
+// settle counts a verdict towards Stats and the metrics hook with tags, WithMaxStarvation and WithPenalty
+func (rlb *RateLimiterBase) settle(currentTime time.Time, tokens int, tags map[string]string, err error) {
+	switch err {
+	case nil:
+		rlb.recordTagged(true, tags)
+		rlb.trackStarvation(currentTime, tokens, true)
+	case ErrCircuitOpen:
+		rlb.recordTagged(false, tags)
+	default:
+		rlb.recordTagged(false, tags)
+		rlb.trackStarvation(currentTime, tokens, false)
+		rlb.penalize(currentTime)
+	}
+}
 
-	return rl
+// mirror asks the WithShadow candidate for the same tokens the limiter just decided on and reports when the two
+// disagree. The candidate's answer never changes the limiter's own decision
+func (rlb *RateLimiterBase) mirror(tokens int, allowed bool) {
+	if rlb.shadow == nil {
+		return
+	}
+	if shadowed := rlb.shadow.Allow(tokens); shadowed != allowed && rlb.onDivergence != nil {
+		rlb.onDivergence(allowed, shadowed)
+	}
 }
 
-func (rl *TokenBucket) tokenBucketAlgorithm(ctx context.Context) {
-	// runs the token bucket algorithm in a separate goroutine and also checks for event(cancelling the context) to stop this goroutine
-	defer rl.wg.Done()
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case reqTokensCh := <-rl.allowCh:
-			fmt.Printf("tokens requested: %d, available tokens: %d ", reqTokensCh.tokens, rl.tokens)
-
-			currentTime := time.Now()
-			timePassed := currentTime.Sub(rl.lastTime).Seconds()
-			temp := rl.tokens + int(timePassed)*rl.tokensPerSecond
-			fmt.Printf("total new tokens: %d ", temp)
-			if rl.capacity <= temp {
-				rl.tokens = rl.capacity
-			} else {
-				rl.tokens = temp
-			}
-			rl.lastTime = currentTime
-			resp := false
-
-			if reqTokensCh.tokens <= rl.tokens {
-				rl.tokens -= reqTokensCh.tokens
-				resp = true
-			} else {
-				resp = false
-			}
-			reqTokensCh.resCh <- resp
-			close(reqTokensCh.resCh)
+// transaction returns the commit and rollback of AllowTransaction for a request of tokens that was allowed or not,
+// rollback hands the tokens to refund unless commit was called first
+func transaction(allowed bool, tokens int, refund func(tokens int)) (commit func(), rollback func(), ok bool) {
+	if !allowed {
+		return func() {}, func() {}, false
+	}
+	var once sync.Once
+	commit = func() {
+		once.Do(func() {})
+	}
+	rollback = func() {
+		once.Do(func() { refund(tokens) })
+	}
+	return commit, rollback, true
+}
+
+// AllowDetailed is Allow reporting how many tokens the lazy refill or leak applied during the call freed up and
+// how many tokens are left afterwards. A stopped limiter reports the zero Result
+func (rlb *RateLimiterBase) AllowDetailed(tokens int) Result {
+	var res Result
+	rlb.exec(func() {
+		currentTime := rlb.now()
+		if r, ok := rlb.algo.(refiller); ok {
+			res.Refilled = r.refill(currentTime)
 		}
+		res.Allowed = tokens > 0 && rlb.admit(currentTime, tokens, nil) == nil
+		res.RemainingTokens = rlb.algo.available(currentTime)
+	})
+	return res
+}
+
+// allowSeq applies requests in the given order as one uninterrupted batch on the limiter's goroutine, so no other
+// caller can interleave with them and the outcome doesn't depend on goroutine scheduling. It's meant for tests
+func (rlb *RateLimiterBase) allowSeq(requests []int) []bool {
+	results := make([]bool, len(requests))
+	rlb.exec(func() {
+		currentTime := rlb.now()
+		for i, tokens := range requests {
+			results[i] = tokens > 0 && rlb.admit(currentTime, tokens, nil) == nil
+		}
+	})
+	return results
+}
+
+// NextAvailable returns the earliest time at which a request for tokens would be admitted, which is the current
+// time if it would be admitted right away and no earlier than the end of a WithPenalty lockout. The zero time is
+// returned for requests that can never be admitted, either because they are invalid, larger than the capacity or
+// because the limiter has been stopped
+func (rlb *RateLimiterBase) NextAvailable(tokens int) time.Time {
+	var next time.Time
+	if tokens <= 0 {
+		return next
 	}
+	rlb.exec(func() {
+		next = rlb.nextAdmission(rlb.now(), rlb.cost(tokens))
+	})
+	return next
 }
 
-type LeakyBucket struct {
-	capacity int
-	leakRate int
-	tokens   int
-	lastTime time.Time
-	*RateLimiterBase
+// nextAdmission is the algorithm's nextAvailable held back until the end of a WithPenalty lockout, with the wait
+// rounded up to the WithRetryAfterQuantum quantum
+func (rlb *RateLimiterBase) nextAdmission(currentTime time.Time, tokens int) time.Time {
+	next := rlb.algo.nextAvailable(currentTime, tokens)
+	if next.IsZero() {
+		return next
+	}
+	if rlb.locked(currentTime) && next.Before(rlb.penalty.until) {
+		next = rlb.penalty.until
+	}
+	if wait := next.Sub(currentTime); rlb.retryQuantum > 0 && wait > 0 {
+		next = currentTime.Add(quantize(wait, rlb.retryQuantum))
+	}
+	return next
 }
 
-func NewLeakyBucket(capacity, leakRate int) RateLimiter {
-	allowCh := make(chan requestTokensCh, LIMITER_CAPACITY)
-	ctx, cancelFunc := context.WithCancel(context.Background())
-	rlBase := &RateLimiterBase{
-		allowCh:  allowCh,
-		stopFunc: cancelFunc,
+// Capacity returns the most tokens the limiter can admit at once
+func (rlb *RateLimiterBase) Capacity() int {
+	capacity := 0
+	rlb.exec(func() {
+		capacity = rlb.algo.maxTokens()
+	})
+	return capacity
+}
+
+// ValidCost reports whether a request for tokens could ever be admitted by rl, whatever its current state, so
+// requests that never would can be rejected up front with a clear error. The built-in limiters decide it the way
+// Allow does, after WithClampToCapacity and WithCostScale, any other Introspector by its Capacity. Costs of zero
+// or less are never valid, and neither is any cost for a stopped limiter. A limiter that isn't an Introspector
+// accepts any positive cost
+func ValidCost(rl RateLimiter, tokens int) bool {
+	if tokens <= 0 {
+		return false
 	}
-	rl := &LeakyBucket{
-		RateLimiterBase: rlBase,
-		capacity:        capacity,
-		leakRate:        leakRate,
-		tokens:          capacity,
-		lastTime:        time.Now(),
+	switch rl := rl.(type) {
+	case interface{ validCost(int) bool }:
+		return rl.validCost(tokens)
+	case Introspector:
+		return tokens <= rl.Capacity()
 	}
+	return true
+}
 
-	rl.wg.Add(1)
-	go rl.leakyBucketAlgorithm(ctx)
+// validCost is ValidCost for a built-in limiter
+func (rlb *RateLimiterBase) validCost(tokens int) bool {
+	valid := false
+	rlb.exec(func() {
+		valid = rlb.admissible(tokens)
+	})
+	return valid
+}
 
-	return rl
+// SustainedRate returns the most tokens per second the limiter admits in the long run, ignoring bursts, which is
+// what it can be planned to handle. It is computed from the limiter's configuration and is infinite for a
+// limiter without a period, such as a MinInterval of 0
+func (rlb *RateLimiterBase) SustainedRate() float64 {
+	rate := 0.0
+	rlb.exec(func() {
+		rate = rlb.algo.sustainedRate()
+	})
+	return rate
 }
 
-func (rl *LeakyBucket) leakyBucketAlgorithm(ctx context.Context) {
-	// runs the leaky bucket algorithm in a separate goroutine and also checks for event(cancelling the context) to stop this goroutine
+// Budget returns how many tokens the limiter could admit over the next horizon without denying any, the tokens
+// available now plus those it frees up by then, for requests spread out to take them as they free up. It's a
+// projection from the limiter's current state, for scheduling batch work, and 0 once the limiter is stopped
+func (rlb *RateLimiterBase) Budget(horizon time.Duration) int {
+	budget := 0
+	rlb.exec(func() {
+		budget = rlb.algo.budget(rlb.now(), max(horizon, 0))
+	})
+	return budget
+}
 
-	defer rl.wg.Done()
+// Tokens returns how many tokens could be admitted right now, or 0 once the limiter has been stopped
+func (rlb *RateLimiterBase) Tokens() int {
+	tokens := 0
+	rlb.exec(func() {
+		tokens = rlb.algo.available(rlb.now())
+	})
+	return tokens
+}
 
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case reqTokensCh := <-rl.allowCh:
-			fmt.Printf("tokens requested: %d, available tokens: %d ", reqTokensCh.tokens, rl.tokens)
-			currentTime := time.Now()
-			timePassed := currentTime.Sub(rl.lastTime).Seconds()
-
-			leakedTokens := int(timePassed) * rl.leakRate
-
-			temp := rl.tokens - leakedTokens
-			if temp < 0 {
-				rl.tokens = 0
-			} else {
-				rl.tokens = temp
-			}
-			fmt.Printf("total new tokens: %d ", rl.tokens)
+// NextReset returns when the limiter will be back to its full capacity if nothing else is admitted, which is the
+// current time if it already is. The zero time is returned if it never will be or the limiter has been stopped
+func (rlb *RateLimiterBase) NextReset() time.Time {
+	var reset time.Time
+	rlb.exec(func() {
+		reset = rlb.algo.nextReset(rlb.now())
+	})
+	return reset
+}
 
-			rl.lastTime = currentTime
-			resp := false
+// Done returns a channel that's closed once the limiter is stopped, for use in select statements
+func (rlb *RateLimiterBase) Done() <-chan struct{} {
+	return rlb.ctx.Done()
+}
 
-			if reqTokensCh.tokens <= (rl.capacity - rl.tokens) {
-				rl.tokens += reqTokensCh.tokens
-				resp = true
-			} else {
-				resp = false
-			}
-			reqTokensCh.resCh <- resp
-			close(reqTokensCh.resCh)
-		}
+// Stop stops the limiter and waits for its goroutine to exit. Every caller blocked in Wait or Do is released with
+// ErrStopped, and later calls are denied or fail with ErrStopped. Calling Stop again does nothing
+func (rlb *RateLimiterBase) Stop() {
+	rlb.mu.Lock()
+	if rlb.isClosed {
+		rlb.mu.Unlock()
+		return
+	}
+	rlb.isClosed = true
+	rlb.mu.Unlock()
+	rlb.stopFunc()
+	rlb.wg.Wait()
+	if rlb.metricsFlush > 0 && rlb.batchedMetrics != nil {
+		rlb.flushMetrics()
+	}
+	if rlb.allowCh != nil {
+		close(rlb.allowCh)
+	}
+	if rlb.registry != nil {
+		rlb.registry.remove(rlb)
 	}
 }
 
-type FixedWindow struct {
-	tokens     int
-	windowSize int
-	capacity   int
-	lastTime   time.Time
+type TokenBucket struct {
+	noCopy       noCopy
+	capacity     int
+	refillTokens int
+	refillPeriod time.Duration
+	tokens       int
+	lastTime     time.Time
+	// grace is the WithGraceBurst pool, drawn from only once the bucket runs short
+	grace gracePool
 	*RateLimiterBase
 }
 
-func NewFixedWindow(windowSize, capacity int) RateLimiter {
-	allowCh := make(chan requestTokensCh, LIMITER_CAPACITY)
-	ctx, cancelFunc := context.WithCancel(context.Background())
-	rlBase := &RateLimiterBase{
-		allowCh:  allowCh,
-		stopFunc: cancelFunc,
+// NewTokenBucket creates a token bucket holding up to capacity tokens, starting with tokens and refilling
+// tokensPerSecond. The bucket never holds more than capacity, so a refill rate far above the capacity only makes
+// the bucket fill up quickly, it never lets a burst larger than capacity through
+func NewTokenBucket(capacity, tokensPerSecond, tokens int, opts ...Option) RateLimiter {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
 	}
-	rl := &FixedWindow{
+	return newTokenBucket(capacity, tokensPerSecond, o.unit(), tokens, opts)
+}
+
+// NewTokenBucketPerDuration creates a token bucket admitting n tokens per duration in the long run, with up to
+// burst tokens available at once. The bucket starts full
+func NewTokenBucketPerDuration(n int, per time.Duration, burst int, opts ...Option) RateLimiter {
+	return newTokenBucket(burst, n, per, burst, opts)
+}
+
+func newTokenBucket(capacity, refillTokens int, refillPeriod time.Duration, tokens int, opts []Option) *TokenBucket {
+	rlBase, ctx := newRateLimiterBase(opts)
+	if rlBase.burstMultiplier > 0 && refillPeriod > 0 {
+		perSecond := float64(refillTokens) * float64(time.Second) / float64(refillPeriod)
+		// rounding off float noise first keeps 10 * 1.2 from coming out as a capacity of 13
+		capacity = int(math.Ceil(math.Round(perSecond*rlBase.burstMultiplier*1e6) / 1e6))
+	}
+	rl := &TokenBucket{
 		RateLimiterBase: rlBase,
-		tokens:          capacity,
 		capacity:        capacity,
-		windowSize:      windowSize,
-		lastTime:        time.Now(),
+		refillTokens:    refillTokens,
+		refillPeriod:    refillPeriod,
+		tokens:          min(max(tokens, 0), capacity),
+		lastTime:        rlBase.now(),
+		grace:           newGracePool(rlBase.graceAmount, rlBase.graceRegen, rlBase.now()),
 	}
 
-	rl.wg.Add(1)
-	go rl.fixedWindowAlgorithm(ctx)
+	rl.start(ctx, rl)
 
 	return rl
 }
 
-func (rl *FixedWindow) fixedWindowAlgorithm(ctx context.Context) {
-	// runs the fixed window algorithm in a separate goroutine and also checks for event(cancelling the context) to stop this goroutine
+// refilled returns the tokens and lastTime after crediting the tokens accrued until currentTime, the time spent
+// towards a token that hasn't fully accrued yet is carried over to the next refill rather than dropped. It's integer
+// nanosecond arithmetic throughout, so high rates such as 100k tokens per second don't lose any precision
+func (rl *TokenBucket) refilled(currentTime time.Time) (int, time.Time) {
+	elapsed := currentTime.Sub(rl.lastTime)
+	if rl.tokens >= rl.capacity || elapsed >= rl.refillDuration(rl.capacity-rl.tokens) {
+		// a full bucket doesn't bank time towards future refills
+		return rl.capacity, currentTime
+	}
+	if elapsed <= 0 || rl.refillTokens <= 0 || rl.refillPeriod <= 0 {
+		return rl.tokens, rl.lastTime
+	}
+	added := int(mulDiv(int64(elapsed), int64(rl.refillTokens), int64(rl.refillPeriod)))
+	return rl.tokens + added, rl.lastTime.Add(time.Duration(mulDiv(int64(added), int64(rl.refillPeriod), int64(rl.refillTokens))))
+}
 
-	defer rl.wg.Done()
+// refillDuration returns how long it takes to accrue n tokens
+func (rl *TokenBucket) refillDuration(n int) time.Duration {
+	if rl.refillTokens <= 0 || rl.refillPeriod <= 0 {
+		return math.MaxInt64
+	}
+	return time.Duration(mulDivCeil(int64(n), int64(rl.refillPeriod), int64(rl.refillTokens)))
+}
 
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case reqTokensCh := <-rl.allowCh:
-			fmt.Printf("tokens requested: %d, available tokens: %d ", reqTokensCh.tokens, rl.tokens)
-			currentTime := time.Now()
-			timePassed := int(currentTime.Sub(rl.lastTime).Seconds())
-
-			resp := false
-			if timePassed >= rl.windowSize {
-				rl.lastTime = currentTime
-				rl.tokens = rl.capacity - reqTokensCh.tokens
-				if rl.tokens < 0 {
-					rl.tokens = rl.capacity
-					resp = false
-				} else {
-					resp = true
-				}
-			} else {
-				if rl.tokens >= reqTokensCh.tokens {
-					rl.tokens -= reqTokensCh.tokens
-					resp = true
-				} else {
-					resp = false
-				}
-			}
-			fmt.Printf("total new tokens: %d ", rl.tokens)
-			reqTokensCh.resCh <- resp
-			close(reqTokensCh.resCh)
-		}
+// allow runs the token bucket algorithm for a request of tokens arriving at currentTime, plain requests are
+// treated as low priority
+func (rl *TokenBucket) allow(currentTime time.Time, tokens int) bool {
+	return rl.allowPriority(currentTime, tokens, PriorityLow)
+}
+
+func (rl *TokenBucket) allowPriority(currentTime time.Time, tokens, priority int) bool {
+	tokens = rl.clamp(tokens, rl.capacity)
+	rl.tokens, rl.lastTime = rl.refilled(currentTime)
+
+	if rl.dropEarly() {
+		return false
 	}
+	usable := rl.tokens - rl.reservedFor(priority)
+	if tokens <= usable {
+		rl.tokens -= tokens
+		return true
+	}
+	// the grace pool covers what the bucket can't, the bucket is drained down to its reserve first
+	if shortfall := tokens - max(usable, 0); shortfall <= rl.grace.available(currentTime) {
+		rl.tokens -= tokens - shortfall
+		rl.grace.take(currentTime, shortfall)
+		return true
+	}
+	return false
 }
 
-type SlidingWindow struct {
-	limit      int
-	windowSize time.Duration
-	timeStamps []time.Time
-	*RateLimiterBase
+// dropEarly decides at random whether WithEarlyDrop denies the next request given how much of the bucket is used up
+func (rl *TokenBucket) dropEarly() bool {
+	if !rl.earlyDrop || rl.capacity <= 0 {
+		return false
+	}
+	util := 1 - float64(rl.tokens)/float64(rl.capacity)
+	if util <= rl.earlyDropMinUtil {
+		return false
+	}
+	rl.randMu.Lock()
+	defer rl.randMu.Unlock()
+	return rl.rand.Float64() < (util-rl.earlyDropMinUtil)/(1-rl.earlyDropMinUtil)
 }
 
-func NewSlidingWindow(limit int, windowSize time.Duration) RateLimiter {
-	allowCh := make(chan requestTokensCh, LIMITER_CAPACITY)
-	ctx, cancelFunc := context.WithCancel(context.Background())
-	rlBase := &RateLimiterBase{
-		allowCh:  allowCh,
-		stopFunc: cancelFunc,
+// reservedFor returns how many tokens a request of the given priority has to leave in the bucket
+func (rl *TokenBucket) reservedFor(priority int) int {
+	if priority >= PriorityHigh {
+		return 0
 	}
-	rl := &SlidingWindow{
-		RateLimiterBase: rlBase,
-		limit:           limit,
-		windowSize:      windowSize,
-		timeStamps:      make([]time.Time, 0),
+	return rl.reservedForHighPriority
+}
+
+// admits counts the grace pool on top of the bucket, less the reserve plain requests have to leave in it
+func (rl *TokenBucket) admits(tokens int) bool {
+	return rl.clamp(tokens, rl.capacity) <= rl.capacity-rl.reservedFor(PriorityLow)+rl.grace.capacity
+}
+
+func (rl *TokenBucket) nextAvailable(currentTime time.Time, tokens int) time.Time {
+	if !rl.admits(tokens) {
+		return time.Time{}
 	}
+	tokens = rl.clamp(tokens, rl.capacity)
+	// plain requests have to leave the high priority reserve in the bucket
+	reserved := rl.reservedFor(PriorityLow)
+	tokens += reserved
+	grace := rl.grace.available(currentTime)
+	if tokens-grace <= rl.capacity {
+		return rl.refilledFor(currentTime, tokens-grace)
+	}
+	// the bucket can't cover the rest of the request even when full, so the grace pool has to regenerate as well.
+	// Waiting for a full bucket and the rest from the pool errs on the late side when both would be refilling
+	graceAt := rl.grace.heldAt(currentTime, tokens-rl.capacity)
+	fullAt := rl.refilledFor(currentTime, rl.capacity)
+	if graceAt.IsZero() || fullAt.IsZero() {
+		return time.Time{}
+	}
+	if fullAt.After(graceAt) {
+		return fullAt
+	}
+	return graceAt
+}
 
-	rl.wg.Add(1)
-	go rl.slidingWindowAlgorithm(ctx)
+// refilledFor returns when the bucket holds n tokens, the zero time if it never does
+func (rl *TokenBucket) refilledFor(currentTime time.Time, n int) time.Time {
+	available, lastTime := rl.refilled(currentTime)
+	if n <= available {
+		return currentTime
+	}
+	if rl.refillTokens <= 0 || rl.refillPeriod <= 0 {
+		return time.Time{}
+	}
+	return lastTime.Add(rl.refillDuration(n - available))
+}
 
-	return rl
+func (rl *TokenBucket) refill(currentTime time.Time) int {
+	before := rl.tokens
+	rl.tokens, rl.lastTime = rl.refilled(currentTime)
+	return rl.tokens - before
 }
 
-func (rl *SlidingWindow) slidingWindowAlgorithm(ctx context.Context) {
-	// runs the sliding window algorithm in a separate goroutine and also checks for event(cancelling the context) to stop this goroutine
+// available leaves out the grace pool, which isn't part of the capacity, GraceTokens reports it separately
+func (rl *TokenBucket) available(currentTime time.Time) int {
+	tokens, _ := rl.refilled(currentTime)
+	return tokens
+}
 
-	defer rl.wg.Done()
+// GraceTokens returns how many tokens the WithGraceBurst pool holds right now, on top of Tokens. It's 0 for a
+// bucket without a grace pool or a stopped one
+func (rl *TokenBucket) GraceTokens() int {
+	tokens := 0
+	rl.exec(func() {
+		tokens = rl.grace.available(rl.now())
+	})
+	return tokens
+}
 
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case reqTokensCh := <-rl.allowCh:
-			currentTime := time.Now()
-			// append as many entries as tokens requested
-			for i := 0; i < reqTokensCh.tokens; i++ {
-				rl.timeStamps = append(rl.timeStamps, currentTime)
-			}
-			fmt.Printf("total requests: %d, limit: %d ", reqTokensCh.tokens, rl.limit)
+func (rl *TokenBucket) nextReset(currentTime time.Time) time.Time {
+	tokens, lastTime := rl.refilled(currentTime)
+	if tokens >= rl.capacity {
+		return currentTime
+	}
+	if rl.refillTokens <= 0 || rl.refillPeriod <= 0 {
+		return time.Time{}
+	}
+	return lastTime.Add(rl.refillDuration(rl.capacity - tokens))
+}
 
-			for len(rl.timeStamps) > 0 && rl.timeStamps[0].Before(currentTime.Add(-rl.windowSize)) {
-				rl.timeStamps = rl.timeStamps[1:]
-			}
-			fmt.Printf("total requests after sliding: %d ", len(rl.timeStamps))
-			resp := false
-			totalTokensInWindow := len(rl.timeStamps)
-			if totalTokensInWindow <= rl.limit {
-				resp = true
-			} else {
-				// roll back the tokens if the request can't be fulfilled
-				rl.timeStamps = rl.timeStamps[:totalTokensInWindow-reqTokensCh.tokens]
-			}
-			reqTokensCh.resCh <- resp
-			close(reqTokensCh.resCh)
-		}
+func (rl *TokenBucket) maxTokens() int {
+	return rl.capacity
+}
+
+func (rl *TokenBucket) sustainedRate() float64 {
+	return perSecond(float64(rl.refillTokens), rl.refillPeriod)
+}
+
+// budget counts the tokens the refill accrues from the carried over part of a token onwards, the tokens reserved
+// for high priority requests are never part of it
+func (rl *TokenBucket) budget(currentTime time.Time, horizon time.Duration) int {
+	tokens, lastTime := rl.refilled(currentTime)
+	accrued := 0
+	if rl.refillTokens > 0 && rl.refillPeriod > 0 {
+		elapsed := saturatingAddDuration(currentTime.Sub(lastTime), horizon)
+		accrued = int(mulDiv(int64(elapsed), int64(rl.refillTokens), int64(rl.refillPeriod)))
+	}
+	return saturatingAdd(max(saturatingAdd(tokens, accrued)-rl.reservedFor(PriorityLow), 0), rl.grace.budget(currentTime, horizon))
+}
+
+// AllowPriority is Allow for a request of the given priority. With WithReservedForHighPriority the last reserved
+// tokens in the bucket can only be taken by requests of PriorityHigh or above, while Allow and lower priorities
+// are denied once taking their tokens would dig into the reserve
+func (rl *TokenBucket) AllowPriority(tokens, priority int) bool {
+	if tokens <= 0 {
+		return false
+	}
+	allowed := false
+	rl.exec(func() {
+		allowed = rl.admitWith(rl.now(), tokens, nil, func(currentTime time.Time, cost int) bool {
+			return rl.allowPriority(currentTime, cost, priority)
+		}) == nil
+	})
+	return allowed
+}
+
+// Refund credits tokens back to the bucket, up to its capacity, for an admitted operation that ended up not
+// doing any work
+func (rl *TokenBucket) Refund(tokens int) {
+	if tokens <= 0 {
+		return
+	}
+	rl.exec(func() {
+		rl.tokens, rl.lastTime = rl.refilled(rl.now())
+		rl.tokens += min(tokens, rl.capacity-rl.tokens)
+	})
+}
+
+// AllowTransaction is Allow holding the tokens of an admitted request until the caller either calls commit to keep
+// them consumed once its operation succeeded or rollback to credit them back like Refund. Only the first of the
+// two calls has any effect. A denied request returns ok false along with a commit and rollback that do nothing
+func (rl *TokenBucket) AllowTransaction(tokens int) (commit func(), rollback func(), ok bool) {
+	return transaction(rl.Allow(tokens), tokens, rl.Refund)
+}
+
+// SetRate changes how many tokens the bucket refills every second, or every WithRefillUnit. The tokens accrued so
+// far at the old rate are kept and the new rate applies from now on
+func (rl *TokenBucket) SetRate(tokensPerSecond int) {
+	rl.exec(func() {
+		rl.tokens, rl.lastTime = rl.refilled(rl.now())
+		rl.refillTokens = max(tokensPerSecond, 0)
+		rl.refillPeriod = rl.unit()
+	})
+}
+
+// SetCapacity changes how many tokens the bucket holds, tokens above the new capacity are dropped
+func (rl *TokenBucket) SetCapacity(capacity int) {
+	rl.exec(func() {
+		rl.tokens, rl.lastTime = rl.refilled(rl.now())
+		rl.capacity = max(capacity, 0)
+		rl.tokens = min(rl.tokens, rl.capacity)
+	})
+}
+
+// SetTokens sets how many tokens the bucket holds right now, clamped to [0, capacity], for administrative
+// overrides such as granting an emergency budget. Refilling carries on from the new level
+func (rl *TokenBucket) SetTokens(n int) {
+	rl.exec(func() {
+		rl.tokens, rl.lastTime = rl.refilled(rl.now())
+		rl.tokens = min(max(n, 0), rl.capacity)
+	})
+}
+
+// DrainAll atomically takes every token that a plain Allow could take right now, leaving any high priority
+// reserve and the WithGraceBurst pool in place, and returns how many it took, 0 if the bucket is empty
+func (rl *TokenBucket) DrainAll() int {
+	drained := 0
+	rl.exec(func() {
+		rl.tokens, rl.lastTime = rl.refilled(rl.now())
+		drained = max(rl.tokens-rl.reservedFor(PriorityLow), 0)
+		rl.tokens -= drained
+		rl.record(drained > 0)
+	})
+	return drained
+}
+
+// Acquire atomically takes up to maxTokens of the tokens a plain Allow could take right now, for a worker to claim
+// a batch of permits at once, and returns how many it took along with release, which credits unused ones back
+// like Refund. release can be called any number of times but never gives back more than were granted in total.
+// Nothing is granted once the bucket is empty or for a maxTokens of zero or less
+func (rl *TokenBucket) Acquire(maxTokens int) (granted int, release func(unused int)) {
+	if maxTokens > 0 {
+		rl.exec(func() {
+			rl.tokens, rl.lastTime = rl.refilled(rl.now())
+			granted = min(max(rl.tokens-rl.reservedFor(PriorityLow), 0), maxTokens)
+			rl.tokens -= granted
+			rl.record(granted > 0)
+		})
+	}
+	var mu sync.Mutex
+	outstanding := granted
+	release = func(unused int) {
+		mu.Lock()
+		unused = min(max(unused, 0), outstanding)
+		outstanding -= unused
+		mu.Unlock()
+		rl.Refund(unused)
+	}
+	return granted, release
+}
+
+// Inspect reports, without taking any tokens, how many tokens a plain Allow could take right now, grace pool
+// included, how many of the requested tokens are missing and how long until a request for tokens would be
+// admitted, 0 if it would be admitted right away. retryAfter is InfDuration for requests that can never be
+// admitted, and a stopped limiter reports no tokens available
+func (rl *TokenBucket) Inspect(tokens int) (available int, shortfall int, retryAfter time.Duration) {
+	shortfall, retryAfter = max(tokens, 0), InfDuration
+	rl.exec(func() {
+		currentTime := rl.now()
+		available = max(rl.available(currentTime)-rl.reservedFor(PriorityLow), 0) + rl.grace.available(currentTime)
+		if tokens <= 0 {
+			return
+		}
+		cost := rl.cost(tokens)
+		shortfall = max(rl.clamp(cost, rl.capacity)-available, 0)
+		if next := rl.nextAdmission(currentTime, cost); !next.IsZero() {
+			retryAfter = next.Sub(currentTime)
+		}
+	})
+	return available, shortfall, retryAfter
+}
+
+type LeakyBucket struct {
+	noCopy   noCopy
+	capacity int
+	leakRate int
+	tokens   int
+	lastTime time.Time
+	// lastSeen is the arrival time of the latest request, AllowAt won't go back past it
+	lastSeen time.Time
+	*RateLimiterBase
+}
+
+func NewLeakyBucket(capacity, leakRate int, opts ...Option) RateLimiter {
+	rlBase, ctx := newRateLimiterBase(opts)
+	rl := &LeakyBucket{
+		RateLimiterBase: rlBase,
+		capacity:        capacity,
+		leakRate:        leakRate,
+		tokens:          capacity,
+		lastTime:        rlBase.now(),
+	}
+
+	rl.start(ctx, rl)
+
+	return rl
+}
+
+// leaked returns the level and lastTime after leaking for the whole seconds passed until currentTime, any
+// fraction of a second is carried over to the next leak rather than dropped
+func (rl *LeakyBucket) leaked(currentTime time.Time) (int, time.Time) {
+	secondsPassed := max(int(currentTime.Sub(rl.lastTime)/time.Second), 0)
+	temp := rl.tokens - saturatingMul(secondsPassed, rl.leakRate)
+	if temp <= 0 {
+		// an empty bucket doesn't bank time towards future leaks
+		return 0, currentTime
+	}
+	return temp, rl.lastTime.Add(seconds(secondsPassed))
+}
+
+// allow runs the leaky bucket algorithm for a request of tokens arriving at currentTime
+func (rl *LeakyBucket) allow(currentTime time.Time, tokens int) bool {
+	tokens = rl.clamp(tokens, rl.headroom(0))
+	rl.tokens, rl.lastTime = rl.leaked(currentTime)
+	rl.lastSeen = currentTime
+
+	if tokens <= rl.headroom(rl.tokens) {
+		rl.tokens += tokens
+		return true
+	}
+	return false
+}
+
+// headroom returns how many tokens can be added to a bucket at level, which with WithAdmitWhenExactlyFull(false) has
+// to stay below the capacity
+func (rl *LeakyBucket) headroom(level int) int {
+	if rl.strictFull {
+		return max(rl.capacity-level-1, 0)
+	}
+	return rl.capacity - level
+}
+
+// admits leaves room below the capacity with WithAdmitWhenExactlyFull(false)
+func (rl *LeakyBucket) admits(tokens int) bool {
+	return rl.clamp(tokens, rl.headroom(0)) <= rl.headroom(0)
+}
+
+func (rl *LeakyBucket) nextAvailable(currentTime time.Time, tokens int) time.Time {
+	if !rl.admits(tokens) {
+		return time.Time{}
+	}
+	tokens = rl.clamp(tokens, rl.headroom(0))
+	level, lastTime := rl.leaked(currentTime)
+	if tokens <= rl.headroom(level) {
+		return currentTime
+	}
+	if rl.leakRate <= 0 {
+		return time.Time{}
+	}
+	// leaks happen on whole seconds after lastTime
+	toLeak := tokens - rl.headroom(level)
+	return lastTime.Add(seconds(ceilDiv(toLeak, rl.leakRate)))
+}
+
+func (rl *LeakyBucket) refill(currentTime time.Time) int {
+	before := rl.tokens
+	rl.tokens, rl.lastTime = rl.leaked(currentTime)
+	return before - rl.tokens
+}
+
+func (rl *LeakyBucket) available(currentTime time.Time) int {
+	level, _ := rl.leaked(currentTime)
+	return rl.headroom(level)
+}
+
+func (rl *LeakyBucket) nextReset(currentTime time.Time) time.Time {
+	level, lastTime := rl.leaked(currentTime)
+	if level == 0 {
+		return currentTime
+	}
+	if rl.leakRate <= 0 {
+		return time.Time{}
+	}
+	return lastTime.Add(seconds(ceilDiv(level, rl.leakRate)))
+}
+
+func (rl *LeakyBucket) maxTokens() int {
+	return rl.capacity
+}
+
+func (rl *LeakyBucket) sustainedRate() float64 {
+	return float64(rl.leakRate)
+}
+
+// budget counts the leaks happening on the whole seconds after lastTime, a bucket kept busy never stops leaking
+func (rl *LeakyBucket) budget(currentTime time.Time, horizon time.Duration) int {
+	level, lastTime := rl.leaked(currentTime)
+	leaks := 0
+	if rl.leakRate > 0 {
+		seconds := int(saturatingAddDuration(currentTime.Sub(lastTime), horizon) / time.Second)
+		leaks = saturatingMul(seconds, rl.leakRate)
+	}
+	return saturatingAdd(rl.headroom(level), leaks)
+}
+
+// AllowAt is Allow for a request arriving at now rather than at the clock's current time, which makes it easy to
+// check leak behaviour or replay recorded traffic. The bucket leaks for the time between its last leak and now,
+// and requests stamped earlier than the latest one it has seen are denied
+func (rl *LeakyBucket) AllowAt(now time.Time, tokens int) bool {
+	if tokens <= 0 {
+		return false
+	}
+	allowed := false
+	rl.exec(func() {
+		if now.Before(rl.lastSeen) {
+			rl.record(false)
+			return
+		}
+		allowed = rl.admit(now, tokens, nil) == nil
+	})
+	return allowed
+}
+
+// Refund takes tokens back out of the bucket, down to empty, for an admitted operation that ended up not doing
+// any work
+func (rl *LeakyBucket) Refund(tokens int) {
+	if tokens <= 0 {
+		return
+	}
+	rl.exec(func() {
+		rl.tokens, rl.lastTime = rl.leaked(rl.now())
+		rl.tokens = max(rl.tokens-tokens, 0)
+	})
+}
+
+// AllowTransaction is Allow holding the tokens of an admitted request until the caller either calls commit to keep
+// them in the bucket once its operation succeeded or rollback to take them back out like Refund. Only the first
+// of the two calls has any effect. A denied request returns ok false along with a commit and rollback that do
+// nothing
+func (rl *LeakyBucket) AllowTransaction(tokens int) (commit func(), rollback func(), ok bool) {
+	return transaction(rl.Allow(tokens), tokens, rl.Refund)
+}
+
+// DrainAll atomically fills the bucket up to its capacity, taking all the room left in one go, and returns how
+// many tokens it took, 0 if the bucket is already full
+func (rl *LeakyBucket) DrainAll() int {
+	drained := 0
+	rl.exec(func() {
+		rl.tokens, rl.lastTime = rl.leaked(rl.now())
+		drained = max(rl.capacity-rl.tokens, 0)
+		rl.tokens += drained
+		rl.record(drained > 0)
+	})
+	return drained
+}
+
+// SetCapacity changes how many tokens the bucket holds, a level above the new capacity is clamped down to it
+func (rl *LeakyBucket) SetCapacity(capacity int) {
+	rl.exec(func() {
+		rl.tokens, rl.lastTime = rl.leaked(rl.now())
+		rl.capacity = max(capacity, 0)
+		rl.tokens = min(rl.tokens, rl.capacity)
+	})
+}
+
+// SetLeakRate changes how many tokens leak out every second. The time since the last leak keeps counting
+// towards the next one, which is the first to leak at the new rate
+func (rl *LeakyBucket) SetLeakRate(leakRate int) {
+	rl.exec(func() {
+		rl.tokens, rl.lastTime = rl.leaked(rl.now())
+		rl.leakRate = max(leakRate, 0)
+	})
+}
+
+// FixedWindow admits requests first come, first served: concurrent requests are decided one at a time in the order
+// they reach the limiter's goroutine, each against the tokens left in the window by those before it, so the tokens
+// admitted in a window never add up to more than its capacity. Which of the concurrent requests get in depends on
+// that order, a large request arriving first can use up the room several small ones would have shared
+type FixedWindow struct {
+	noCopy     noCopy
+	tokens     int
+	windowSize time.Duration
+	capacity   int
+	lastTime   time.Time
+	*RateLimiterBase
+}
+
+// NewFixedWindow creates a FixedWindow admitting capacity tokens per window of windowSize seconds, see
+// NewFixedWindowDuration for windows that aren't a whole number of seconds
+func NewFixedWindow(windowSize, capacity int, opts ...Option) RateLimiter {
+	return NewFixedWindowDuration(seconds(windowSize), capacity, opts...)
+}
+
+// NewFixedWindowDuration creates a FixedWindow admitting capacity tokens per window of windowSize
+func NewFixedWindowDuration(windowSize time.Duration, capacity int, opts ...Option) RateLimiter {
+	rlBase, ctx := newRateLimiterBase(opts)
+	rl := &FixedWindow{
+		RateLimiterBase: rlBase,
+		tokens:          capacity,
+		capacity:        capacity,
+		windowSize:      windowSize,
+		lastTime:        rlBase.now(),
+	}
+
+	rl.start(ctx, rl)
+
+	return rl
+}
+
+// allow runs the fixed window algorithm for a request of tokens arriving at currentTime
+func (rl *FixedWindow) allow(currentTime time.Time, tokens int) bool {
+	tokens = rl.clamp(tokens, rl.capacity)
+	resp := false
+	if currentTime.Sub(rl.lastTime) >= rl.windowSize {
+		rl.lastTime = currentTime
+		rl.windowReset(currentTime)
+		rl.tokens = rl.capacity - tokens
+		if rl.tokens < 0 {
+			rl.tokens = rl.capacity
+			resp = false
+		} else {
+			resp = true
+		}
+	} else {
+		if rl.tokens >= tokens {
+			rl.tokens -= tokens
+			resp = true
+		} else {
+			resp = false
+		}
+	}
+	return resp
+}
+
+func (rl *FixedWindow) nextAvailable(currentTime time.Time, tokens int) time.Time {
+	tokens = rl.clamp(tokens, rl.capacity)
+	if tokens > rl.capacity {
+		return time.Time{}
+	}
+	nextWindow := rl.lastTime.Add(rl.windowSize)
+	if !currentTime.Before(nextWindow) || tokens <= rl.tokens {
+		return currentTime
+	}
+	return nextWindow
+}
+
+func (rl *FixedWindow) available(currentTime time.Time) int {
+	if !currentTime.Before(rl.lastTime.Add(rl.windowSize)) {
+		return rl.capacity
+	}
+	return rl.tokens
+}
+
+func (rl *FixedWindow) nextReset(currentTime time.Time) time.Time {
+	nextWindow := rl.lastTime.Add(rl.windowSize)
+	if rl.tokens >= rl.capacity || !currentTime.Before(nextWindow) {
+		return currentTime
+	}
+	return nextWindow
+}
+
+func (rl *FixedWindow) maxTokens() int {
+	return rl.capacity
+}
+
+func (rl *FixedWindow) sustainedRate() float64 {
+	return perSecond(float64(rl.capacity), rl.windowSize)
+}
+
+// budget counts the windows starting within horizon, once the current window is over the next one starts with
+// the next request
+func (rl *FixedWindow) budget(currentTime time.Time, horizon time.Duration) int {
+	if rl.windowSize <= 0 {
+		return math.MaxInt
+	}
+	windows := 0
+	end := rl.lastTime.Add(rl.windowSize)
+	if !currentTime.Before(end) {
+		windows = int(horizon / rl.windowSize)
+	} else if until := end.Sub(currentTime); horizon >= until {
+		windows = 1 + int((horizon-until)/rl.windowSize)
+	}
+	return saturatingAdd(rl.available(currentTime), saturatingMul(windows, rl.capacity))
+}
+
+type SlidingWindow struct {
+	noCopy     noCopy
+	limit      int
+	windowSize time.Duration
+	// timeStamps holds one entry per admitted token, it never grows past limit however many requests are denied
+	timeStamps *timeRing
+	*RateLimiterBase
+}
+
+func NewSlidingWindow(limit int, windowSize time.Duration, opts ...Option) RateLimiter {
+	rlBase, ctx := newRateLimiterBase(opts)
+	rl := &SlidingWindow{
+		RateLimiterBase: rlBase,
+		limit:           limit,
+		windowSize:      windowSize,
+		timeStamps:      newTimeRing(limit),
+	}
+
+	rl.start(ctx, rl)
+
+	return rl
+}
+
+// allow runs the sliding window algorithm for a request of tokens arriving at currentTime
+func (rl *SlidingWindow) allow(currentTime time.Time, tokens int) bool {
+	tokens = rl.clamp(tokens, rl.limit)
+	wasEmpty := rl.timeStamps.len() == 0
+	// expired timestamps are only ever at the front, so each one is popped once and pruning is amortized O(1)
+	for rl.timeStamps.len() > 0 && rl.timeStamps.at(0).Before(currentTime.Add(-rl.windowSize)) {
+		rl.timeStamps.pop()
+	}
+	if !wasEmpty && rl.timeStamps.len() == 0 {
+		rl.windowReset(currentTime)
+	}
+	if tokens > rl.limit-rl.timeStamps.len() {
+		return false
+	}
+	// push as many entries as tokens requested
+	for i := 0; i < tokens; i++ {
+		rl.timeStamps.push(currentTime)
+	}
+	return true
+}
+
+func (rl *SlidingWindow) nextAvailable(currentTime time.Time, tokens int) time.Time {
+	tokens = rl.clamp(tokens, rl.limit)
+	if tokens > rl.limit {
+		return time.Time{}
+	}
+	start := rl.windowStart(currentTime)
+	toExpire := rl.timeStamps.len() - start + tokens - rl.limit
+	if toExpire <= 0 {
+		return currentTime
+	}
+	// a timestamp stops counting once it is strictly older than the window
+	return rl.timeStamps.at(start + toExpire - 1).Add(rl.windowSize + time.Nanosecond)
+}
+
+// windowStart returns the index of the first timestamp that hasn't slid out of the window at currentTime. The
+// timestamps are in order since now never goes back, so it's a binary search rather than a scan of the window
+func (rl *SlidingWindow) windowStart(currentTime time.Time) int {
+	cutoff := currentTime.Add(-rl.windowSize)
+	return sort.Search(rl.timeStamps.len(), func(i int) bool {
+		return !rl.timeStamps.at(i).Before(cutoff)
+	})
+}
+
+func (rl *SlidingWindow) available(currentTime time.Time) int {
+	return rl.limit - (rl.timeStamps.len() - rl.windowStart(currentTime))
+}
+
+func (rl *SlidingWindow) nextReset(currentTime time.Time) time.Time {
+	if rl.windowStart(currentTime) == rl.timeStamps.len() {
+		return currentTime
+	}
+	return rl.timeStamps.at(rl.timeStamps.len() - 1).Add(rl.windowSize + time.Nanosecond)
+}
+
+func (rl *SlidingWindow) maxTokens() int {
+	return rl.limit
+}
+
+func (rl *SlidingWindow) sustainedRate() float64 {
+	return perSecond(float64(rl.limit), rl.windowSize)
+}
+
+// budget counts how often each of the limit tokens can be used within horizon, a token is free again right after
+// its timestamp leaves the window
+func (rl *SlidingWindow) budget(currentTime time.Time, horizon time.Duration) int {
+	period := rl.windowSize + time.Nanosecond
+	uses := func(wait time.Duration) int {
+		if wait > horizon {
+			return 0
+		}
+		return 1 + int((horizon-wait)/period)
+	}
+	budget := saturatingMul(rl.available(currentTime), uses(0))
+	for i := rl.windowStart(currentTime); i < rl.timeStamps.len(); i++ {
+		budget = saturatingAdd(budget, uses(rl.timeStamps.at(i).Add(period).Sub(currentTime)))
+	}
+	return budget
+}
+
+// MinInterval admits one request at a time with at least interval between admitted requests, a request for any
+// positive number of tokens counts as a single request
+type MinInterval struct {
+	noCopy      noCopy
+	interval    time.Duration
+	lastAllowed time.Time
+	*RateLimiterBase
+}
+
+func NewMinInterval(interval time.Duration, opts ...Option) RateLimiter {
+	rlBase, ctx := newRateLimiterBase(opts)
+	rl := &MinInterval{
+		RateLimiterBase: rlBase,
+		interval:        interval,
+	}
+
+	rl.start(ctx, rl)
+
+	return rl
+}
+
+// allow admits the request if at least interval has passed since the last admitted one
+func (rl *MinInterval) allow(currentTime time.Time, tokens int) bool {
+	if currentTime.Before(rl.nextAvailable(currentTime, tokens)) {
+		return false
+	}
+	rl.lastAllowed = currentTime
+	return true
+}
+
+func (rl *MinInterval) nextAvailable(currentTime time.Time, tokens int) time.Time {
+	if rl.lastAllowed.IsZero() {
+		return currentTime
+	}
+	next := rl.lastAllowed.Add(rl.interval)
+	if !currentTime.Before(next) {
+		return currentTime
+	}
+	return next
+}
+
+func (rl *MinInterval) available(currentTime time.Time) int {
+	if currentTime.Before(rl.nextAvailable(currentTime, 1)) {
+		return 0
+	}
+	return 1
+}
+
+func (rl *MinInterval) nextReset(currentTime time.Time) time.Time {
+	return rl.nextAvailable(currentTime, 1)
+}
+
+func (rl *MinInterval) maxTokens() int {
+	return 1
+}
+
+// admits any request, whatever its cost it counts as a single one
+func (rl *MinInterval) admits(tokens int) bool {
+	return true
+}
+
+func (rl *MinInterval) sustainedRate() float64 {
+	return perSecond(1, rl.interval)
+}
+
+func (rl *MinInterval) budget(currentTime time.Time, horizon time.Duration) int {
+	if rl.interval <= 0 {
+		return math.MaxInt
+	}
+	wait := rl.nextAvailable(currentTime, 1).Sub(currentTime)
+	if wait > horizon {
+		return 0
+	}
+	return 1 + int((horizon-wait)/rl.interval)
+}
+
+// decayEpsilon is how far below zero a DecayingWindow's count has to decay to count as empty, it absorbs float
+// rounding and lets a request for the whole limit through once the count has all but decayed away
+const decayEpsilon = 1e-6
+
+// DecayingWindow keeps a running count of the tokens it admitted that decays exponentially with time, halving
+// every halfLife, and admits a request as long as the decayed count plus the request stays within limit. Unlike a
+// sliding window's log its state is a single number, and it has no window edges for bursts to line up on
+type DecayingWindow struct {
+	noCopy   noCopy
+	limit    int
+	halfLife time.Duration
+	count    float64
+	lastTime time.Time
+	*RateLimiterBase
+}
+
+func NewDecayingWindow(limit int, halfLife time.Duration, opts ...Option) RateLimiter {
+	rlBase, ctx := newRateLimiterBase(opts)
+	rl := &DecayingWindow{
+		RateLimiterBase: rlBase,
+		limit:           limit,
+		halfLife:        halfLife,
+		lastTime:        rlBase.now(),
+	}
+
+	rl.start(ctx, rl)
+
+	return rl
+}
+
+// decayed returns the count decayed until currentTime
+func (rl *DecayingWindow) decayed(currentTime time.Time) float64 {
+	if rl.halfLife <= 0 {
+		return 0
+	}
+	elapsed := currentTime.Sub(rl.lastTime)
+	return rl.count * math.Exp2(-float64(elapsed)/float64(rl.halfLife))
+}
+
+// allow decays the count to currentTime and adds the request to it if it stays within the limit
+func (rl *DecayingWindow) allow(currentTime time.Time, tokens int) bool {
+	tokens = rl.clamp(tokens, rl.limit)
+	rl.count, rl.lastTime = rl.decayed(currentTime), currentTime
+	if rl.count+float64(tokens) > float64(rl.limit)+decayEpsilon {
+		return false
+	}
+	rl.count += float64(tokens)
+	return true
+}
+
+func (rl *DecayingWindow) nextAvailable(currentTime time.Time, tokens int) time.Time {
+	tokens = rl.clamp(tokens, rl.limit)
+	if tokens > rl.limit {
+		return time.Time{}
+	}
+	return rl.decayedTo(currentTime, float64(rl.limit-tokens)+decayEpsilon)
+}
+
+// decayedTo returns when the count will have decayed to at most target
+func (rl *DecayingWindow) decayedTo(currentTime time.Time, target float64) time.Time {
+	count := rl.decayed(currentTime)
+	if count <= target {
+		return currentTime
+	}
+	halfLives := math.Log2(count / target)
+	return currentTime.Add(time.Duration(math.Ceil(halfLives * float64(rl.halfLife))))
+}
+
+func (rl *DecayingWindow) available(currentTime time.Time) int {
+	return max(int(math.Floor(float64(rl.limit)-rl.decayed(currentTime)+decayEpsilon)), 0)
+}
+
+func (rl *DecayingWindow) nextReset(currentTime time.Time) time.Time {
+	return rl.decayedTo(currentTime, decayEpsilon)
+}
+
+func (rl *DecayingWindow) maxTokens() int {
+	return rl.limit
+}
+
+// sustainedRate is the rate that holds the count steady at the limit, where admissions make up for exactly the
+// limit*ln2/halfLife per second the count decays by
+func (rl *DecayingWindow) sustainedRate() float64 {
+	return perSecond(float64(rl.limit)*math.Ln2, rl.halfLife)
+}
+
+// budget counts what decays from a count kept just below the limit, which is what requests of a token each can
+// make use of, so it errs on the side of admitting a little more than promised
+func (rl *DecayingWindow) budget(currentTime time.Time, horizon time.Duration) int {
+	if rl.halfLife <= 0 {
+		return math.MaxInt
+	}
+	decay := float64(max(rl.limit-1, 0)) * math.Ln2 * float64(horizon) / float64(rl.halfLife)
+	if decay >= math.MaxInt64 {
+		return math.MaxInt
+	}
+	return saturatingAdd(rl.available(currentTime), int(decay))
+}
+
+// EventBucket is a token bucket without any time-based refill, its tokens are only granted through Credit by
+// external events such as a downstream call completing, which models credit-based flow control. As time alone
+// never frees tokens up, Wait fails with ErrNeverAvailable rather than blocking for a credit
+type EventBucket struct {
+	noCopy   noCopy
+	capacity int
+	tokens   int
+	*RateLimiterBase
+}
+
+// NewEventBucket creates an EventBucket holding up to capacity tokens, which starts full so the first capacity
+// tokens can be spent before any credit arrives
+func NewEventBucket(capacity int, opts ...Option) RateLimiter {
+	rlBase, ctx := newRateLimiterBase(opts)
+	rl := &EventBucket{
+		RateLimiterBase: rlBase,
+		capacity:        capacity,
+		tokens:          capacity,
+	}
+
+	rl.start(ctx, rl)
+
+	return rl
+}
+
+// Credit grants n tokens, up to the capacity
+func (rl *EventBucket) Credit(n int) {
+	if n <= 0 {
+		return
+	}
+	rl.exec(func() {
+		rl.tokens += min(n, rl.capacity-rl.tokens)
+	})
+}
+
+func (rl *EventBucket) allow(currentTime time.Time, tokens int) bool {
+	tokens = rl.clamp(tokens, rl.capacity)
+	if tokens > rl.tokens {
+		return false
+	}
+	rl.tokens -= tokens
+	return true
+}
+
+func (rl *EventBucket) nextAvailable(currentTime time.Time, tokens int) time.Time {
+	if rl.clamp(tokens, rl.capacity) > rl.tokens {
+		return time.Time{}
+	}
+	return currentTime
+}
+
+func (rl *EventBucket) available(currentTime time.Time) int {
+	return rl.tokens
+}
+
+func (rl *EventBucket) nextReset(currentTime time.Time) time.Time {
+	if rl.tokens < rl.capacity {
+		return time.Time{}
+	}
+	return currentTime
+}
+
+func (rl *EventBucket) maxTokens() int {
+	return rl.capacity
+}
+
+// sustainedRate is 0, the bucket never refills by itself
+func (rl *EventBucket) sustainedRate() float64 {
+	return 0
+}
+
+// budget is only the tokens held now, credits can't be foreseen
+func (rl *EventBucket) budget(currentTime time.Time, horizon time.Duration) int {
+	return rl.tokens
+}
+
+// baselineSlots is how many slots a BaselineLimiter's window is split into
+const baselineSlots = 10
+
+// BaselineLimiter admits traffic relative to its own recent past instead of a fixed ceiling: each slot of a tenth
+// of the window may admit up to multiplier times the average a slot admitted over the window before it, so normal
+// traffic and gradual growth get through while sudden spikes are clamped. It admits everything during the first
+// window, while it learns the baseline, and at least one token per slot afterwards so traffic can pick up again
+// after a quiet spell
+type BaselineLimiter struct {
+	noCopy     noCopy
+	multiplier float64
+	slot       time.Duration
+	// history holds the tokens admitted in each of the last baselineSlots slots, oldest first
+	history []int
+	// current is the tokens admitted in the slot starting at slotStart
+	current   int
+	slotStart time.Time
+	*RateLimiterBase
+}
+
+// NewBaselineLimiter creates a BaselineLimiter denying requests once the slot's rate would exceed multiplier times
+// the average rate over the last window
+func NewBaselineLimiter(multiplier float64, window time.Duration, opts ...Option) RateLimiter {
+	rlBase, ctx := newRateLimiterBase(opts)
+	rl := &BaselineLimiter{
+		RateLimiterBase: rlBase,
+		multiplier:      multiplier,
+		slot:            max(window/baselineSlots, 1),
+		slotStart:       rlBase.now(),
+	}
+
+	rl.start(ctx, rl)
+
+	return rl
+}
+
+// rolled returns the history, the current slot's tokens and the slot start as of currentTime, after moving the
+// finished slots into the history
+func (rl *BaselineLimiter) rolled(currentTime time.Time) ([]int, int, time.Time) {
+	elapsed := currentTime.Sub(rl.slotStart)
+	if elapsed < rl.slot {
+		return rl.history, rl.current, rl.slotStart
+	}
+	passed := elapsed / rl.slot
+	history := append(append([]int(nil), rl.history...), rl.current)
+	for i := time.Duration(1); i < min(passed, baselineSlots); i++ {
+		history = append(history, 0)
+	}
+	history = history[max(len(history)-baselineSlots, 0):]
+	return history, 0, rl.slotStart.Add(passed * rl.slot)
+}
+
+// limit returns how many tokens a slot may admit after history, math.MaxInt while the baseline is still learned
+func (rl *BaselineLimiter) limit(history []int) int {
+	if len(history) < baselineSlots {
+		return math.MaxInt
+	}
+	sum := 0
+	for _, n := range history {
+		sum += n
+	}
+	return max(int(rl.multiplier*float64(sum)/baselineSlots), 1)
+}
+
+// allow rolls the slots forward to currentTime and admits the request if the slot stays within the limit
+func (rl *BaselineLimiter) allow(currentTime time.Time, tokens int) bool {
+	rl.history, rl.current, rl.slotStart = rl.rolled(currentTime)
+	limit := rl.limit(rl.history)
+	tokens = rl.clamp(tokens, limit)
+	if tokens > limit-rl.current {
+		return false
+	}
+	rl.current += tokens
+	return true
+}
+
+// nextAvailable looks ahead slot by slot, the limit only changes when a slot ends and stops changing once a whole
+// window has passed without admissions
+func (rl *BaselineLimiter) nextAvailable(currentTime time.Time, tokens int) time.Time {
+	history, current, slotStart := rl.rolled(currentTime)
+	for i := 0; i <= baselineSlots; i++ {
+		limit := rl.limit(history)
+		if rl.clamp(tokens, limit) <= limit-current {
+			if i == 0 {
+				return currentTime
+			}
+			return slotStart
+		}
+		history = append(append([]int(nil), history...), current)[max(len(history)+1-baselineSlots, 0):]
+		current, slotStart = 0, slotStart.Add(rl.slot)
+	}
+	return time.Time{}
+}
+
+func (rl *BaselineLimiter) available(currentTime time.Time) int {
+	history, current, _ := rl.rolled(currentTime)
+	return max(rl.limit(history)-current, 0)
+}
+
+func (rl *BaselineLimiter) nextReset(currentTime time.Time) time.Time {
+	_, current, slotStart := rl.rolled(currentTime)
+	if current == 0 {
+		return currentTime
+	}
+	return slotStart.Add(rl.slot)
+}
+
+func (rl *BaselineLimiter) maxTokens() int {
+	history, _, _ := rl.rolled(rl.now())
+	return rl.limit(history)
+}
+
+// sustainedRate is infinite, a BaselineLimiter has no fixed ceiling and follows traffic that grows gradually
+func (rl *BaselineLimiter) sustainedRate() float64 {
+	return math.Inf(1)
+}
+
+// budget counts the slots starting within horizon at the current limit, as if the baseline stayed where it is
+func (rl *BaselineLimiter) budget(currentTime time.Time, horizon time.Duration) int {
+	history, current, slotStart := rl.rolled(currentTime)
+	limit := rl.limit(history)
+	if limit == math.MaxInt {
+		return math.MaxInt
+	}
+	slots := 0
+	if until := slotStart.Add(rl.slot).Sub(currentTime); horizon >= until {
+		slots = 1 + int((horizon-until)/rl.slot)
 	}
+	return saturatingAdd(max(limit-current, 0), saturatingMul(slots, limit))
 }
 
 func main() {
@@ -1,7 +1,13 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -67,19 +73,46 @@ func TestTokenBucket_Concurrency(t *testing.T) {
 
 	wg.Wait()
 	close(results)
-	i := 0
+	// whichever order the requests are served in, the first four fit in the bucket and the last one doesn't
+	successCount := 0
 	for res := range results {
-		if i < len(tokens)-1 {
-			if !res {
-				t.Errorf("Expected token to be allowed but it was denied")
-			}
-		} else {
-			if res {
-				t.Errorf("Expected token to be denied but it was allowed")
-			}
+		if res {
+			successCount++
 		}
+	}
+
+	if successCount != len(tokens)-1 {
+		t.Errorf("Expected %d successful requests, but got %d", len(tokens)-1, successCount)
+	}
+}
 
-		i += 1
+func TestTokenBucket_ConcurrencySerialized(t *testing.T) {
+	// requests that would race if made from separate goroutines, allowSeq decides them as one batch on the
+	// limiter's goroutine in the given order, so the results don't depend on the scheduler
+	tests := []struct {
+		name     string
+		requests []int
+		want     []bool
+	}{
+		{"Request 1, 2, 3, 4, 1 tokens in order, expect the last one denied", []int{1, 2, 3, 4, 1}, []bool{true, true, true, true, false}},
+		{"Request 4, 3, 2, 1, 1 tokens in order, expect the last one denied", []int{4, 3, 2, 1, 1}, []bool{true, true, true, true, false}},
+		{"Request 1, 1, 2, 3, 4 tokens in order, expect the 4 denied", []int{1, 1, 2, 3, 4}, []bool{true, true, true, true, false}},
+		{"Request 4, 4, 1, 2, 1 tokens in order, expect the 2 denied", []int{4, 4, 1, 2, 1}, []bool{true, true, true, false, true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the clock never moves, so no tokens are refilled while the requests are decided
+			rl := NewTokenBucket(10, 5, 10, WithClock(newFakeClock())).(*TokenBucket)
+			defer rl.Stop()
+			for run := 0; run < 20; run++ {
+				rl.SetTokens(10)
+				got := rl.allowSeq(tt.requests)
+				if !slices.Equal(got, tt.want) {
+					t.Fatalf("run %d: results = %v, want %v", run, got, tt.want)
+				}
+			}
+		})
 	}
 }
 
@@ -147,19 +180,16 @@ func TestLeakyBucket_Concurrency(t *testing.T) {
 
 	wg.Wait()
 	close(results)
-	i := 0
+	// whichever order the requests are served in, the first four fit in the bucket and the last one doesn't
+	successCount := 0
 	for res := range results {
-		if i < len(tokens)-1 {
-			if !res {
-				t.Errorf("Expected token to be allowed but it was denied")
-			}
-		} else {
-			if res {
-				t.Errorf("Expected token to be denied but it was allowed")
-			}
+		if res {
+			successCount++
 		}
+	}
 
-		i += 1
+	if successCount != len(tokens)-1 {
+		t.Errorf("Expected %d successful requests, but got %d", len(tokens)-1, successCount)
 	}
 }
 
@@ -197,6 +227,62 @@ func TestFixedWindow_Allow(t *testing.T) {
 	rl.Stop()
 }
 
+func TestFixedWindowDuration_Resets(t *testing.T) {
+	// step advances the clock by advance and then requests a token
+	type step struct {
+		advance time.Duration
+		want    bool
+	}
+	tests := []struct {
+		name       string
+		windowSize time.Duration
+		steps      []step
+	}{
+		{
+			"Window of 250ms, expect a full window again once 250ms have passed",
+			250 * time.Millisecond,
+			[]step{
+				{0, true},
+				{100 * time.Millisecond, true},
+				{100 * time.Millisecond, false},
+				{49 * time.Millisecond, false},
+				{time.Millisecond, true},
+				{0, true},
+				{0, false},
+			},
+		},
+		{
+			"Window of 2.5s, expect no reset after 2s and a full window again once 2.5s have passed",
+			2500 * time.Millisecond,
+			[]step{
+				{0, true},
+				{0, true},
+				{0, false},
+				{2 * time.Second, false},
+				{499 * time.Millisecond, false},
+				{time.Millisecond, true},
+				{0, true},
+				{0, false},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			rl := NewFixedWindowDuration(tt.windowSize, 2, WithClock(clock))
+			defer rl.Stop()
+
+			for i, step := range tt.steps {
+				clock.Advance(step.advance)
+				if got := rl.Allow(1); got != step.want {
+					t.Errorf("step %d: Allow(1) = %v, want %v", i, got, step.want)
+				}
+			}
+		})
+	}
+}
+
 func TestFixedWindow_Stop(t *testing.T) {
 	rl := NewFixedWindow(1, 10)
 	rl.Stop()
@@ -208,7 +294,8 @@ func TestFixedWindow_Stop(t *testing.T) {
 
 func TestFixedWindow_Concurrency(t *testing.T) {
 	wg := &sync.WaitGroup{}
-	rl := NewFixedWindow(1, 10)
+	// a fake clock keeps every request in the same window
+	rl := NewFixedWindow(1, 10, WithClock(newFakeClock()))
 	numRequests := 10
 	results := make([]bool, numRequests)
 
@@ -224,9 +311,68 @@ func TestFixedWindow_Concurrency(t *testing.T) {
 
 	wg.Wait()
 
-	// expecting exactly 10 successful requests and the rest to be denied
+	// the number of successes depends on the order the requests are served in, but they can never add up to more
+	// than the window's capacity. TestFixedWindow_AllowSeq pins down the outcome for a fixed order
+	admittedTokens := 0
+	for index, allowed := range results {
+		if allowed {
+			admittedTokens += index
+		}
+	}
+
+	if admittedTokens == 0 || admittedTokens > 10 {
+		t.Errorf("Expected between 1 and 10 admitted tokens, but got %d", admittedTokens)
+	}
+	rl.Stop()
+}
+
+func TestFixedWindow_ConcurrencyStress(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for round := 0; round < 50; round++ {
+		limit := 1 + r.Intn(50)
+		costs := make([]int, 1+r.Intn(40))
+		for i := range costs {
+			costs[i] = r.Intn(limit + 5)
+		}
+
+		rl := NewFixedWindowDuration(time.Second, limit, WithClock(newFakeClock())).(*FixedWindow)
+		var admitted atomic.Int64
+		var wg sync.WaitGroup
+		for _, cost := range costs {
+			wg.Add(1)
+			go func(cost int) {
+				defer wg.Done()
+				if rl.Allow(cost) {
+					admitted.Add(int64(cost))
+				}
+			}(cost)
+		}
+		wg.Wait()
+
+		if got := int(admitted.Load()); got > limit {
+			t.Errorf("round %d: admitted %d tokens of costs %v, want at most the limit of %d", round, got, costs, limit)
+		}
+		// whatever the order, every token admitted is one the window no longer has
+		if got, want := rl.Tokens(), limit-int(admitted.Load()); got != want {
+			t.Errorf("round %d: Tokens() = %d, want %d", round, got, want)
+		}
+		rl.Stop()
+	}
+}
+
+func TestFixedWindow_AllowSeq(t *testing.T) {
+	rl := NewFixedWindow(1, 10).(*FixedWindow)
+	defer rl.Stop()
+
+	// requests for 0 to 9 tokens served in ascending order: 0 is invalid, 1+2+3+4 fill the window and 5+ don't fit
+	results := rl.allowSeq([]int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9})
+	want := []bool{false, true, true, true, true, false, false, false, false, false}
+
 	successCount := 0
-	for _, allowed := range results {
+	for i, allowed := range results {
+		if allowed != want[i] {
+			t.Errorf("Allow(%d) = %v, want %v", i, allowed, want[i])
+		}
 		if allowed {
 			successCount++
 		}
@@ -235,7 +381,17 @@ func TestFixedWindow_Concurrency(t *testing.T) {
 	if successCount != 4 {
 		t.Errorf("Expected 4 successful requests, but got %d", successCount)
 	}
+}
+
+func TestAllowSeq_Stopped(t *testing.T) {
+	rl := NewTokenBucket(10, 5, 10).(*TokenBucket)
 	rl.Stop()
+
+	for i, allowed := range rl.allowSeq([]int{1, 2}) {
+		if allowed {
+			t.Errorf("request %d allowed after Stop(), want denied", i)
+		}
+	}
 }
 
 func TestSlidingWindow_Allow(t *testing.T) {
@@ -314,3 +470,1605 @@ func TestSlidingWindow_Concurrency(t *testing.T) {
 	}
 	rl.Stop()
 }
+
+func TestNextAvailable(t *testing.T) {
+	tests := []struct {
+		name   string
+		newRL  func(clock Clock) RateLimiter
+		setup  func(rl RateLimiter, clock *fakeClock)
+		tokens int
+		want   time.Duration
+	}{
+		{
+			"TokenBucket, request 3 tokens from an empty bucket, expect available after 600 milliseconds",
+			func(clock Clock) RateLimiter { return NewTokenBucket(10, 5, 0, WithClock(clock)) },
+			func(rl RateLimiter, clock *fakeClock) {},
+			3, 600 * time.Millisecond,
+		},
+		{
+			"TokenBucket, request 8 tokens after 1.5 seconds, expect available after 1.6 seconds",
+			func(clock Clock) RateLimiter { return NewTokenBucket(10, 5, 0, WithClock(clock)) },
+			func(rl RateLimiter, clock *fakeClock) {
+				clock.Advance(1500 * time.Millisecond)
+				rl.Allow(10)
+			},
+			8, 1600 * time.Millisecond,
+		},
+		{
+			"LeakyBucket, request 7 tokens from a full bucket, expect available after 2 seconds",
+			func(clock Clock) RateLimiter { return NewLeakyBucket(10, 5, WithClock(clock)) },
+			func(rl RateLimiter, clock *fakeClock) {},
+			7, 2 * time.Second,
+		},
+		{
+			"FixedWindow, request 5 tokens with 2 left in the window, expect available in the next window",
+			func(clock Clock) RateLimiter { return NewFixedWindow(2, 10, WithClock(clock)) },
+			func(rl RateLimiter, clock *fakeClock) { rl.Allow(8) },
+			5, 2 * time.Second,
+		},
+		{
+			"SlidingWindow, request 3 tokens in a full window, expect available once the first 3 slide out",
+			func(clock Clock) RateLimiter { return NewSlidingWindow(5, time.Second, WithClock(clock)) },
+			func(rl RateLimiter, clock *fakeClock) {
+				rl.Allow(3)
+				clock.Advance(300 * time.Millisecond)
+				rl.Allow(2)
+			},
+			3, time.Second + time.Nanosecond,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			start := clock.Now()
+			rl := tt.newRL(clock)
+			defer rl.Stop()
+			tt.setup(rl, clock)
+
+			next := rl.(interface{ NextAvailable(int) time.Time }).NextAvailable(tt.tokens)
+			if want := start.Add(tt.want); !next.Equal(want) {
+				t.Fatalf("NextAvailable(%d) = %v, want %v", tt.tokens, next.Sub(start), tt.want)
+			}
+
+			clock.Advance(next.Sub(clock.Now()) - time.Nanosecond)
+			if rl.Allow(tt.tokens) {
+				t.Errorf("Allow(%d) just before NextAvailable = true, want false", tt.tokens)
+			}
+			clock.Advance(time.Nanosecond)
+			if !rl.Allow(tt.tokens) {
+				t.Errorf("Allow(%d) at NextAvailable = false, want true", tt.tokens)
+			}
+		})
+	}
+}
+
+func TestNextAvailable_Never(t *testing.T) {
+	clock := newFakeClock()
+	rl := NewTokenBucket(10, 5, 10, WithClock(clock)).(*TokenBucket)
+
+	tests := []struct {
+		name   string
+		tokens int
+		want   time.Time
+	}{
+		{"Request 1 token, expect available now", 1, clock.Now()},
+		{"Request 11 tokens, expect never (exceeds capacity)", 11, time.Time{}},
+		{"Request 0 tokens, expect never (invalid request)", 0, time.Time{}},
+		{"Request -1 tokens, expect never (invalid request)", -1, time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rl.NextAvailable(tt.tokens); !got.Equal(tt.want) {
+				t.Errorf("NextAvailable(%d) = %v, want %v", tt.tokens, got, tt.want)
+			}
+		})
+	}
+
+	rl.Stop()
+	if got := rl.NextAvailable(1); !got.IsZero() {
+		t.Errorf("NextAvailable(1) after Stop() = %v, want zero time", got)
+	}
+}
+
+func TestTokenBucketPerDuration_SteadyStateRate(t *testing.T) {
+	tests := []struct {
+		name    string
+		n       int
+		per     time.Duration
+		burst   int
+		step    time.Duration
+		periods int
+	}{
+		{"100 requests per minute with a burst of 10", 100, time.Minute, 10, 100 * time.Millisecond, 5},
+		{"3 requests per second with a burst of 3", 3, time.Second, 3, 10 * time.Millisecond, 20},
+		{"7 requests per 250 milliseconds with a burst of 7", 7, 250 * time.Millisecond, 7, time.Millisecond, 40},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			rl := NewTokenBucketPerDuration(tt.n, tt.per, tt.burst, WithClock(clock))
+			defer rl.Stop()
+
+			// the bucket starts full, spend the burst before measuring
+			if !rl.Allow(tt.burst) {
+				t.Fatalf("Allow(%d) = false, want the initial burst to be available", tt.burst)
+			}
+
+			admitted := 0
+			end := clock.Now().Add(time.Duration(tt.periods) * tt.per)
+			for clock.Now().Before(end) {
+				clock.Advance(tt.step)
+				for rl.Allow(1) {
+					admitted++
+				}
+			}
+
+			if want := tt.n * tt.periods; admitted < want-1 || admitted > want {
+				t.Errorf("admitted %d tokens over %d periods, want %d (rate %d per %v)", admitted, tt.periods, want, tt.n, tt.per)
+			}
+		})
+	}
+}
+
+func TestDecayingWindow_Decay(t *testing.T) {
+	clock := newFakeClock()
+	rl := NewDecayingWindow(10, time.Second, WithClock(clock)).(*DecayingWindow)
+	defer rl.Stop()
+
+	// the count of 8 admitted tokens halves every second, leaving the rest of the limit available
+	if !rl.Allow(8) {
+		t.Fatalf("Allow(8) = false, want true")
+	}
+	tests := []struct {
+		advance    time.Duration
+		wantTokens int
+	}{
+		{0, 2},
+		{time.Second, 6},
+		{time.Second, 8},
+		{time.Second, 9},
+		{500 * time.Millisecond, 9},
+		{500 * time.Millisecond, 9},
+		{30 * time.Second, 10},
+	}
+
+	elapsed := time.Duration(0)
+	for _, tt := range tests {
+		clock.Advance(tt.advance)
+		elapsed += tt.advance
+		if got := rl.Tokens(); got != tt.wantTokens {
+			t.Errorf("Tokens() after %v = %d, want %d", elapsed, got, tt.wantTokens)
+		}
+	}
+}
+
+func TestDecayingWindow_Allow(t *testing.T) {
+	clock := newFakeClock()
+	rl := NewDecayingWindow(10, time.Second, WithClock(clock)).(*DecayingWindow)
+	defer rl.Stop()
+
+	tests := []struct {
+		name    string
+		advance time.Duration
+		tokens  int
+		want    bool
+	}{
+		{"Request 8 tokens, expect allowed (count 8)", 0, 8, true},
+		{"Request 3 tokens, expect denied (count would be 11)", 0, 3, false},
+		{"Request 6 tokens a half life later, expect allowed (count 4 + 6 = 10)", time.Second, 6, true},
+		{"Request 1 token, expect denied at the limit", 0, 1, false},
+		{"Request 5 tokens a half life later, expect allowed (count 5 + 5 = 10)", time.Second, 5, true},
+		{"Request 11 tokens, expect denied (exceeds the limit)", 30 * time.Second, 11, false},
+		{"Request 10 tokens once the count has decayed, expect allowed", 0, 10, true},
+		{"Request 0 tokens, expect denied (invalid request)", 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock.Advance(tt.advance)
+			if got := rl.Allow(tt.tokens); got != tt.want {
+				t.Errorf("Allow(%d) = %v, want %v", tt.tokens, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecayingWindow_NextAvailable(t *testing.T) {
+	clock := newFakeClock()
+	rl := NewDecayingWindow(10, time.Second, WithClock(clock)).(*DecayingWindow)
+	defer rl.Stop()
+
+	rl.Allow(8)
+	// 8 tokens on top of a count of 8 need it to decay to 2, which takes two half lives give or take float rounding
+	next := rl.NextAvailable(8)
+	if got, want := next.Sub(clock.Now()), 2*time.Second; got < want-time.Millisecond || got > want+time.Millisecond {
+		t.Errorf("NextAvailable(8) = now + %v, want now + %v", got, want)
+	}
+	clock.Advance(next.Sub(clock.Now()) - time.Millisecond)
+	if rl.Allow(8) {
+		t.Errorf("Allow(8) just before NextAvailable = true, want false")
+	}
+	clock.Advance(time.Millisecond)
+	if !rl.Allow(8) {
+		t.Errorf("Allow(8) at NextAvailable = false, want true")
+	}
+	if got := rl.NextAvailable(11); !got.IsZero() {
+		t.Errorf("NextAvailable(11) = %v, want zero time", got)
+	}
+}
+
+func TestTokenBucket_HighRate(t *testing.T) {
+	tests := []struct {
+		name     string
+		newRL    func(clock Clock) RateLimiter
+		step     time.Duration
+		interval time.Duration
+		want     int
+	}{
+		{
+			"100k tokens per second polled every 7µs, expect 5000 tokens in 50ms",
+			func(clock Clock) RateLimiter { return NewTokenBucket(100, 100_000, 0, WithClock(clock)) },
+			7 * time.Microsecond, 50 * time.Millisecond, 5000,
+		},
+		{
+			"1000003 tokens per 10s polled every 3µs, expect 2000 tokens in 20ms",
+			func(clock Clock) RateLimiter {
+				rl := NewTokenBucketPerDuration(1_000_003, 10*time.Second, 100, WithClock(clock))
+				rl.Allow(100)
+				return rl
+			},
+			3 * time.Microsecond, 20 * time.Millisecond, 2000,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			rl := tt.newRL(clock)
+			defer rl.Stop()
+
+			admitted := 0
+			end := clock.Now().Add(tt.interval)
+			for clock.Now().Before(end) {
+				clock.Advance(tt.step)
+				for rl.Allow(1) {
+					admitted++
+				}
+			}
+			if admitted < tt.want-1 || admitted > tt.want {
+				t.Errorf("admitted %d tokens in %v, want %d", admitted, tt.interval, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenBucket_Refund(t *testing.T) {
+	rl := NewTokenBucket(10, 5, 8, WithClock(newFakeClock())).(*TokenBucket)
+	defer rl.Stop()
+
+	tests := []struct {
+		name   string
+		op     func()
+		wantTo int
+	}{
+		{"Allow 5 tokens, expect 3 left", func() { rl.Allow(5) }, 3},
+		{"Refund 5 tokens, expect balance back to 8", func() { rl.Refund(5) }, 8},
+		{"Refund 5 more tokens, expect balance clamped at capacity", func() { rl.Refund(5) }, 10},
+		{"Refund 0 tokens, expect balance unchanged", func() { rl.Refund(0) }, 10},
+		{"Refund -1 tokens, expect balance unchanged", func() { rl.Refund(-1) }, 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.op()
+			if got, _, _ := limiterLevel(rl); got != tt.wantTo {
+				t.Errorf("tokens = %d, want %d", got, tt.wantTo)
+			}
+		})
+	}
+}
+
+func TestLeakyBucket_Refund(t *testing.T) {
+	clock := newFakeClock()
+	rl := NewLeakyBucket(10, 5, WithClock(clock)).(*LeakyBucket)
+	defer rl.Stop()
+	// let the bucket drain completely
+	clock.Advance(2 * time.Second)
+
+	tests := []struct {
+		name   string
+		op     func()
+		wantTo int
+	}{
+		{"Allow 6 tokens, expect level 6", func() { rl.Allow(6) }, 6},
+		{"Refund 6 tokens, expect level back to 0", func() { rl.Refund(6) }, 0},
+		{"Refund 3 more tokens, expect level clamped at empty", func() { rl.Refund(3) }, 0},
+		{"Allow 10 tokens, expect level 10 (full capacity available again)", func() { rl.Allow(10) }, 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.op()
+			if got, _, _ := limiterLevel(rl); got != tt.wantTo {
+				t.Errorf("level = %d, want %d", got, tt.wantTo)
+			}
+		})
+	}
+}
+
+func TestRefund_Concurrency(t *testing.T) {
+	rl := NewTokenBucket(100, 5, 100, WithClock(newFakeClock())).(*TokenBucket)
+	defer rl.Stop()
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// each goroutine speculatively takes 2 tokens and gives them back
+			if rl.Allow(2) {
+				rl.Refund(2)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got, _, _ := limiterLevel(rl); got != 100 {
+		t.Errorf("tokens = %d, want 100", got)
+	}
+}
+
+func TestIntrospection(t *testing.T) {
+	tests := []struct {
+		name         string
+		newRL        func(clock Clock) RateLimiter
+		tokens       int
+		wantCapacity int
+		wantTokens   int
+		wantReset    time.Duration
+	}{
+		{
+			"TokenBucket, expect 6 tokens left and full again in 800 milliseconds",
+			func(clock Clock) RateLimiter { return NewTokenBucket(10, 5, 10, WithClock(clock)) },
+			4, 10, 6, 800 * time.Millisecond,
+		},
+		{
+			"LeakyBucket, expect 0 tokens left and empty again in 2 seconds",
+			func(clock Clock) RateLimiter { return NewLeakyBucket(10, 5, WithClock(clock)) },
+			1, 10, 0, 2 * time.Second,
+		},
+		{
+			"FixedWindow, expect 11 tokens left and full again in the next window",
+			func(clock Clock) RateLimiter { return NewFixedWindow(3, 15, WithClock(clock)) },
+			4, 15, 11, 3 * time.Second,
+		},
+		{
+			"SlidingWindow, expect 9 tokens left and full again once the window slides",
+			func(clock Clock) RateLimiter { return NewSlidingWindow(15, 500*time.Millisecond, WithClock(clock)) },
+			6, 15, 9, 500*time.Millisecond + time.Nanosecond,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			start := clock.Now()
+			rl := tt.newRL(clock)
+			rl.Allow(tt.tokens)
+			in := rl.(Introspector)
+
+			if got := in.Capacity(); got != tt.wantCapacity {
+				t.Errorf("Capacity() = %d, want %d", got, tt.wantCapacity)
+			}
+			if got := in.Tokens(); got != tt.wantTokens {
+				t.Errorf("Tokens() = %d, want %d", got, tt.wantTokens)
+			}
+			if got := in.NextReset(); !got.Equal(start.Add(tt.wantReset)) {
+				t.Errorf("NextReset() = %v, want %v", got.Sub(start), tt.wantReset)
+			}
+
+			clock.Advance(tt.wantReset)
+			if got := in.Tokens(); got != tt.wantCapacity {
+				t.Errorf("Tokens() after reset = %d, want %d", got, tt.wantCapacity)
+			}
+			if got := in.NextReset(); !got.Equal(clock.Now()) {
+				t.Errorf("NextReset() after reset = %v, want now", got.Sub(start))
+			}
+
+			rl.Stop()
+			if got := in.Tokens(); got != 0 {
+				t.Errorf("Tokens() after Stop() = %d, want 0", got)
+			}
+		})
+	}
+}
+
+func TestSlidingWindow_BoundedMemory(t *testing.T) {
+	clock := newFakeClock()
+	limit := 10
+	rl := NewSlidingWindow(limit, time.Second, WithClock(clock)).(*SlidingWindow)
+	defer rl.Stop()
+
+	// hammer the limiter at 100 times its limit, the vast majority of requests are denied
+	for i := 0; i < 10000; i++ {
+		rl.Allow(1 + i%3)
+		clock.Advance(time.Millisecond)
+
+		var length, size int
+		rl.exec(func() { length, size = rl.timeStamps.len(), len(rl.timeStamps.buf) })
+		if length > limit || size != limit {
+			t.Fatalf("after %d requests the buffer holds %d timestamps in %d slots, want at most %d in %d", i+1, length, size, limit, limit)
+		}
+	}
+}
+
+func TestSlidingWindow_SubSecond(t *testing.T) {
+	for _, windowSize := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 250 * time.Millisecond} {
+		t.Run(windowSize.String(), func(t *testing.T) {
+			clock := newFakeClock()
+			limit := 5
+			rl := NewSlidingWindow(limit, windowSize, WithClock(clock))
+			defer rl.Stop()
+
+			// burst 8 requests at the start of each of several windows, only the limit gets through each time
+			for window := 0; window < 4; window++ {
+				allowed := 0
+				for i := 0; i < 8; i++ {
+					if rl.Allow(1) {
+						allowed++
+					}
+				}
+				if allowed != limit {
+					t.Fatalf("window %d: allowed %d requests, want %d", window, allowed, limit)
+				}
+
+				// a timestamp still counts when exactly windowSize old and expires a nanosecond later
+				clock.Advance(windowSize)
+				if rl.Allow(1) {
+					t.Fatalf("window %d: Allow(1) exactly %v after the burst = true, want false", window, windowSize)
+				}
+				clock.Advance(time.Nanosecond)
+			}
+
+			// requests spread across the window slide out one group at a time
+			rl.Allow(2)
+			clock.Advance(windowSize / 2)
+			rl.Allow(3)
+			if rl.Allow(1) {
+				t.Fatalf("Allow(1) with %d requests in the window = true, want false", limit)
+			}
+			clock.Advance(windowSize/2 + time.Nanosecond)
+			if !rl.Allow(2) {
+				t.Fatalf("Allow(2) after the first 2 requests expired = false, want true")
+			}
+			if rl.Allow(1) {
+				t.Fatalf("Allow(1) with the window full again = true, want false")
+			}
+		})
+	}
+}
+
+func TestTokenBucket_AllowPriority(t *testing.T) {
+	rl := NewTokenBucket(10, 5, 10, WithClock(newFakeClock()), WithReservedForHighPriority(3)).(*TokenBucket)
+	defer rl.Stop()
+
+	tests := []struct {
+		name     string
+		tokens   int
+		priority int
+		want     bool
+	}{
+		{"Request 5 low priority tokens, expect allowed (5 left)", 5, PriorityLow, true},
+		{"Request 3 low priority tokens, expect denied (would dig into the reserve of 3)", 3, PriorityLow, false},
+		{"Request 2 low priority tokens, expect allowed (reserve untouched)", 2, PriorityLow, true},
+		{"Request 1 low priority token, expect denied (only the reserve is left)", 1, PriorityLow, false},
+		{"Request 3 high priority tokens, expect allowed (high priority can drain the reserve)", 3, PriorityHigh, true},
+		{"Request 1 high priority token, expect denied (bucket empty)", 1, PriorityHigh, false},
+		{"Request 0 high priority tokens, expect denied (invalid request)", 0, PriorityHigh, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := rl.AllowPriority(tt.tokens, tt.priority)
+			if got != tt.want {
+				t.Errorf("AllowPriority(%d, %d) = %v, want %v", tt.tokens, tt.priority, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenBucket_ReservedForHighPriority_Allow(t *testing.T) {
+	clock := newFakeClock()
+	rl := NewTokenBucket(10, 5, 10, WithClock(clock), WithReservedForHighPriority(3)).(*TokenBucket)
+	defer rl.Stop()
+
+	if !rl.Allow(7) {
+		t.Errorf("Allow(7) = false, want true")
+	}
+	if rl.Allow(1) {
+		t.Errorf("Allow(1) = true, want false (plain Allow is low priority)")
+	}
+	// one more token has to accrue on top of the reserve before a plain Allow of 1 goes through
+	if got, want := rl.NextAvailable(1), clock.Now().Add(200*time.Millisecond); !got.Equal(want) {
+		t.Errorf("NextAvailable(1) = %v, want %v", got, want)
+	}
+}
+
+// allowBenchmarks builds each limiter with enough capacity and refill that most requests in a benchmark take the
+// admitting path
+var allowBenchmarks = []struct {
+	name  string
+	newRL func() RateLimiter
+}{
+	{"TokenBucket", func() RateLimiter { return NewTokenBucket(1_000_000, 1_000_000_000, 1_000_000) }},
+	{"LeakyBucket", func() RateLimiter {
+		clock := newFakeClock()
+		rl := NewLeakyBucket(1_000_000, 1_000_000_000, WithClock(clock))
+		// the leaky bucket starts full, let it drain
+		clock.Advance(time.Second)
+		return rl
+	}},
+	{"FixedWindow", func() RateLimiter { return NewFixedWindow(1, 1_000_000_000) }},
+	{"SlidingWindow", func() RateLimiter { return NewSlidingWindow(100_000, time.Millisecond) }},
+}
+
+func benchmarkAllow(b *testing.B, newRL func() RateLimiter) {
+	rl := newRL()
+	defer rl.Stop()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rl.Allow(1)
+	}
+}
+
+func BenchmarkTokenBucket_Allow(b *testing.B) { benchmarkAllow(b, allowBenchmarks[0].newRL) }
+
+func BenchmarkLeakyBucket_Allow(b *testing.B) { benchmarkAllow(b, allowBenchmarks[1].newRL) }
+
+func BenchmarkFixedWindow_Allow(b *testing.B) { benchmarkAllow(b, allowBenchmarks[2].newRL) }
+
+func BenchmarkSlidingWindow_Allow(b *testing.B) { benchmarkAllow(b, allowBenchmarks[3].newRL) }
+
+// naiveSlidingLog is the textbook sliding window log that rescans every timestamp on each request, it's the
+// reference SlidingWindow is checked and benchmarked against
+type naiveSlidingLog struct {
+	limit      int
+	windowSize time.Duration
+	timeStamps []time.Time
+}
+
+func (l *naiveSlidingLog) allow(currentTime time.Time, tokens int) bool {
+	kept := l.timeStamps[:0]
+	for _, ts := range l.timeStamps {
+		if !ts.Before(currentTime.Add(-l.windowSize)) {
+			kept = append(kept, ts)
+		}
+	}
+	l.timeStamps = kept
+	if tokens > l.limit-len(l.timeStamps) {
+		return false
+	}
+	for i := 0; i < tokens; i++ {
+		l.timeStamps = append(l.timeStamps, currentTime)
+	}
+	return true
+}
+
+func (l *naiveSlidingLog) available(currentTime time.Time) int {
+	inWindow := 0
+	for _, ts := range l.timeStamps {
+		if !ts.Before(currentTime.Add(-l.windowSize)) {
+			inWindow++
+		}
+	}
+	return l.limit - inWindow
+}
+
+func TestSlidingWindow_MatchesNaiveLog(t *testing.T) {
+	clock := newFakeClock()
+	rl := NewSlidingWindow(50, 100*time.Millisecond, WithClock(clock)).(*SlidingWindow)
+	defer rl.Stop()
+	naive := &naiveSlidingLog{limit: 50, windowSize: 100 * time.Millisecond}
+	r := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 10_000; i++ {
+		clock.Advance(time.Duration(r.Intn(5_000)) * time.Microsecond)
+		tokens := 1 + r.Intn(8)
+		want := naive.allow(clock.Now(), tokens)
+		if got := rl.Allow(tokens); got != want {
+			t.Fatalf("request %d: Allow(%d) = %v, want %v", i, tokens, got, want)
+		}
+		if got, want := rl.Tokens(), naive.available(clock.Now()); got != want {
+			t.Fatalf("request %d: Tokens() = %d, want %d", i, got, want)
+		}
+	}
+}
+
+// BenchmarkSlidingWindow_Prune runs the sliding window algorithm directly, without the limiter's goroutine, with
+// a full window of 10000 timestamps of which one expires on every request
+func BenchmarkSlidingWindow_Prune(b *testing.B) {
+	const limit = 10_000
+	windowSize := time.Second
+	step := windowSize / limit
+
+	b.Run("NaiveScan", func(b *testing.B) {
+		l := &naiveSlidingLog{limit: limit, windowSize: windowSize}
+		now := time.Now()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			now = now.Add(step)
+			l.allow(now, 1)
+			l.available(now)
+		}
+	})
+
+	b.Run("Ring", func(b *testing.B) {
+		rl := &SlidingWindow{
+			limit:           limit,
+			windowSize:      windowSize,
+			timeStamps:      newTimeRing(limit),
+			RateLimiterBase: &RateLimiterBase{},
+		}
+		now := time.Now()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			now = now.Add(step)
+			rl.allow(now, 1)
+			rl.available(now)
+		}
+	})
+}
+
+func TestAllow_ZeroAllocs(t *testing.T) {
+	for _, bm := range allowBenchmarks {
+		t.Run(bm.name, func(t *testing.T) {
+			rl := bm.newRL()
+			defer rl.Stop()
+
+			allocs := testing.AllocsPerRun(1000, func() {
+				rl.Allow(1)
+			})
+			if allocs != 0 {
+				t.Errorf("Allow(1) allocates %v times per call, want 0", allocs)
+			}
+		})
+	}
+}
+
+func TestLeakyBucket_AllowAt(t *testing.T) {
+	clock := newFakeClock()
+	start := clock.Now()
+	rl := NewLeakyBucket(10, 5, WithClock(clock)).(*LeakyBucket)
+	defer rl.Stop()
+
+	tests := []struct {
+		name      string
+		at        time.Duration
+		tokens    int
+		want      bool
+		wantLevel int
+	}{
+		{"Request 1 token at 999ms, expect denied (nothing leaked before a whole second)", 999 * time.Millisecond, 1, false, 10},
+		{"Request 5 tokens at 1s, expect allowed (5 tokens leaked)", time.Second, 5, true, 10},
+		{"Request 1 token at 1.5s, expect denied (bucket full again)", 1500 * time.Millisecond, 1, false, 10},
+		{"Request 5 tokens at 2.4s, expect allowed (one more second leaked)", 2400 * time.Millisecond, 5, true, 10},
+		{"Request 1 token at 2.3s, expect denied (earlier than the last request)", 2300 * time.Millisecond, 1, false, 10},
+		{"Request 3 tokens at 4s, expect allowed (bucket leaked dry)", 4 * time.Second, 3, true, 3},
+		{"Request 0 tokens at 5s, expect denied (invalid request)", 5 * time.Second, 0, false, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := rl.AllowAt(start.Add(tt.at), tt.tokens)
+			if got != tt.want {
+				t.Errorf("AllowAt(%v, %d) = %v, want %v", tt.at, tt.tokens, got, tt.want)
+			}
+			if level, _, _ := limiterLevel(rl); level != tt.wantLevel {
+				t.Errorf("level = %d, want %d", level, tt.wantLevel)
+			}
+		})
+	}
+}
+
+func TestHugeRequests(t *testing.T) {
+	limiters := []struct {
+		name  string
+		newRL func(clock Clock, opts ...Option) RateLimiter
+	}{
+		{"TokenBucket", func(clock Clock, opts ...Option) RateLimiter {
+			return NewTokenBucket(10, 5, 10, append(opts, WithClock(clock), WithReservedForHighPriority(2))...)
+		}},
+		{"LeakyBucket", func(clock Clock, opts ...Option) RateLimiter {
+			return NewLeakyBucket(10, 5, append(opts, WithClock(clock))...)
+		}},
+		{"FixedWindow", func(clock Clock, opts ...Option) RateLimiter {
+			return NewFixedWindow(1, 10, append(opts, WithClock(clock))...)
+		}},
+		{"SlidingWindow", func(clock Clock, opts ...Option) RateLimiter {
+			return NewSlidingWindow(10, time.Second, append(opts, WithClock(clock))...)
+		}},
+	}
+	huge := []int{math.MaxInt, math.MaxInt - 1, math.MaxInt / 2, math.MaxInt32 + 1}
+
+	for _, l := range limiters {
+		t.Run(l.name+", expect huge requests denied and never available", func(t *testing.T) {
+			clock := newFakeClock()
+			rl := l.newRL(clock)
+			defer rl.Stop()
+			clock.Advance(time.Hour)
+
+			for _, tokens := range huge {
+				if rl.Allow(tokens) {
+					t.Errorf("Allow(%d) = true, want false", tokens)
+				}
+				if next := rl.(interface{ NextAvailable(int) time.Time }).NextAvailable(tokens); !next.IsZero() {
+					t.Errorf("NextAvailable(%d) = %v, want zero time", tokens, next)
+				}
+			}
+			// the limiter is left untouched by the huge requests
+			if got := rl.(Introspector).Tokens(); got != 10 {
+				t.Errorf("Tokens() = %d, want 10", got)
+			}
+		})
+
+		t.Run(l.name+", expect huge requests clamped with WithClampToCapacity", func(t *testing.T) {
+			clock := newFakeClock()
+			rl := l.newRL(clock, WithClampToCapacity())
+			defer rl.Stop()
+			clock.Advance(time.Hour)
+
+			if l.name == "TokenBucket" {
+				// a plain request can't dig into the high priority reserve, even clamped
+				if rl.Allow(math.MaxInt) {
+					t.Errorf("Allow(%d) = true, want false", math.MaxInt)
+				}
+				if !rl.(*TokenBucket).AllowPriority(math.MaxInt, PriorityHigh) {
+					t.Errorf("AllowPriority(%d, PriorityHigh) = false, want true", math.MaxInt)
+				}
+			} else if !rl.Allow(math.MaxInt) {
+				t.Errorf("Allow(%d) = false, want true", math.MaxInt)
+			}
+			if got := rl.(Introspector).Tokens(); got != 0 {
+				t.Errorf("Tokens() = %d, want 0", got)
+			}
+		})
+	}
+}
+
+func TestHugeParameters(t *testing.T) {
+	clock := newFakeClock()
+
+	tb := NewTokenBucket(10, math.MaxInt, 0, WithClock(clock)).(*TokenBucket)
+	defer tb.Stop()
+	tb.Refund(math.MaxInt)
+	if got := tb.Tokens(); got != 10 {
+		t.Errorf("TokenBucket Tokens() after Refund(MaxInt) = %d, want 10", got)
+	}
+
+	lb := NewLeakyBucket(math.MaxInt, math.MaxInt, WithClock(clock)).(*LeakyBucket)
+	defer lb.Stop()
+	clock.Advance(1000 * time.Hour)
+	if !lb.Allow(math.MaxInt) {
+		t.Errorf("LeakyBucket Allow(MaxInt) after draining = false, want true")
+	}
+
+	slow := NewLeakyBucket(math.MaxInt, 1, WithClock(clock)).(*LeakyBucket)
+	defer slow.Stop()
+	// draining MaxInt tokens at one per second takes longer than a time.Duration can hold, the reset saturates
+	if reset := slow.NextReset(); !reset.After(clock.Now().Add(100 * 365 * 24 * time.Hour)) {
+		t.Errorf("LeakyBucket NextReset() = %v, want centuries away", reset)
+	}
+
+	fw := NewFixedWindow(math.MaxInt, 10, WithClock(clock)).(*FixedWindow)
+	defer fw.Stop()
+	fw.Allow(10)
+	if next := fw.NextAvailable(1); !next.After(clock.Now()) {
+		t.Errorf("FixedWindow NextAvailable(1) = %v, want after now", next)
+	}
+}
+
+func TestAllowContext(t *testing.T) {
+	rl := NewTokenBucket(10, 1, 5, WithClock(newFakeClock())).(*TokenBucket)
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name          string
+		ctx           context.Context
+		tokens        int
+		wantAllowed   bool
+		wantRemaining int
+		wantErr       error
+	}{
+		{"Request 2 tokens, expect allowed with 3 remaining", context.Background(), 2, true, 3, nil},
+		{"Request 4 tokens, expect denied with 3 still remaining", context.Background(), 4, false, 3, nil},
+		{"Request 1 token with a cancelled context, expect its error", cancelled, 1, false, 0, context.Canceled},
+		{"Request 3 tokens, expect allowed with 0 remaining (nothing taken while cancelled)", context.Background(), 3, true, 0, nil},
+		{"Request 0 tokens, expect ErrNeverAvailable", context.Background(), 0, false, 0, ErrNeverAvailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowed, remaining, err := rl.AllowContext(tt.ctx, tt.tokens)
+			if allowed != tt.wantAllowed || remaining != tt.wantRemaining || !errors.Is(err, tt.wantErr) {
+				t.Errorf("AllowContext(%d) = %v, %d, %v, want %v, %d, %v", tt.tokens, allowed, remaining, err,
+					tt.wantAllowed, tt.wantRemaining, tt.wantErr)
+			}
+		})
+	}
+
+	rl.Stop()
+	if _, _, err := rl.AllowContext(context.Background(), 1); !errors.Is(err, ErrStopped) {
+		t.Errorf("AllowContext(1) after Stop() error = %v, want %v", err, ErrStopped)
+	}
+}
+
+func TestAllowDetailed(t *testing.T) {
+	type step struct {
+		name    string
+		advance time.Duration
+		tokens  int
+		want    Result
+	}
+	tests := []struct {
+		name  string
+		newRL func(clock Clock) RateLimiter
+		steps []step
+	}{
+		{
+			"TokenBucket refilling a token every 200 milliseconds",
+			func(clock Clock) RateLimiter { return NewTokenBucket(10, 5, 0, WithClock(clock)) },
+			[]step{
+				{"Request 1 token from the empty bucket, expect denied without a refill", 0, 1, Result{false, 0, 0}},
+				{"Request 1 token 100ms later, expect denied without a refill (half a token accrued)", 100 * time.Millisecond, 1, Result{false, 0, 0}},
+				{"Request 1 token 100ms later, expect a refill of 1 token which is admitted", 100 * time.Millisecond, 1, Result{true, 1, 0}},
+				{"Request 2 tokens 1 second later, expect a refill of 5 tokens and 3 left", time.Second, 2, Result{true, 5, 3}},
+				{"Request 1 token straight away, expect no refill and 2 left", 0, 1, Result{true, 0, 2}},
+				{"Request 0 tokens 400ms later, expect denied after a refill of 2 tokens", 400 * time.Millisecond, 0, Result{false, 2, 4}},
+			},
+		},
+		{
+			"LeakyBucket leaking 2 tokens every second",
+			func(clock Clock) RateLimiter { return NewLeakyBucket(10, 2, WithClock(clock)) },
+			[]step{
+				{"Request 1 token from the full bucket, expect denied without a leak", 0, 1, Result{false, 0, 0}},
+				{"Request 1 token 500ms later, expect denied without a leak", 500 * time.Millisecond, 1, Result{false, 0, 0}},
+				{"Request 1 token 500ms later, expect a leak of 2 tokens and 1 left", 500 * time.Millisecond, 1, Result{true, 2, 1}},
+				{"Request 1 token 999ms later, expect no leak and 0 left", 999 * time.Millisecond, 1, Result{true, 0, 0}},
+				{"Request 3 tokens 1ms later, expect a leak of 2 tokens and denied", time.Millisecond, 3, Result{false, 2, 2}},
+			},
+		},
+		{
+			"FixedWindow never reports a refill",
+			func(clock Clock) RateLimiter { return NewFixedWindow(1, 5, WithClock(clock)) },
+			[]step{
+				{"Request 5 tokens, expect allowed with none left", 0, 5, Result{true, 0, 0}},
+				{"Request 2 tokens in the next window, expect allowed with 3 left", time.Second, 2, Result{true, 0, 3}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			rl := tt.newRL(clock)
+			defer rl.Stop()
+
+			detailed := rl.(interface{ AllowDetailed(int) Result })
+			for _, st := range tt.steps {
+				clock.Advance(st.advance)
+				if got := detailed.AllowDetailed(st.tokens); got != st.want {
+					t.Fatalf("%s: AllowDetailed(%d) = %+v, want %+v", st.name, st.tokens, got, st.want)
+				}
+			}
+
+			rl.Stop()
+			if got := detailed.AllowDetailed(1); got != (Result{}) {
+				t.Errorf("AllowDetailed(1) after Stop() = %+v, want the zero Result", got)
+			}
+		})
+	}
+}
+
+func TestMinInterval_Allow(t *testing.T) {
+	clock := newFakeClock()
+	rl := NewMinInterval(100*time.Millisecond, WithClock(clock))
+	defer rl.Stop()
+
+	tests := []struct {
+		name    string
+		advance time.Duration
+		tokens  int
+		want    bool
+	}{
+		{"First request, expect allowed", 0, 1, true},
+		{"Immediate second request, expect denied", 0, 1, false},
+		{"Request 99ms later, expect denied (gap not elapsed)", 99 * time.Millisecond, 1, false},
+		{"Request 1ms later, expect allowed (exactly 100ms since the last one)", time.Millisecond, 1, true},
+		{"Request 5 tokens 150ms later, expect allowed as a single request", 150 * time.Millisecond, 5, true},
+		{"Request 5 tokens straight away, expect denied", 0, 5, false},
+		{"Request 0 tokens 1 second later, expect denied (invalid request)", time.Second, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock.Advance(tt.advance)
+			got := rl.Allow(tt.tokens)
+			if got != tt.want {
+				t.Errorf("Allow(%d) = %v, want %v", tt.tokens, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMinInterval_Wait(t *testing.T) {
+	interval := 50 * time.Millisecond
+	rl := NewMinInterval(interval).(*MinInterval)
+	defer rl.Stop()
+
+	if !rl.Allow(1) {
+		t.Fatalf("Allow(1) = false, want true")
+	}
+	start := time.Now()
+	if err := rl.Wait(context.Background(), 1); err != nil {
+		t.Fatalf("Wait(1) = %v, want nil", err)
+	}
+	if elapsed := time.Since(start); elapsed < interval-5*time.Millisecond {
+		t.Errorf("Wait(1) returned after %v, want it to sleep out the remaining %v gap", elapsed, interval)
+	}
+	if rl.Allow(1) {
+		t.Errorf("Allow(1) right after Wait = true, want false")
+	}
+}
+
+func TestTokenBucket_RefillExceedsCapacity(t *testing.T) {
+	clock := newFakeClock()
+	// refills 20 times the capacity every second, a token every 10 milliseconds
+	rl := NewTokenBucket(5, 100, 0, WithClock(clock)).(*TokenBucket)
+	defer rl.Stop()
+
+	tests := []struct {
+		name       string
+		advance    time.Duration
+		tokens     int
+		want       bool
+		wantTokens int
+	}{
+		{"Request 1 token 10ms in, expect allowed (1 token refilled)", 10 * time.Millisecond, 1, true, 0},
+		{"Request 6 tokens 1 second later, expect denied (never more than the capacity of 5)", time.Second, 6, false, 5},
+		{"Request 5 tokens, expect allowed (bucket full)", 0, 5, true, 0},
+		{"Request 1 token, expect denied (bucket empty)", 0, 1, false, 0},
+		{"Request 5 tokens 30ms later, expect denied (3 tokens refilled)", 30 * time.Millisecond, 5, false, 3},
+		{"Request 1 token an hour later, expect allowed (refill stops at capacity)", time.Hour, 1, true, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock.Advance(tt.advance)
+			if got := rl.Allow(tt.tokens); got != tt.want {
+				t.Errorf("Allow(%d) = %v, want %v", tt.tokens, got, tt.want)
+			}
+			if got := rl.Tokens(); got != tt.wantTokens {
+				t.Errorf("Tokens() = %d, want %d", got, tt.wantTokens)
+			}
+		})
+	}
+}
+
+func TestTokenBucket_Inspect(t *testing.T) {
+	// 4 of 10 tokens, refilling one every 200ms
+	rl := NewTokenBucket(10, 5, 4, WithClock(newFakeClock())).(*TokenBucket)
+
+	tests := []struct {
+		name           string
+		tokens         int
+		wantAvailable  int
+		wantShortfall  int
+		wantRetryAfter time.Duration
+	}{
+		{"Request 2 tokens, below availability, expect no shortfall", 2, 4, 0, 0},
+		{"Request 4 tokens, at availability, expect no shortfall", 4, 4, 0, 0},
+		{"Request 7 tokens, above availability, expect 3 short for 600ms", 7, 4, 3, 600 * time.Millisecond},
+		{"Request 11 tokens, above capacity, expect never admitted", 11, 4, 7, InfDuration},
+		{"Request 0 tokens, expect never admitted", 0, 4, 0, InfDuration},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			available, shortfall, retryAfter := rl.Inspect(tt.tokens)
+			if available != tt.wantAvailable || shortfall != tt.wantShortfall || retryAfter != tt.wantRetryAfter {
+				t.Errorf("Inspect(%d) = %d, %d, %v, want %d, %d, %v", tt.tokens, available, shortfall, retryAfter,
+					tt.wantAvailable, tt.wantShortfall, tt.wantRetryAfter)
+			}
+		})
+	}
+
+	if got := rl.Tokens(); got != 4 {
+		t.Errorf("Tokens() after Inspect = %d, want 4", got)
+	}
+	rl.Stop()
+	if available, shortfall, retryAfter := rl.Inspect(3); available != 0 || shortfall != 3 || retryAfter != InfDuration {
+		t.Errorf("Inspect(3) after Stop() = %d, %d, %v, want 0, 3, %v", available, shortfall, retryAfter, InfDuration)
+	}
+}
+
+func TestDrainAll(t *testing.T) {
+	clock := newFakeClock()
+	tb := NewTokenBucket(10, 5, 7, WithClock(clock), WithReservedForHighPriority(2)).(*TokenBucket)
+	defer tb.Stop()
+	lb := NewLeakyBucket(10, 4, WithClock(clock)).(*LeakyBucket)
+	defer lb.Stop()
+
+	tests := []struct {
+		name    string
+		advance time.Duration
+		drain   func() int
+		want    int
+	}{
+		{"Drain the token bucket, expect 5 tokens (2 of 7 kept in reserve)", 0, tb.DrainAll, 5},
+		{"Drain the token bucket again, expect 0 tokens", 0, tb.DrainAll, 0},
+		{"Drain the full leaky bucket, expect 0 tokens", 0, lb.DrainAll, 0},
+		{"Drain the token bucket 1 second later, expect the 5 refilled tokens", time.Second, tb.DrainAll, 5},
+		{"Drain the leaky bucket, expect the 4 leaked tokens", 0, lb.DrainAll, 4},
+		{"Drain the leaky bucket again, expect 0 tokens", 0, lb.DrainAll, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock.Advance(tt.advance)
+			if got := tt.drain(); got != tt.want {
+				t.Errorf("DrainAll() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDrainAll_Concurrency(t *testing.T) {
+	clock := newFakeClock()
+	tb := NewTokenBucket(1000, 5, 1000, WithClock(clock)).(*TokenBucket)
+	defer tb.Stop()
+	lb := NewLeakyBucket(1000, 600, WithClock(clock)).(*LeakyBucket)
+	defer lb.Stop()
+	// leak 600 tokens out of the leaky bucket, the clock is frozen from here on
+	clock.Advance(time.Second)
+
+	tests := []struct {
+		name  string
+		rl    RateLimiter
+		drain func() int
+		want  int
+	}{
+		{"TokenBucket", tb, tb.DrainAll, 1000},
+		{"LeakyBucket", lb, lb.DrainAll, 600},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			total := 0
+			wg := &sync.WaitGroup{}
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					// interleave single token requests with the drains
+					taken := tt.drain()
+					if tt.rl.Allow(1) {
+						taken++
+					}
+					mu.Lock()
+					total += taken
+					mu.Unlock()
+				}()
+			}
+			wg.Wait()
+
+			if total != tt.want {
+				t.Errorf("drained %d tokens in total, want %d", total, tt.want)
+			}
+		})
+	}
+}
+
+func TestLeakyBucket_SetCapacity(t *testing.T) {
+	clock := newFakeClock()
+	rl := NewLeakyBucket(10, 2, WithClock(clock)).(*LeakyBucket)
+	defer rl.Stop()
+
+	// leak 4 tokens, leaving a level of 6
+	clock.Advance(2 * time.Second)
+	rl.SetCapacity(4)
+	if level, capacity, _ := limiterLevel(rl); level != 4 || capacity != 4 {
+		t.Fatalf("level %d of %d after SetCapacity(4), want 4 of 4", level, capacity)
+	}
+	if rl.Allow(1) {
+		t.Errorf("Allow(1) on the clamped full bucket = true, want false")
+	}
+
+	rl.SetCapacity(8)
+	if !rl.Allow(4) {
+		t.Errorf("Allow(4) after SetCapacity(8) = false, want true")
+	}
+	if rl.Allow(1) {
+		t.Errorf("Allow(1) on the full bucket = true, want false")
+	}
+}
+
+func TestLeakyBucket_SetLeakRate(t *testing.T) {
+	clock := newFakeClock()
+	rl := NewLeakyBucket(20, 2, WithClock(clock)).(*LeakyBucket)
+	defer rl.Stop()
+
+	// half a second towards the next leak carries over the rate change
+	clock.Advance(1500 * time.Millisecond)
+	rl.SetLeakRate(8)
+	if level, _, _ := limiterLevel(rl); level != 18 {
+		t.Fatalf("level %d after leaking at the old rate, want 18", level)
+	}
+
+	clock.Advance(500 * time.Millisecond)
+	if got := rl.Tokens(); got != 10 {
+		t.Errorf("Tokens() after the first leak at the new rate = %d, want 10", got)
+	}
+	clock.Advance(time.Second)
+	if got := rl.Tokens(); got != 18 {
+		t.Errorf("Tokens() after the second leak at the new rate = %d, want 18", got)
+	}
+
+	rl.SetLeakRate(0)
+	clock.Advance(time.Hour)
+	if got := rl.Tokens(); got != 18 {
+		t.Errorf("Tokens() after SetLeakRate(0) = %d, want 18 (no more leaks)", got)
+	}
+}
+
+func TestDone(t *testing.T) {
+	limiters := map[string]RateLimiter{
+		"TokenBucket":   NewTokenBucket(10, 5, 10),
+		"LeakyBucket":   NewLeakyBucket(10, 5),
+		"FixedWindow":   NewFixedWindow(1, 10),
+		"SlidingWindow": NewSlidingWindow(10, time.Second),
+		"MinInterval":   NewMinInterval(time.Second),
+	}
+
+	for name, rl := range limiters {
+		t.Run(name, func(t *testing.T) {
+			done := rl.(interface{ Done() <-chan struct{} }).Done()
+			select {
+			case <-done:
+				t.Fatalf("Done() closed before Stop()")
+			default:
+			}
+
+			observed := make(chan struct{})
+			go func() {
+				<-done
+				close(observed)
+			}()
+
+			rl.Stop()
+			select {
+			case <-observed:
+			case <-time.After(time.Second):
+				t.Fatalf("Done() not closed within a second of Stop()")
+			}
+		})
+	}
+}
+
+func TestTokenBucket_SetTokens(t *testing.T) {
+	clock := newFakeClock()
+	rl := NewTokenBucket(10, 2, 10, WithClock(clock)).(*TokenBucket)
+	defer rl.Stop()
+
+	tests := []struct {
+		name       string
+		op         func()
+		wantTokens int
+	}{
+		{"Set 3 tokens, expect 3", func() { rl.SetTokens(3) }, 3},
+		{"Request 4 tokens, expect denied with 3 left", func() { rl.Allow(4) }, 3},
+		{"Request 3 tokens, expect all taken", func() { rl.Allow(3) }, 0},
+		{"Set 1 token and wait 1 second, expect the refill on top", func() { rl.SetTokens(1); clock.Advance(time.Second) }, 3},
+		{"Set -5 tokens, expect clamped to 0", func() { rl.SetTokens(-5) }, 0},
+		{"Set 50 tokens, expect clamped to the capacity", func() { rl.SetTokens(50) }, 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.op()
+			if got := rl.Tokens(); got != tt.wantTokens {
+				t.Errorf("Tokens() = %d, want %d", got, tt.wantTokens)
+			}
+		})
+	}
+}
+
+func TestTokenBucket_SetRateAndCapacity(t *testing.T) {
+	clock := newFakeClock()
+	rl := NewTokenBucket(10, 2, 0, WithClock(clock)).(*TokenBucket)
+	defer rl.Stop()
+
+	// 3 tokens accrue at the old rate before it's raised to 10 per second
+	clock.Advance(1500 * time.Millisecond)
+	rl.SetRate(10)
+	clock.Advance(500 * time.Millisecond)
+	if got := rl.Tokens(); got != 8 {
+		t.Errorf("Tokens() after SetRate(10) = %d, want 8", got)
+	}
+
+	rl.SetCapacity(4)
+	if got := rl.Tokens(); got != 4 {
+		t.Errorf("Tokens() after SetCapacity(4) = %d, want 4", got)
+	}
+	if rl.Allow(5) {
+		t.Errorf("Allow(5) beyond the new capacity = true, want false")
+	}
+	if !rl.Allow(4) {
+		t.Errorf("Allow(4) = false, want true")
+	}
+}
+
+func TestSustainedRate(t *testing.T) {
+	tests := []struct {
+		name string
+		rl   RateLimiter
+		want float64
+	}{
+		{"TokenBucket refilling 5 per second, expect 5 per second", NewTokenBucket(10, 5, 10), 5},
+		{"TokenBucket refilling 30 per minute, expect 0.5 per second", NewTokenBucketPerDuration(30, time.Minute, 10), 0.5},
+		{"LeakyBucket leaking 3 per second, expect 3 per second", NewLeakyBucket(10, 3), 3},
+		{"FixedWindow of 20 per 4 seconds, expect 5 per second", NewFixedWindow(4, 20), 5},
+		{"FixedWindow of 10 per 500ms, expect 20 per second", NewFixedWindowDuration(500*time.Millisecond, 10), 20},
+		{"SlidingWindow of 60 per minute, expect 1 per second", NewSlidingWindow(60, time.Minute), 1},
+		{"MinInterval of 250ms, expect 4 per second", NewMinInterval(250 * time.Millisecond), 4},
+		{"MinInterval of 0, expect an infinite rate", NewMinInterval(0), math.Inf(1)},
+		{"DecayingWindow of 10 halving every second, expect 10*ln2 per second", NewDecayingWindow(10, time.Second), 10 * math.Ln2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer tt.rl.Stop()
+			got := tt.rl.(interface{ SustainedRate() float64 }).SustainedRate()
+			if math.Abs(got-tt.want) > 1e-9 && got != tt.want {
+				t.Errorf("SustainedRate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidCost(t *testing.T) {
+	double := WithCostScale(func() float64 { return 2 })
+	tests := []struct {
+		name  string
+		newRL func(clock Clock) RateLimiter
+	}{
+		{"TokenBucket of 10", func(clock Clock) RateLimiter { return NewTokenBucket(10, 5, 10, WithClock(clock)) }},
+		{"TokenBucket of 10 with 3 reserved", func(clock Clock) RateLimiter {
+			return NewTokenBucket(10, 5, 10, WithClock(clock), WithReservedForHighPriority(3))
+		}},
+		{"TokenBucket of 10 with a grace pool of 4", func(clock Clock) RateLimiter {
+			return NewTokenBucket(10, 5, 10, WithClock(clock), WithGraceBurst(4, time.Second))
+		}},
+		{"TokenBucket of 10 clamped", func(clock Clock) RateLimiter {
+			return NewTokenBucket(10, 5, 10, WithClock(clock), WithClampToCapacity())
+		}},
+		{"TokenBucket of 10 at double cost", func(clock Clock) RateLimiter { return NewTokenBucket(10, 5, 10, WithClock(clock), double) }},
+		{"LeakyBucket of 8", func(clock Clock) RateLimiter { return NewLeakyBucket(8, 2, WithClock(clock)) }},
+		{"LeakyBucket of 8 never exactly full", func(clock Clock) RateLimiter {
+			return NewLeakyBucket(8, 2, WithClock(clock), WithAdmitWhenExactlyFull(false))
+		}},
+		{"FixedWindow of 6", func(clock Clock) RateLimiter { return NewFixedWindow(1, 6, WithClock(clock)) }},
+		{"SlidingWindow of 5", func(clock Clock) RateLimiter { return NewSlidingWindow(5, time.Second, WithClock(clock)) }},
+		{"MinInterval", func(clock Clock) RateLimiter { return NewMinInterval(time.Second, WithClock(clock)) }},
+		{"DecayingWindow of 4", func(clock Clock) RateLimiter { return NewDecayingWindow(4, time.Second, WithClock(clock)) }},
+		{"EventBucket of 3", func(clock Clock) RateLimiter { return NewEventBucket(3, WithClock(clock)) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name+", expect ValidCost to match whether Allow can ever admit the cost", func(t *testing.T) {
+			// the limiter's fill doesn't matter, only whether the cost fits once it's idle
+			drained := tt.newRL(newFakeClock())
+			defer drained.Stop()
+			DrainUntilDenied(drained, 1)
+			for tokens := -1; tokens <= 20; tokens++ {
+				clock := newFakeClock()
+				rl := tt.newRL(clock)
+				// leave time for a leaky bucket to empty and windows to start over
+				clock.Advance(time.Hour)
+				want := rl.Allow(tokens)
+				rl.Stop()
+				if got := ValidCost(drained, tokens); got != want {
+					t.Errorf("ValidCost(%d) = %v, want %v as Allow(%d) on an idle limiter", tokens, got, want, tokens)
+				}
+			}
+		})
+	}
+
+	clock := newFakeClock()
+	baseline := NewBaselineLimiter(2, time.Second, WithClock(clock))
+	defer baseline.Stop()
+	if !ValidCost(baseline, 1000) || ValidCost(baseline, 0) {
+		t.Error("ValidCost on a BaselineLimiter still learning its baseline, want any positive cost valid")
+	}
+	stopped := NewTokenBucket(10, 5, 10, WithClock(clock))
+	stopped.Stop()
+	if ValidCost(stopped, 1) {
+		t.Error("ValidCost(1) on a stopped limiter = true, want false")
+	}
+	if !ValidCost(stubLimiter{allow: true}, 100) || ValidCost(stubLimiter{allow: true}, 0) {
+		t.Error("ValidCost on a limiter that isn't an Introspector, want any positive cost valid")
+	}
+}
+
+func TestAllowTransaction(t *testing.T) {
+	newTB := func(clock Clock) RateLimiter { return NewTokenBucket(10, 1, 10, WithClock(clock)) }
+	newLB := func(clock Clock) RateLimiter { return NewLeakyBucket(10, 1, WithClock(clock)) }
+	tests := []struct {
+		name       string
+		newRL      func(clock Clock) RateLimiter
+		finish     func(commit, rollback func())
+		wantTokens int
+	}{
+		{"TokenBucket, commit, expect the tokens to stay consumed", newTB, func(commit, rollback func()) { commit() }, 7},
+		{"TokenBucket, rollback, expect the tokens restored", newTB, func(commit, rollback func()) { rollback() }, 10},
+		{"TokenBucket, rollback after commit, expect the commit to stand", newTB, func(commit, rollback func()) { commit(); rollback() }, 7},
+		{"LeakyBucket, commit, expect the tokens to stay in the bucket", newLB, func(commit, rollback func()) { commit() }, 7},
+		{"LeakyBucket, rollback, expect the tokens taken back out", newLB, func(commit, rollback func()) { rollback() }, 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			rl := tt.newRL(clock)
+			defer rl.Stop()
+			// a leaky bucket starts full
+			clock.Advance(10 * time.Second)
+
+			tx := rl.(interface {
+				AllowTransaction(tokens int) (func(), func(), bool)
+				Tokens() int
+			})
+			commit, rollback, ok := tx.AllowTransaction(3)
+			if !ok {
+				t.Fatalf("AllowTransaction(3) ok = false, want true")
+			}
+			if got := tx.Tokens(); got != 7 {
+				t.Errorf("Tokens() while held = %d, want 7", got)
+			}
+			tt.finish(commit, rollback)
+			if got := tx.Tokens(); got != tt.wantTokens {
+				t.Errorf("Tokens() after finishing = %d, want %d", got, tt.wantTokens)
+			}
+		})
+	}
+}
+
+func TestAllowTransaction_RollbackOnce(t *testing.T) {
+	tb := NewTokenBucket(10, 1, 5, WithClock(newFakeClock())).(*TokenBucket)
+	defer tb.Stop()
+
+	_, rollback, ok := tb.AllowTransaction(3)
+	if !ok {
+		t.Fatalf("AllowTransaction(3) ok = false, want true")
+	}
+	rollback()
+	rollback()
+	if got := tb.Tokens(); got != 5 {
+		t.Errorf("Tokens() after rolling back twice = %d, want 5", got)
+	}
+
+	commit, rollback, ok := tb.AllowTransaction(6)
+	if ok {
+		t.Errorf("AllowTransaction(6) ok = true, want false")
+	}
+	// the no-op commit and rollback of a denied request leave the bucket alone
+	commit()
+	rollback()
+	if got := tb.Tokens(); got != 5 {
+		t.Errorf("Tokens() after a denied transaction = %d, want 5", got)
+	}
+}
+
+func TestBaselineLimiter(t *testing.T) {
+	clock := newFakeClock()
+	// slots of a second, each allowed twice the average of the 10 before it
+	rl := NewBaselineLimiter(2, 10*time.Second, WithClock(clock)).(*BaselineLimiter)
+	defer rl.Stop()
+
+	tests := []struct {
+		name         string
+		slots        int
+		requests     int
+		wantAdmitted int
+	}{
+		{"Request 5 per second while learning the baseline, expect all admitted", 10, 5, 5},
+		{"Request 5 per second at the baseline, expect all admitted", 5, 5, 5},
+		{"Request 30 in a second, a spike, expect throttled to twice the baseline of 5", 1, 30, 10},
+		{"Request 30 in the next second, expect throttled to twice the raised baseline of 5.5", 1, 30, 11},
+		{"Request 5 per second again, expect all admitted", 5, 5, 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for slot := 0; slot < tt.slots; slot++ {
+				admitted := 0
+				for i := 0; i < tt.requests; i++ {
+					if rl.Allow(1) {
+						admitted++
+					}
+				}
+				if admitted != tt.wantAdmitted {
+					t.Errorf("slot %d: admitted %d of %d, want %d", slot, admitted, tt.requests, tt.wantAdmitted)
+				}
+				clock.Advance(time.Second)
+			}
+		})
+	}
+}
+
+func TestBaselineLimiter_NextAvailable(t *testing.T) {
+	clock := newFakeClock()
+	rl := NewBaselineLimiter(2, 10*time.Second, WithClock(clock)).(*BaselineLimiter)
+	defer rl.Stop()
+
+	// learn a baseline of 1 per second, then use up the current slot's 2
+	for slot := 0; slot < 10; slot++ {
+		rl.Allow(1)
+		clock.Advance(time.Second)
+	}
+	rl.Allow(2)
+
+	tests := []struct {
+		name   string
+		tokens int
+		want   time.Time
+	}{
+		{"Request 1 token with the slot used up, expect the next slot", 1, clock.Now().Add(time.Second)},
+		{"Request 2 tokens, expect the next slot", 2, clock.Now().Add(time.Second)},
+		{"Request 3 tokens, expect never as the baseline only drops without admissions", 3, time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rl.NextAvailable(tt.tokens); !got.Equal(tt.want) {
+				t.Errorf("NextAvailable(%d) = %v, want %v", tt.tokens, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBudget(t *testing.T) {
+	tests := []struct {
+		name    string
+		newRL   func(clock Clock) RateLimiter
+		horizon time.Duration
+		// slack is how many more tokens than the budget spaced out requests may get, only the decaying window's
+		// budget is a lower bound
+		slack int
+	}{
+		{"TokenBucket of 5 refilling 2 per second over 2.5s, expect 4 left and 5 refilled", func(c Clock) RateLimiter { return NewTokenBucket(5, 2, 5, WithClock(c)) }, 2500 * time.Millisecond, 0},
+		{"TokenBucket with a reserve over 2s, expect the reserve left out", func(c Clock) RateLimiter {
+			return NewTokenBucket(5, 2, 5, WithClock(c), WithReservedForHighPriority(2))
+		}, 2 * time.Second, 0},
+		{"LeakyBucket of 5 leaking 2 per second over 3.5s, expect 4 of room and 3 leaks of 2", func(c Clock) RateLimiter { return NewLeakyBucket(5, 2, WithClock(c)) }, 3500 * time.Millisecond, 0},
+		{"FixedWindow of 4 per second over 2.5s, expect 3 left and 2 more windows", func(c Clock) RateLimiter { return NewFixedWindow(1, 4, WithClock(c)) }, 2500 * time.Millisecond, 0},
+		{"SlidingWindow of 3 per second over 2.5s, expect 2 free tokens used 3 times and 1 used twice", func(c Clock) RateLimiter { return NewSlidingWindow(3, time.Second, WithClock(c)) }, 2500 * time.Millisecond, 0},
+		{"MinInterval of 300ms over 1s, expect 3 after the interval ends", func(c Clock) RateLimiter { return NewMinInterval(300*time.Millisecond, WithClock(c)) }, time.Second, 0},
+		{"DecayingWindow of 10 halving every second over 3s, expect at least 9 free and 18 decayed", func(c Clock) RateLimiter { return NewDecayingWindow(10, time.Second, WithClock(c)) }, 3 * time.Second, 2},
+		{"BaselineLimiter with a baseline of 0 over 2.5s, expect a token per slot", func(c Clock) RateLimiter { return NewBaselineLimiter(2, 10*time.Second, WithClock(c)) }, 2500 * time.Millisecond, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			rl := tt.newRL(clock)
+			defer rl.Stop()
+			// drain the leaky bucket, which starts full, and start the others off partway through
+			clock.Advance(10 * time.Second)
+			rl.Allow(1)
+			clock.Advance(100 * time.Millisecond)
+
+			budget := rl.(interface{ Budget(time.Duration) int }).Budget(tt.horizon)
+
+			// spend every token as soon as it frees up, a millisecond at a time
+			admitted := 0
+			for elapsed := time.Duration(0); elapsed <= tt.horizon; elapsed += time.Millisecond {
+				for rl.Allow(1) {
+					admitted++
+				}
+				clock.Advance(time.Millisecond)
+			}
+			if admitted < budget || admitted > budget+tt.slack {
+				t.Errorf("Budget(%v) = %d, but spaced out requests got %d", tt.horizon, budget, admitted)
+			}
+		})
+	}
+}
+
+func TestEventBucket(t *testing.T) {
+	clock := newFakeClock()
+	rl := NewEventBucket(5, WithClock(clock)).(*EventBucket)
+	defer rl.Stop()
+
+	tests := []struct {
+		name       string
+		advance    time.Duration
+		credit     int
+		tokens     int
+		want       bool
+		wantTokens int
+	}{
+		{"Request 5 tokens, expect the initial credit spent", 0, 0, 5, true, 0},
+		{"Request 1 token an hour later, expect denied without a credit", time.Hour, 0, 1, false, 0},
+		{"Request 2 tokens after a credit of 3, expect allowed", 0, 3, 2, true, 1},
+		{"Request 2 tokens, expect denied beyond the credit", 0, 0, 2, false, 1},
+		{"Request 5 tokens after a credit of 10, expect the credit clamped to the capacity", 0, 10, 5, true, 0},
+		{"Request 1 token, expect denied until more is credited", 0, 0, 1, false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock.Advance(tt.advance)
+			rl.Credit(tt.credit)
+			if got := rl.Allow(tt.tokens); got != tt.want {
+				t.Errorf("Allow(%d) = %v, want %v", tt.tokens, got, tt.want)
+			}
+			if got := rl.Tokens(); got != tt.wantTokens {
+				t.Errorf("Tokens() = %d, want %d", got, tt.wantTokens)
+			}
+		})
+	}
+
+	if err := rl.Wait(context.Background(), 1); err != ErrNeverAvailable {
+		t.Errorf("Wait(1) on an empty bucket = %v, want %v", err, ErrNeverAvailable)
+	}
+}
+
+func TestTokenBucket_Acquire(t *testing.T) {
+	rl := NewTokenBucket(10, 1, 10, WithClock(newFakeClock())).(*TokenBucket)
+	defer rl.Stop()
+
+	granted, release := rl.Acquire(6)
+	if granted != 6 {
+		t.Fatalf("Acquire(6) granted %d, want 6", granted)
+	}
+	if got := rl.Tokens(); got != 4 {
+		t.Errorf("Tokens() after Acquire(6) = %d, want 4", got)
+	}
+
+	// the worker used 4 of its 6 permits
+	release(2)
+	if got := rl.Tokens(); got != 6 {
+		t.Errorf("Tokens() after releasing 2 = %d, want 6", got)
+	}
+	// releasing more than is left of the batch only gives back the other 4, and nothing after that
+	release(10)
+	release(1)
+	if got := rl.Tokens(); got != 10 {
+		t.Errorf("Tokens() after releasing the whole batch = %d, want 10", got)
+	}
+
+	tests := []struct {
+		name        string
+		maxTokens   int
+		wantGranted int
+	}{
+		{"Acquire more than available, expect the whole bucket", 20, 10},
+		{"Acquire from an empty bucket, expect nothing", 5, 0},
+		{"Acquire 0, expect nothing", 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if granted, _ := rl.Acquire(tt.maxTokens); granted != tt.wantGranted {
+				t.Errorf("Acquire(%d) granted %d, want %d", tt.maxTokens, granted, tt.wantGranted)
+			}
+		})
+	}
+}
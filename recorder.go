@@ -0,0 +1,90 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Decision is one Allow call seen by a Recorder, when it arrived, how many tokens it asked for and whether it was
+// admitted
+type Decision struct {
+	Time    time.Time
+	Tokens  int
+	Allowed bool
+}
+
+// Recorder wraps a RateLimiter and keeps a trace of every decision it makes, so the traffic of an incident can be
+// captured and replayed offline against a candidate configuration with Replay
+type Recorder struct {
+	RateLimiter
+	mu    sync.Mutex
+	clock Clock
+	trace []Decision
+}
+
+// NewRecorder wraps base in a Recorder. Of the options only WithClock applies, it stamps the decisions and should
+// be the clock base reads
+func NewRecorder(base RateLimiter, opts ...Option) *Recorder {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.clock == nil {
+		o.clock = realClock{}
+	}
+	return &Recorder{RateLimiter: base, clock: o.clock}
+}
+
+// Allow asks the wrapped limiter and records the decision, stamped with the time the request arrived
+func (r *Recorder) Allow(tokens int) bool {
+	now := r.clock.Now()
+	allowed := r.RateLimiter.Allow(tokens)
+	r.mu.Lock()
+	r.trace = append(r.trace, Decision{Time: now, Tokens: tokens, Allowed: allowed})
+	r.mu.Unlock()
+	return allowed
+}
+
+// Trace returns a copy of the decisions recorded so far, oldest first
+func (r *Recorder) Trace() []Decision {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Decision(nil), r.trace...)
+}
+
+// Replay creates a candidate configuration with newCandidate and runs the requests of trace against it in order,
+// returning its decisions. The candidate is handed a clock that reads the recorded time of the request being
+// replayed, starting from the first, so the replay comes out the same every run however fast it goes. The
+// candidate is stopped once the trace has been replayed
+func Replay(trace []Decision, newCandidate func(clock Clock) RateLimiter) []bool {
+	results := make([]bool, len(trace))
+	if len(trace) == 0 {
+		return results
+	}
+	clock := &replayClock{now: trace[0].Time}
+	candidate := newCandidate(clock)
+	defer candidate.Stop()
+	for i, d := range trace {
+		clock.set(d.Time)
+		results[i] = candidate.Allow(d.Tokens)
+	}
+	return results
+}
+
+// replayClock is the Clock of a Replay candidate, set to the time of each request before it's replayed
+type replayClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *replayClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *replayClock) set(t time.Time) {
+	c.mu.Lock()
+	c.now = t
+	c.mu.Unlock()
+}
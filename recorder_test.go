@@ -0,0 +1,70 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRecorder(t *testing.T) {
+	clock := newFakeClock()
+	start := clock.Now()
+	rl := NewRecorder(NewTokenBucket(3, 1, 3, WithClock(clock)), WithClock(clock))
+	defer rl.Stop()
+
+	var got []bool
+	for i := 0; i < 4; i++ {
+		got = append(got, rl.Allow(1))
+	}
+	clock.Advance(time.Second)
+	got = append(got, rl.Allow(2), rl.Allow(1))
+
+	recorded := []bool{true, true, true, false, false, true}
+	if !slices.Equal(got, recorded) {
+		t.Fatalf("Allow() = %v, want %v", got, recorded)
+	}
+	trace := rl.Trace()
+	if len(trace) != len(recorded) {
+		t.Fatalf("Trace() has %d decisions, want %d", len(trace), len(recorded))
+	}
+	for i, d := range trace {
+		wantTime := start
+		if i >= 4 {
+			wantTime = wantTime.Add(time.Second)
+		}
+		if d.Allowed != recorded[i] || !d.Time.Equal(wantTime) {
+			t.Errorf("Trace()[%d] = %+v, want Allowed %v at %v", i, d, recorded[i], wantTime)
+		}
+	}
+
+	// the real clock keeps moving while the candidates are replayed, they only ever see the recorded times
+	tests := []struct {
+		name         string
+		newCandidate func(clock Clock) RateLimiter
+		want         []bool
+	}{
+		{
+			"Replay against a token bucket of 5, expect the 4th and the 2 token requests admitted but not the last",
+			func(clock Clock) RateLimiter { return NewTokenBucket(5, 1, 5, WithClock(clock)) },
+			[]bool{true, true, true, true, true, false},
+		},
+		{
+			"Replay against a fixed window of 5 a second, expect everything admitted",
+			func(clock Clock) RateLimiter { return NewFixedWindowDuration(time.Second, 5, WithClock(clock)) },
+			[]bool{true, true, true, true, true, true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for run := 0; run < 3; run++ {
+				if got := Replay(trace, tt.newCandidate); !slices.Equal(got, tt.want) {
+					t.Errorf("run %d: Replay() = %v, want %v", run, got, tt.want)
+				}
+			}
+		})
+	}
+
+	if got := Replay(nil, func(Clock) RateLimiter { panic("candidate created for an empty trace") }); len(got) != 0 {
+		t.Errorf("Replay(nil) = %v, want no decisions", got)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Registry gathers the Stats of the limiters that joined it through WithRegistry into a single report, for a
+// fleet-wide view of many limiters. Limiters leave it when they're stopped
+type Registry struct {
+	mu      sync.Mutex
+	members []*RateLimiterBase
+}
+
+// NewRegistry creates an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// add makes rlb a member of the registry
+func (r *Registry) add(rlb *RateLimiterBase) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.members = append(r.members, rlb)
+}
+
+// remove takes rlb out of the registry
+func (r *Registry) remove(rlb *RateLimiterBase) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, m := range r.members {
+		if m == rlb {
+			r.members = append(r.members[:i], r.members[i+1:]...)
+			return
+		}
+	}
+}
+
+// snapshot returns a copy of the members, so they can be called without holding the lock
+func (r *Registry) snapshot() []*RateLimiterBase {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*RateLimiterBase(nil), r.members...)
+}
+
+// Report returns the Stats of every member in the order they joined. The members are read one after the other,
+// so the report isn't a single point in time while they're busy
+func (r *Registry) Report() []Stats {
+	members := r.snapshot()
+	report := make([]Stats, len(members))
+	for i, m := range members {
+		report[i] = m.Stats()
+	}
+	return report
+}
+
+// Totals returns how many requests the members have admitted and denied altogether, which is the sum of the
+// Allowed and Denied counts of Report
+func (r *Registry) Totals() (allowed, denied uint64) {
+	for _, s := range r.Report() {
+		allowed += s.Allowed
+		denied += s.Denied
+	}
+	return allowed, denied
+}
+
+// SnapshotAll returns the Snapshot of every member keyed by its WithName name, to persist the state of a whole
+// fleet across a restart. Like Report the members are read one after the other. It fails if a member has no name
+// or shares it with another, as its snapshot couldn't be told apart
+func (r *Registry) SnapshotAll() (map[string][]byte, error) {
+	snapshots := make(map[string][]byte)
+	for _, m := range r.snapshot() {
+		if m.name == "" {
+			return nil, fmt.Errorf("%w: registry member without a name", ErrInvalidParameter)
+		}
+		if _, ok := snapshots[m.name]; ok {
+			return nil, fmt.Errorf("%w: registry members share the name %q", ErrInvalidParameter, m.name)
+		}
+		data, err := m.Snapshot()
+		if err != nil {
+			return nil, fmt.Errorf("snapshot %q: %w", m.name, err)
+		}
+		snapshots[m.name] = data
+	}
+	return snapshots, nil
+}
+
+// RestoreAll restores every member from the snapshot under its name, as taken by SnapshotAll. Members without a
+// snapshot are left as they are and snapshots without a member are ignored. Every snapshot is checked before
+// any member is restored, so a snapshot that doesn't fit its member leaves the whole fleet untouched
+func (r *Registry) RestoreAll(snapshots map[string][]byte) error {
+	members := r.snapshot()
+	states := make([]limiterState, len(members))
+	for i, m := range members {
+		data, ok := snapshots[m.name]
+		if !ok || m.name == "" {
+			continue
+		}
+		s, err := m.decodeSnapshot(data)
+		if err != nil {
+			return fmt.Errorf("restore %q: %w", m.name, err)
+		}
+		states[i] = s
+	}
+	for i, m := range members {
+		if states[i].Algorithm == "" {
+			continue
+		}
+		if err := m.restore(states[i]); err != nil {
+			return fmt.Errorf("restore %q: %w", m.name, err)
+		}
+	}
+	return nil
+}
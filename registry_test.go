@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRegistry_Report(t *testing.T) {
+	clock := newFakeClock()
+	reg := NewRegistry()
+	limiters := []RateLimiter{
+		NewTokenBucket(5, 1, 5, WithClock(clock), WithRegistry(reg), WithName("api")),
+		NewFixedWindow(1, 3, WithClock(clock), WithRegistry(reg), WithName("login")),
+		NewSlidingWindow(2, time.Second, WithClock(clock), WithRegistry(reg)),
+	}
+	defer func() {
+		for _, rl := range limiters {
+			rl.Stop()
+		}
+	}()
+
+	// 10 requests of 1 token each, every limiter admits up to its capacity and denies the rest
+	for i := 0; i < 10; i++ {
+		for _, rl := range limiters {
+			rl.Allow(1)
+		}
+	}
+
+	want := []Stats{
+		{Name: "api", Type: "token_bucket", Capacity: 5, Tokens: 0, Allowed: 5, Denied: 5},
+		{Name: "login", Type: "fixed_window", Capacity: 3, Tokens: 0, Allowed: 3, Denied: 7},
+		{Type: "sliding_window", Capacity: 2, Tokens: 0, Allowed: 2, Denied: 8},
+	}
+	report := reg.Report()
+	if len(report) != len(want) {
+		t.Fatalf("Report() returned %d stats, want %d", len(report), len(want))
+	}
+	var wantAllowed, wantDenied uint64
+	for i := range want {
+		if report[i] != want[i] {
+			t.Errorf("Report()[%d] = %+v, want %+v", i, report[i], want[i])
+		}
+		wantAllowed += report[i].Allowed
+		wantDenied += report[i].Denied
+	}
+
+	if allowed, denied := reg.Totals(); allowed != wantAllowed || denied != wantDenied {
+		t.Errorf("Totals() = %d, %d, want %d, %d", allowed, denied, wantAllowed, wantDenied)
+	}
+}
+
+func TestRegistry_StopLeaves(t *testing.T) {
+	reg := NewRegistry()
+	first := NewTokenBucket(5, 1, 5, WithRegistry(reg), WithName("first"))
+	second := NewTokenBucket(5, 1, 5, WithRegistry(reg), WithName("second"))
+	defer second.Stop()
+
+	first.Stop()
+	report := reg.Report()
+	if len(report) != 1 || report[0].Name != "second" {
+		t.Errorf("Report() after stopping first = %+v, want only second", report)
+	}
+}
+
+func TestRegistry_SnapshotAll(t *testing.T) {
+	clock := newFakeClock()
+	fleet := func(reg *Registry) []RateLimiter {
+		return []RateLimiter{
+			NewTokenBucket(5, 1, 5, WithClock(clock), WithRegistry(reg), WithName("token")),
+			NewLeakyBucket(5, 1, WithClock(clock), WithRegistry(reg), WithName("leaky")),
+			NewFixedWindow(10, 5, WithClock(clock), WithRegistry(reg), WithName("fixed")),
+			NewSlidingWindow(5, 10*time.Second, WithClock(clock), WithRegistry(reg), WithName("sliding")),
+			NewMinInterval(10*time.Second, WithClock(clock), WithRegistry(reg), WithName("interval")),
+			NewDecayingWindow(5, 10*time.Second, WithClock(clock), WithRegistry(reg), WithName("decaying")),
+		}
+	}
+	stopAll := func(limiters []RateLimiter) {
+		for _, rl := range limiters {
+			rl.Stop()
+		}
+	}
+
+	reg := NewRegistry()
+	old := fleet(reg)
+	// drain the leaky bucket, which starts full, then take 3 tokens from every limiter
+	clock.Advance(5 * time.Second)
+	for _, rl := range old {
+		for i := 0; i < 3; i++ {
+			rl.Allow(1)
+		}
+	}
+	snapshots, err := reg.SnapshotAll()
+	if err != nil {
+		t.Fatalf("SnapshotAll() error = %v", err)
+	}
+	if len(snapshots) != len(old) {
+		t.Errorf("SnapshotAll() returned %d snapshots, want %d", len(snapshots), len(old))
+	}
+
+	restoredReg := NewRegistry()
+	restored := fleet(restoredReg)
+	defer stopAll(restored)
+	fresh := fleet(NewRegistry())
+	defer stopAll(fresh)
+	if err := restoredReg.RestoreAll(snapshots); err != nil {
+		t.Fatalf("RestoreAll() error = %v", err)
+	}
+
+	// the restored fleet behaves like the one snapshotted, not like a fresh one
+	for i, rl := range restored {
+		got, want := rl.(Introspector).Tokens(), old[i].(Introspector).Tokens()
+		if got != want {
+			t.Errorf("%T Tokens() after RestoreAll = %d, want %d", rl, got, want)
+		}
+		if fresh := fresh[i].(Introspector).Tokens(); got == fresh {
+			t.Errorf("%T Tokens() after RestoreAll = %d, same as a fresh limiter", rl, got)
+		}
+		for j := 0; j < 3; j++ {
+			if got, want := rl.Allow(1), old[i].Allow(1); got != want {
+				t.Errorf("%T Allow(1) #%d after RestoreAll = %v, want %v", rl, j, got, want)
+			}
+		}
+	}
+	stopAll(old)
+}
+
+func TestRegistry_RestoreAll_Mismatch(t *testing.T) {
+	clock := newFakeClock()
+	reg := NewRegistry()
+	api := NewTokenBucket(5, 1, 5, WithClock(clock), WithRegistry(reg), WithName("api")).(*TokenBucket)
+	defer api.Stop()
+	login := NewFixedWindow(1, 5, WithClock(clock), WithRegistry(reg), WithName("login")).(*FixedWindow)
+	defer login.Stop()
+
+	drained := NewTokenBucket(5, 1, 0, WithClock(clock))
+	defer drained.Stop()
+	apiSnapshot, err := drained.(*TokenBucket).Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot() error = %v", err)
+	}
+
+	// the token bucket snapshot doesn't fit the fixed window, so neither limiter is restored
+	err = reg.RestoreAll(map[string][]byte{"api": apiSnapshot, "login": apiSnapshot})
+	if !errors.Is(err, ErrSnapshotMismatch) {
+		t.Errorf("RestoreAll() error = %v, want %v", err, ErrSnapshotMismatch)
+	}
+	if got := api.Tokens(); got != 5 {
+		t.Errorf("api Tokens() after a failed RestoreAll = %d, want 5", got)
+	}
+
+	unnamed := NewTokenBucket(5, 1, 5, WithClock(clock), WithRegistry(reg))
+	defer unnamed.Stop()
+	if _, err := reg.SnapshotAll(); !errors.Is(err, ErrInvalidParameter) {
+		t.Errorf("SnapshotAll() with an unnamed member error = %v, want %v", err, ErrInvalidParameter)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// LimitConfig holds the limits ConfigReloader applies to one limiter, fields left out of the file are left alone
+type LimitConfig struct {
+	Capacity *int `json:"capacity"`
+	Rate     *int `json:"rate"`
+}
+
+// ConfigReloader polls a JSON file mapping limiter names to their LimitConfig, such as
+//
+//	{"api": {"capacity": 100, "rate": 10}}
+//
+// and applies the limits to the limiters registered under those names whenever the file changes. The capacity
+// is applied through SetCapacity and the rate through SetRate, or SetLeakRate for a LeakyBucket, limiters
+// without the matching setter are left alone. A file that can't be read or parsed is logged and the previous
+// config stays in effect
+type ConfigReloader struct {
+	path     string
+	mu       sync.Mutex
+	raw      []byte
+	config   map[string]LimitConfig
+	limiters map[string]RateLimiter
+	stopCh   chan struct{}
+	wg       sync.WaitGroup
+	stopOnce sync.Once
+}
+
+// NewConfigReloader loads the config at path and polls it for changes every interval until Stop is called, it
+// fails if the initial config can't be loaded. The file is parsed as JSON whatever its extension, YAML isn't
+// supported
+func NewConfigReloader(path string, interval time.Duration) (*ConfigReloader, error) {
+	r := &ConfigReloader{
+		path:     path,
+		limiters: map[string]RateLimiter{},
+		stopCh:   make(chan struct{}),
+	}
+	if _, err := r.load(); err != nil {
+		return nil, err
+	}
+
+	r.wg.Add(1)
+	go r.poll(interval)
+	return r, nil
+}
+
+// Register applies the current config for name to rl and keeps it up to date with later changes
+func (r *ConfigReloader) Register(name string, rl RateLimiter) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.limiters[name] = rl
+	if cfg, ok := r.config[name]; ok {
+		apply(rl, cfg)
+	}
+}
+
+// Stop stops polling the file, the registered limiters keep running with their current limits
+func (r *ConfigReloader) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.stopCh)
+	})
+	r.wg.Wait()
+}
+
+func (r *ConfigReloader) poll(interval time.Duration) {
+	defer r.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stopCh:
+			return
+		case <-ticker.C:
+			changed, err := r.load()
+			if err != nil {
+				log.Printf("ratelimitters: keeping the previous config: %v", err)
+				continue
+			}
+			if changed {
+				r.applyAll()
+			}
+		}
+	}
+}
+
+// load reads and parses the file, it reports whether its contents changed since the last successful load
+func (r *ConfigReloader) load() (bool, error) {
+	raw, err := os.ReadFile(r.path)
+	if err != nil {
+		return false, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.config != nil && bytes.Equal(raw, r.raw) {
+		return false, nil
+	}
+	var config map[string]LimitConfig
+	if err := json.Unmarshal(raw, &config); err != nil {
+		// remember the broken contents so they're only reported once
+		r.raw = raw
+		return false, fmt.Errorf("parsing %s: %w", r.path, err)
+	}
+	r.raw, r.config = raw, config
+	return true, nil
+}
+
+func (r *ConfigReloader) applyAll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for name, rl := range r.limiters {
+		if cfg, ok := r.config[name]; ok {
+			apply(rl, cfg)
+		}
+	}
+}
+
+// apply sets the limits in cfg on rl through whichever setters it has
+func apply(rl RateLimiter, cfg LimitConfig) {
+	if cfg.Capacity != nil {
+		if s, ok := rl.(interface{ SetCapacity(int) }); ok {
+			s.SetCapacity(*cfg.Capacity)
+		}
+	}
+	if cfg.Rate != nil {
+		switch s := rl.(type) {
+		case interface{ SetRate(int) }:
+			s.SetRate(*cfg.Rate)
+		case interface{ SetLeakRate(int) }:
+			s.SetLeakRate(*cfg.Rate)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// bucketParams reads a TokenBucket's capacity and refill rate per second from its own goroutine
+func bucketParams(rl *TokenBucket) (capacity, rate int) {
+	rl.exec(func() {
+		capacity, rate = rl.capacity, int(int64(rl.refillTokens)*int64(time.Second)/int64(rl.refillPeriod))
+	})
+	return capacity, rate
+}
+
+// waitParams polls until rl has the given capacity and rate
+func waitParams(t *testing.T, rl *TokenBucket, capacity, rate int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		gotCapacity, gotRate := bucketParams(rl)
+		if gotCapacity == capacity && gotRate == rate {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("capacity %d and rate %d, want %d and %d", gotCapacity, gotRate, capacity, rate)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestConfigReloader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "limits.json")
+	write := func(config string) {
+		if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`{"api": {"capacity": 5, "rate": 1}, "jobs": {"rate": 3}}`)
+	interval := 5 * time.Millisecond
+	r, err := NewConfigReloader(path, interval)
+	if err != nil {
+		t.Fatalf("NewConfigReloader() error = %v", err)
+	}
+	defer r.Stop()
+
+	api := NewTokenBucket(10, 10, 10).(*TokenBucket)
+	defer api.Stop()
+	jobs := NewLeakyBucket(10, 10).(*LeakyBucket)
+	defer jobs.Stop()
+	other := NewTokenBucket(10, 10, 10).(*TokenBucket)
+	defer other.Stop()
+	r.Register("api", api)
+	r.Register("jobs", jobs)
+	r.Register("other", other)
+
+	waitParams(t, api, 5, 1)
+	var leakRate int
+	jobs.exec(func() { leakRate = jobs.leakRate })
+	if leakRate != 3 {
+		t.Errorf("jobs leak rate = %d, want 3", leakRate)
+	}
+
+	write(`{"api": {"capacity": 20, "rate": 4}}`)
+	waitParams(t, api, 20, 4)
+
+	// a broken file is logged and the previous limits stay in effect
+	write(`{"api": {"capacity": `)
+	time.Sleep(10 * interval)
+	waitParams(t, api, 20, 4)
+
+	write(`{"api": {"capacity": 8}, "other": {"capacity": 2, "rate": 2}}`)
+	waitParams(t, api, 8, 4)
+	waitParams(t, other, 2, 2)
+}
+
+func TestConfigReloader_Errors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := NewConfigReloader(filepath.Join(dir, "missing.json"), time.Second); err == nil {
+		t.Errorf("NewConfigReloader() on a missing file error = nil, want an error")
+	}
+
+	path := filepath.Join(dir, "broken.json")
+	if err := os.WriteFile(path, []byte("capacity: 5"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewConfigReloader(path, time.Second); err == nil {
+		t.Errorf("NewConfigReloader() on a broken file error = nil, want an error")
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import "time"
+
+// timeRing is a fixed size FIFO of timestamps backed by a circular buffer, so it never allocates after creation
+type timeRing struct {
+	buf   []time.Time
+	head  int
+	count int
+}
+
+func newTimeRing(size int) *timeRing {
+	return &timeRing{buf: make([]time.Time, max(size, 0))}
+}
+
+func (r *timeRing) len() int {
+	return r.count
+}
+
+// at returns the i-th oldest timestamp
+func (r *timeRing) at(i int) time.Time {
+	return r.buf[(r.head+i)%len(r.buf)]
+}
+
+// push appends t as the newest timestamp, the caller has to make sure the ring isn't full
+func (r *timeRing) push(t time.Time) {
+	r.buf[(r.head+r.count)%len(r.buf)] = t
+	r.count++
+}
+
+// pop drops the oldest timestamp
+func (r *timeRing) pop() {
+	r.head = (r.head + 1) % len(r.buf)
+	r.count--
+}
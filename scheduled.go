@@ -0,0 +1,63 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// ScheduledLimiter enforces a limit only while a schedule says so, such as during business hours, and admits every
+// request the rest of the time unless it has a fallback limiter for those hours
+type ScheduledLimiter struct {
+	active   RateLimiter
+	fallback RateLimiter
+	schedule func(time.Time) bool
+	clock    Clock
+	stopped  atomic.Bool
+}
+
+// NewScheduledLimiter creates a ScheduledLimiter asking active whenever schedule reports true for the current
+// time. Of the options only WithClock and WithFallback apply
+func NewScheduledLimiter(active RateLimiter, schedule func(time.Time) bool, opts ...Option) *ScheduledLimiter {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.clock == nil {
+		o.clock = realClock{}
+	}
+	return &ScheduledLimiter{active: active, fallback: o.fallback, schedule: schedule, clock: o.clock}
+}
+
+// AllowE asks the active limiter if the schedule is on, otherwise the fallback limiter or, without one, admits the
+// request. It returns ErrNeverAvailable for requests of zero or fewer tokens and ErrStopped once the limiter has
+// been stopped, whatever the schedule. A limiter without an AllowE method is asked through Allow and its denials
+// are ErrRateLimited
+func (s *ScheduledLimiter) AllowE(tokens int) error {
+	if tokens <= 0 {
+		return ErrNeverAvailable
+	}
+	if s.stopped.Load() {
+		return ErrStopped
+	}
+	if s.schedule(s.clock.Now()) {
+		return allowE(s.active, tokens)
+	}
+	if s.fallback != nil {
+		return allowE(s.fallback, tokens)
+	}
+	return nil
+}
+
+// Allow is AllowE reporting only whether the tokens were admitted
+func (s *ScheduledLimiter) Allow(tokens int) bool {
+	return s.AllowE(tokens) == nil
+}
+
+// Stop stops the active limiter and the fallback, if any, every request is denied from then on
+func (s *ScheduledLimiter) Stop() {
+	s.stopped.Store(true)
+	s.active.Stop()
+	if s.fallback != nil {
+		s.fallback.Stop()
+	}
+}
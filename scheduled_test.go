@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestScheduledLimiter(t *testing.T) {
+	// business hours are 9 to 17, the fake clock starts at midnight
+	businessHours := func(now time.Time) bool {
+		return now.Hour() >= 9 && now.Hour() < 17
+	}
+	tests := []struct {
+		name     string
+		fallback bool
+		at       time.Duration
+		requests int
+		want     int
+	}{
+		{"Request 5 tokens at 3:00, expect all allowed (off hours)", false, 3 * time.Hour, 5, 5},
+		{"Request 5 tokens at 10:00, expect 2 allowed (business hours)", false, 10 * time.Hour, 5, 2},
+		{"Request 5 tokens at 17:00, expect all allowed (off hours)", false, 17 * time.Hour, 5, 5},
+		{"Request 5 tokens at 3:00 with a fallback, expect 1 allowed", true, 3 * time.Hour, 5, 1},
+		{"Request 5 tokens at 10:00 with a fallback, expect 2 allowed", true, 10 * time.Hour, 5, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			opts := []Option{WithClock(clock)}
+			if tt.fallback {
+				opts = append(opts, WithFallback(NewTokenBucket(1, 1, 1, WithClock(clock))))
+			}
+			s := NewScheduledLimiter(NewTokenBucket(2, 1, 2, WithClock(clock)), businessHours, opts...)
+			defer s.Stop()
+
+			clock.Advance(tt.at)
+			allowed := 0
+			for i := 0; i < tt.requests; i++ {
+				if s.Allow(1) {
+					allowed++
+				}
+			}
+			if allowed != tt.want {
+				t.Errorf("allowed %d of %d, want %d", allowed, tt.requests, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduledLimiter_Stopped(t *testing.T) {
+	clock := newFakeClock()
+	// the schedule is never on, so a running limiter admits everything
+	s := NewScheduledLimiter(NewTokenBucket(2, 1, 2, WithClock(clock)), func(time.Time) bool { return false }, WithClock(clock))
+	if err := s.AllowE(1); err != nil {
+		t.Fatalf("AllowE(1) off schedule = %v, want nil", err)
+	}
+
+	s.Stop()
+	if s.Allow(1) {
+		t.Error("Allow(1) after Stop() = true, want false")
+	}
+	if err := s.AllowE(1); !errors.Is(err, ErrStopped) {
+		t.Errorf("AllowE(1) after Stop() = %v, want %v", err, ErrStopped)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// sharedBudgetHalfLife is how quickly a SharedBudget forgets what its children used, it's also how long a child
+// counts as active after its last request and as starved after its last denial
+const sharedBudgetHalfLife = time.Second
+
+// SharedBudget splits the tokens of a total limiter between children by their weights. A child draws from the
+// total as long as nobody else is short of tokens, so an idle child's share isn't wasted, but while another active
+// child is being denied by the total, a child that recently used more than its weighted share of what the active
+// children used is denied until it's back within it
+type SharedBudget struct {
+	mu       sync.Mutex
+	total    RateLimiter
+	clock    Clock
+	children []*budgetChild
+}
+
+// NewSharedBudget creates a SharedBudget drawing from total. Of the options only WithClock applies
+func NewSharedBudget(total RateLimiter, opts ...Option) *SharedBudget {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.clock == nil {
+		o.clock = realClock{}
+	}
+	return &SharedBudget{total: total, clock: o.clock}
+}
+
+// Child returns a new child limiter of the budget with weight, a weight of zero or less counts as 1. Stopping the
+// child takes it out of the budget, stopping the budget stops the total
+func (s *SharedBudget) Child(weight int) RateLimiter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := &budgetChild{shared: s, weight: max(weight, 1), lastTime: s.clock.Now()}
+	s.children = append(s.children, c)
+	return c
+}
+
+// Stop stops the total limiter, every child is denied from then on
+func (s *SharedBudget) Stop() {
+	s.total.Stop()
+}
+
+// budgetChild is a child of a SharedBudget, its fields are guarded by the budget's mutex
+type budgetChild struct {
+	shared *SharedBudget
+	weight int
+	// usage is the tokens admitted, decayed to lastTime
+	usage       float64
+	lastTime    time.Time
+	lastRequest time.Time
+	lastDenied  time.Time
+}
+
+// Allow admits tokens from the total unless the child has to give way to a starved one
+func (c *budgetChild) Allow(tokens int) bool {
+	if tokens <= 0 {
+		return false
+	}
+	s := c.shared
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock.Now()
+	c.lastRequest = now
+	if s.contended(c, now) && s.overShare(c, now) {
+		return false
+	}
+	if !s.total.Allow(tokens) {
+		c.lastDenied = now
+		return false
+	}
+	c.decay(now)
+	c.usage += float64(tokens)
+	return true
+}
+
+// Stop takes the child out of the budget
+func (c *budgetChild) Stop() {
+	s := c.shared
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, other := range s.children {
+		if other == c {
+			s.children = append(s.children[:i], s.children[i+1:]...)
+			return
+		}
+	}
+}
+
+// decay decays the usage to now
+func (c *budgetChild) decay(now time.Time) {
+	c.usage *= math.Exp2(-float64(now.Sub(c.lastTime)) / float64(sharedBudgetHalfLife))
+	c.lastTime = now
+}
+
+// active reports whether the child made a request recently
+func (c *budgetChild) active(now time.Time) bool {
+	return now.Sub(c.lastRequest) < sharedBudgetHalfLife
+}
+
+// contended reports whether another active child was recently denied by the total
+func (s *SharedBudget) contended(c *budgetChild, now time.Time) bool {
+	for _, other := range s.children {
+		if other != c && other.active(now) && !other.lastDenied.IsZero() && now.Sub(other.lastDenied) < sharedBudgetHalfLife {
+			return true
+		}
+	}
+	return false
+}
+
+// overShare reports whether c used more than its weighted share of what the active children used recently
+func (s *SharedBudget) overShare(c *budgetChild, now time.Time) bool {
+	var usage, weight float64
+	for _, other := range s.children {
+		if other.active(now) {
+			other.decay(now)
+			usage += other.usage
+			weight += float64(other.weight)
+		}
+	}
+	return usage > 0 && c.usage/usage > float64(c.weight)/weight
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSharedBudget_Contended(t *testing.T) {
+	clock := newFakeClock()
+	// 100 tokens per second shared by two children weighted 3 to 1
+	budget := NewSharedBudget(NewTokenBucket(10, 100, 10, WithClock(clock)), WithClock(clock))
+	defer budget.Stop()
+	heavy, light := budget.Child(3), budget.Child(1)
+
+	var heavyAdmitted, lightAdmitted int
+	for step := 0; step < 2000; step++ {
+		// both children ask for far more than the total every 10ms
+		for i := 0; i < 5; i++ {
+			if heavy.Allow(1) {
+				heavyAdmitted++
+			}
+			if light.Allow(1) {
+				lightAdmitted++
+			}
+		}
+		clock.Advance(10 * time.Millisecond)
+	}
+
+	if lightAdmitted == 0 {
+		t.Fatalf("light child admitted nothing, heavy child %d", heavyAdmitted)
+	}
+	if ratio := float64(heavyAdmitted) / float64(lightAdmitted); math.Abs(ratio-3) > 0.5 {
+		t.Errorf("admitted %d to %d, a ratio of %.2f, want about 3", heavyAdmitted, lightAdmitted, ratio)
+	}
+}
+
+func TestSharedBudget_Idle(t *testing.T) {
+	clock := newFakeClock()
+	budget := NewSharedBudget(NewTokenBucket(10, 100, 10, WithClock(clock)), WithClock(clock))
+	defer budget.Stop()
+	light, idle := budget.Child(1), budget.Child(3)
+	idle.Allow(1)
+
+	admitted := 0
+	for step := 0; step < 1000; step++ {
+		for i := 0; i < 5; i++ {
+			if light.Allow(1) {
+				admitted++
+			}
+		}
+		clock.Advance(10 * time.Millisecond)
+	}
+
+	// with its sibling idle the light child takes the whole total, far beyond its quarter share
+	if admitted < 990 {
+		t.Errorf("light child admitted %d over 10s of a 100 per second total, want about 1000", admitted)
+	}
+}
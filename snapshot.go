@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrSnapshotMismatch is returned when restoring a snapshot taken from a different algorithm
+var ErrSnapshotMismatch = errors.New("ratelimitters: snapshot of a different algorithm")
+
+// limiterState is the persisted state of a limiter, each algorithm fills in the fields it needs
+type limiterState struct {
+	Algorithm  string      `json:"algorithm"`
+	Tokens     int         `json:"tokens,omitempty"`
+	Count      float64     `json:"count,omitempty"`
+	LastTime   time.Time   `json:"last_time"`
+	TimeStamps []time.Time `json:"timestamps,omitempty"`
+	History    []int       `json:"history,omitempty"`
+	Grace      *graceState `json:"grace,omitempty"`
+}
+
+// graceState is the persisted WithGraceBurst pool of a TokenBucket, left out when it has none
+type graceState struct {
+	Tokens   int       `json:"tokens"`
+	LastTime time.Time `json:"last_time"`
+}
+
+// snapshotter saves and loads an algorithm's state for Snapshot and Restore
+type snapshotter interface {
+	save() limiterState
+	load(s limiterState)
+}
+
+// Snapshot returns the limiter's current state as JSON, so it can be persisted and handed to Restore on a limiter
+// of the same algorithm after a restart. Only the algorithm's state is kept, not its configuration or Stats
+func (rlb *RateLimiterBase) Snapshot() ([]byte, error) {
+	var s limiterState
+	if !rlb.exec(func() { s = rlb.algo.save() }) {
+		return nil, ErrStopped
+	}
+	s.Algorithm = algorithmName(rlb.algo)
+	return json.Marshal(s)
+}
+
+// Restore replaces the limiter's state with one taken by Snapshot, as if the time since had passed with no
+// requests. State beyond the limiter's own configuration, such as more tokens than its capacity, is cut down to
+// it. It returns an error wrapping ErrSnapshotMismatch for a snapshot of another algorithm
+func (rlb *RateLimiterBase) Restore(data []byte) error {
+	s, err := rlb.decodeSnapshot(data)
+	if err != nil {
+		return err
+	}
+	return rlb.restore(s)
+}
+
+// TransferFrom carries other's current state, such as its tokens or level, over into the limiter, so a limiter
+// rebuilt with a new configuration picks up where the old one left off instead of starting over with a burst. It
+// goes through Snapshot and Restore, so the same cut down to the limiter's configuration applies, and it returns
+// an error wrapping ErrSnapshotMismatch if other isn't a limiter of the same algorithm
+func (rlb *RateLimiterBase) TransferFrom(other RateLimiter) error {
+	snap, ok := other.(interface{ Snapshot() ([]byte, error) })
+	if !ok {
+		return fmt.Errorf("%w: %T can't be snapshotted", ErrSnapshotMismatch, other)
+	}
+	data, err := snap.Snapshot()
+	if err != nil {
+		return err
+	}
+	return rlb.Restore(data)
+}
+
+// decodeSnapshot parses a snapshot and checks it was taken from the limiter's algorithm
+func (rlb *RateLimiterBase) decodeSnapshot(data []byte) (limiterState, error) {
+	var s limiterState
+	if err := json.Unmarshal(data, &s); err != nil {
+		return s, err
+	}
+	if want := algorithmName(rlb.algo); s.Algorithm != want {
+		return s, fmt.Errorf("%w: got %q, want %q", ErrSnapshotMismatch, s.Algorithm, want)
+	}
+	return s, nil
+}
+
+func (rlb *RateLimiterBase) restore(s limiterState) error {
+	if !rlb.exec(func() { rlb.algo.load(s) }) {
+		return ErrStopped
+	}
+	return nil
+}
+
+func (rl *TokenBucket) save() limiterState {
+	s := limiterState{Tokens: rl.tokens, LastTime: rl.lastTime}
+	if rl.grace.capacity > 0 {
+		s.Grace = &graceState{Tokens: rl.grace.tokens, LastTime: rl.grace.lastTime}
+	}
+	return s
+}
+
+// load keeps the grace pool as it is if the snapshot has none, so a pool added since starts full
+func (rl *TokenBucket) load(s limiterState) {
+	rl.tokens = min(max(s.Tokens, 0), rl.capacity)
+	rl.lastTime = s.LastTime
+	if s.Grace != nil {
+		rl.grace.tokens = min(max(s.Grace.Tokens, 0), rl.grace.capacity)
+		rl.grace.lastTime = s.Grace.LastTime
+	}
+}
+
+func (rl *LeakyBucket) save() limiterState {
+	return limiterState{Tokens: rl.tokens, LastTime: rl.lastTime}
+}
+
+func (rl *LeakyBucket) load(s limiterState) {
+	rl.tokens = min(max(s.Tokens, 0), rl.capacity)
+	rl.lastTime = s.LastTime
+}
+
+func (rl *FixedWindow) save() limiterState {
+	return limiterState{Tokens: rl.tokens, LastTime: rl.lastTime}
+}
+
+func (rl *FixedWindow) load(s limiterState) {
+	rl.tokens = min(max(s.Tokens, 0), rl.capacity)
+	rl.lastTime = s.LastTime
+}
+
+func (rl *SlidingWindow) save() limiterState {
+	s := limiterState{TimeStamps: make([]time.Time, rl.timeStamps.len())}
+	for i := range s.TimeStamps {
+		s.TimeStamps[i] = rl.timeStamps.at(i)
+	}
+	return s
+}
+
+// load keeps the newest timestamps that fit the limit
+func (rl *SlidingWindow) load(s limiterState) {
+	ring := newTimeRing(len(rl.timeStamps.buf))
+	for _, t := range s.TimeStamps[max(len(s.TimeStamps)-len(ring.buf), 0):] {
+		ring.push(t)
+	}
+	rl.timeStamps = ring
+}
+
+func (rl *MinInterval) save() limiterState {
+	return limiterState{LastTime: rl.lastAllowed}
+}
+
+func (rl *MinInterval) load(s limiterState) {
+	rl.lastAllowed = s.LastTime
+}
+
+func (rl *DecayingWindow) save() limiterState {
+	return limiterState{Count: rl.count, LastTime: rl.lastTime}
+}
+
+func (rl *DecayingWindow) load(s limiterState) {
+	rl.count = max(s.Count, 0)
+	rl.lastTime = s.LastTime
+}
+
+func (rl *BaselineLimiter) save() limiterState {
+	return limiterState{Tokens: rl.current, LastTime: rl.slotStart, History: append([]int(nil), rl.history...)}
+}
+
+func (rl *BaselineLimiter) load(s limiterState) {
+	rl.history = append([]int(nil), s.History[max(len(s.History)-baselineSlots, 0):]...)
+	rl.current = max(s.Tokens, 0)
+	rl.slotStart = s.LastTime
+}
+
+func (rl *EventBucket) save() limiterState {
+	return limiterState{Tokens: rl.tokens}
+}
+
+func (rl *EventBucket) load(s limiterState) {
+	rl.tokens = min(max(s.Tokens, 0), rl.capacity)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTransferFrom(t *testing.T) {
+	clock := newFakeClock()
+	old := NewTokenBucket(10, 1, 10, WithClock(clock))
+	defer old.Stop()
+	for i := 0; i < 5; i++ {
+		old.Allow(1)
+	}
+
+	// the replacement is configured with twice the capacity and rate, but carries over the 5 tokens left
+	replacement := NewTokenBucket(20, 2, 20, WithClock(clock)).(*TokenBucket)
+	defer replacement.Stop()
+	if err := replacement.TransferFrom(old); err != nil {
+		t.Fatalf("TransferFrom() error = %v", err)
+	}
+	if got := replacement.Tokens(); got != 5 {
+		t.Errorf("Tokens() after TransferFrom = %d, want 5", got)
+	}
+	if got := replacement.Capacity(); got != 20 {
+		t.Errorf("Capacity() after TransferFrom = %d, want 20", got)
+	}
+	// from here on it refills at its own rate
+	clock.Advance(time.Second)
+	if got := replacement.Tokens(); got != 7 {
+		t.Errorf("Tokens() 1s after TransferFrom = %d, want 7", got)
+	}
+
+	// a fuller bucket is cut down to the replacement's capacity
+	small := NewTokenBucket(3, 1, 0, WithClock(clock)).(*TokenBucket)
+	defer small.Stop()
+	if err := small.TransferFrom(replacement); err != nil {
+		t.Fatalf("TransferFrom() error = %v", err)
+	}
+	if got := small.Tokens(); got != 3 {
+		t.Errorf("Tokens() after TransferFrom a fuller bucket = %d, want 3", got)
+	}
+}
+
+func TestSnapshot_GraceBurst(t *testing.T) {
+	clock := newFakeClock()
+	old := NewTokenBucket(5, 1, 5, WithClock(clock), WithGraceBurst(10, 10*time.Second)).(*TokenBucket)
+	defer old.Stop()
+	// drain the bucket and then the grace pool
+	for i := 0; i < 3; i++ {
+		if !old.Allow(5) {
+			t.Fatalf("Allow(5) #%d = false, want true", i+1)
+		}
+	}
+	data, err := old.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot() error = %v", err)
+	}
+
+	restored := NewTokenBucket(5, 1, 0, WithClock(clock), WithGraceBurst(10, 10*time.Second)).(*TokenBucket)
+	defer restored.Stop()
+	if err := restored.Restore(data); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	if restored.Allow(1) {
+		t.Error("Allow(1) right after restoring a drained grace pool = true, want false")
+	}
+	// 2s later the bucket has 2 tokens and the pool has regenerated 2 more
+	clock.Advance(2 * time.Second)
+	if restored.Allow(5) {
+		t.Error("Allow(5) 2s after restoring = true, want false")
+	}
+	if !restored.Allow(4) {
+		t.Error("Allow(4) 2s after restoring = false, want true")
+	}
+}
+
+func TestTransferFrom_Mismatch(t *testing.T) {
+	clock := newFakeClock()
+	rl := NewTokenBucket(10, 1, 10, WithClock(clock)).(*TokenBucket)
+	defer rl.Stop()
+
+	window := NewFixedWindow(1, 5, WithClock(clock))
+	defer window.Stop()
+	if err := rl.TransferFrom(window); !errors.Is(err, ErrSnapshotMismatch) {
+		t.Errorf("TransferFrom(FixedWindow) error = %v, want %v", err, ErrSnapshotMismatch)
+	}
+	if err := rl.TransferFrom(stubLimiter{allow: true}); !errors.Is(err, ErrSnapshotMismatch) {
+		t.Errorf("TransferFrom(stubLimiter) error = %v, want %v", err, ErrSnapshotMismatch)
+	}
+	if got := rl.Tokens(); got != 10 {
+		t.Errorf("Tokens() after a failed TransferFrom = %d, want 10", got)
+	}
+
+	stopped := NewTokenBucket(10, 1, 0, WithClock(clock))
+	stopped.Stop()
+	if err := rl.TransferFrom(stopped); err != ErrStopped {
+		t.Errorf("TransferFrom(stopped) error = %v, want %v", err, ErrStopped)
+	}
+}
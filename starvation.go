@@ -0,0 +1,75 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// starvation tracks the request WithMaxStarvation protects, it's only touched from the limiter's goroutine
+type starvation struct {
+	// tokens is the size of the largest request denied since it was last admitted
+	tokens int
+	// denials counts the consecutive denials of requests for tokens
+	denials int
+	// lastSeen is when a request for tokens last arrived, the reservation lapses a refill period after it
+	lastSeen time.Time
+}
+
+// starved reports whether a request for tokens has to give way to a starved request of a different size, which
+// holds the next available tokens once it has been denied maxStarvation consecutive times. The reservation lapses
+// once a refill period has passed without the starved size arriving, so a caller that gave up doesn't lock
+// everyone else out
+func (rlb *RateLimiterBase) starved(currentTime time.Time, tokens int) bool {
+	s := &rlb.starvation
+	if rlb.maxStarvation <= 0 || s.denials < rlb.maxStarvation || tokens == s.tokens {
+		return false
+	}
+	if currentTime.Sub(s.lastSeen) > rlb.refillPeriod() {
+		*s = starvation{}
+		return false
+	}
+	return true
+}
+
+// trackStarvation counts a decision on a request for tokens towards WithMaxStarvation. A request smaller than the
+// one being tracked doesn't interrupt its run of denials, so a stream of small requests can't hide a large one.
+// A request that could never be admitted isn't tracked, as reserving the tokens for it would starve everyone else
+func (rlb *RateLimiterBase) trackStarvation(currentTime time.Time, tokens int, allowed bool) {
+	if rlb.maxStarvation <= 0 {
+		return
+	}
+	s := &rlb.starvation
+	switch {
+	case tokens == s.tokens && allowed:
+		*s = starvation{}
+	case tokens == s.tokens:
+		s.denials++
+		s.lastSeen = currentTime
+	case !allowed && (tokens > s.tokens || s.denials == 0) && rlb.admissible(tokens):
+		*s = starvation{tokens: tokens, denials: 1, lastSeen: currentTime}
+	}
+}
+
+// admissible reports whether a request for tokens can ever be admitted once scaled, an admitter decides for itself
+// and any other algorithm by its capacity once the request is clamped
+func (rlb *RateLimiterBase) admissible(tokens int) bool {
+	if a, ok := rlb.algo.(admitter); ok {
+		return a.admits(rlb.cost(tokens))
+	}
+	capacity := rlb.algo.maxTokens()
+	return rlb.clamp(rlb.cost(tokens), capacity) <= capacity
+}
+
+// refillPeriod is how long the limiter takes to free up its whole capacity at its sustained rate, 0 for a limiter
+// that never refills or refills at once
+func (rlb *RateLimiterBase) refillPeriod() time.Duration {
+	rate := rlb.algo.sustainedRate()
+	if !(rate > 0) || math.IsInf(rate, 1) {
+		return 0
+	}
+	period := float64(rlb.algo.maxTokens()) / rate * float64(time.Second)
+	if period >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return time.Duration(period)
+}
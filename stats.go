@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a snapshot of a limiter's state and of the requests it has seen
+type Stats struct {
+	// Name is the name given with WithName, if any
+	Name string `json:"name"`
+	// Type is the limiter's algorithm under the name New knows it by, such as "token_bucket"
+	Type     string `json:"type"`
+	Capacity int    `json:"capacity"`
+	Tokens   int    `json:"tokens"`
+	Allowed  uint64 `json:"allowed"`
+	Denied   uint64 `json:"denied"`
+}
+
+// counters tallies admission decisions, it's safe to read from any goroutine, even after Stop
+type counters struct {
+	allowed atomic.Uint64
+	denied  atomic.Uint64
+	// flushedAllowed and flushedDenied are the counts last handed to the WithBatchedMetrics hook, only touched by
+	// flushMetrics
+	flushedAllowed uint64
+	flushedDenied  uint64
+}
+
+// record counts an admission decision towards Stats and passes it through
+func (c *counters) record(allowed bool) bool {
+	if allowed {
+		c.allowed.Add(1)
+	} else {
+		c.denied.Add(1)
+	}
+	return allowed
+}
+
+// record counts an admission decision towards Stats, the WithMetricsHook hook and the WithDenialSink sink and
+// passes it through
+func (rlb *RateLimiterBase) record(allowed bool) bool {
+	return rlb.recordTagged(allowed, nil)
+}
+
+// recordTagged is record passing tags to the metrics hook
+func (rlb *RateLimiterBase) recordTagged(allowed bool, tags map[string]string) bool {
+	rlb.counters.record(allowed)
+	if rlb.metricsHook != nil {
+		rlb.metricsHook(allowed, tags)
+	}
+	if !allowed && rlb.denialSink != nil && !rlb.denialSink.Allow(1) && rlb.onSinkExhausted != nil {
+		rlb.onSinkExhausted()
+	}
+	return allowed
+}
+
+// batchMetrics calls flushMetrics every WithBatchedMetrics interval until ctx is done, Stop makes the last call
+// once no more decisions can be made
+func (rlb *RateLimiterBase) batchMetrics(ctx context.Context) {
+	defer rlb.wg.Done()
+	ticker := time.NewTicker(rlb.metricsFlush)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			rlb.flushMetrics()
+		}
+	}
+}
+
+// flushMetrics hands the WithBatchedMetrics hook the decisions counted since its last call, if there were any
+func (rlb *RateLimiterBase) flushMetrics() {
+	allowed, denied := rlb.counters.allowed.Load(), rlb.counters.denied.Load()
+	if allowed == rlb.flushedAllowed && denied == rlb.flushedDenied {
+		return
+	}
+	rlb.batchedMetrics(allowed-rlb.flushedAllowed, denied-rlb.flushedDenied)
+	rlb.flushedAllowed, rlb.flushedDenied = allowed, denied
+}
+
+// Stats returns the limiter's capacity, the tokens it could admit right now and how many requests it has
+// admitted and denied so far. Invalid requests for zero or fewer tokens aren't counted, and a stopped limiter
+// reports no capacity or tokens but keeps its counts
+func (rlb *RateLimiterBase) Stats() Stats {
+	s := Stats{Name: rlb.name, Type: algorithmName(rlb.algo)}
+	rlb.exec(func() {
+		s.Capacity = rlb.algo.maxTokens()
+		s.Tokens = rlb.algo.available(rlb.now())
+	})
+	s.Allowed = rlb.counters.allowed.Load()
+	s.Denied = rlb.counters.denied.Load()
+	return s
+}
+
+// Utilization returns the share of the capacity in use, from 0 when every token could be admitted to 1 when
+// none could, whatever Tokens holds beyond the capacity. A limiter without capacity, or a stopped one, is reported
+// as fully utilized
+func (s Stats) Utilization() float64 {
+	if s.Capacity <= 0 {
+		return 1
+	}
+	return min(max(1-float64(s.Tokens)/float64(s.Capacity), 0), 1)
+}
+
+// StatsJSON returns the limiter's Stats along with their utilization as a JSON document with the fields name,
+// type, capacity, tokens, allowed, denied and utilization, ready to be served from an admin endpoint
+func (rlb *RateLimiterBase) StatsJSON() ([]byte, error) {
+	s := rlb.Stats()
+	return json.Marshal(struct {
+		Stats
+		Utilization float64 `json:"utilization"`
+	}{s, s.Utilization()})
+}
+
+// algorithmName returns the name New knows algo by
+func algorithmName(algo algorithm) string {
+	switch algo.(type) {
+	case *TokenBucket:
+		return "token_bucket"
+	case *LeakyBucket:
+		return "leaky_bucket"
+	case *FixedWindow:
+		return "fixed_window"
+	case *SlidingWindow:
+		return "sliding_window"
+	case *MinInterval:
+		return "min_interval"
+	case *DecayingWindow:
+		return "decaying_window"
+	case *BaselineLimiter:
+		return "baseline"
+	case *EventBucket:
+		return "event_bucket"
+	}
+	return ""
+}
+
+// PublishExpvar exposes the limiter's Stats as JSON under name on the expvar page, which is served at
+// /debug/vars once expvar is linked in. Like expvar.Publish it panics if name is already taken
+func (rlb *RateLimiterBase) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() any {
+		return rlb.Stats()
+	}))
+}
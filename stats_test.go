@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	clock := newFakeClock()
+	rl := NewTokenBucket(10, 5, 10, WithClock(clock)).(*TokenBucket)
+
+	rl.Allow(4)                       // allowed, 6 left
+	rl.Allow(7)                       // denied
+	rl.Allow(0)                       // invalid, not counted
+	rl.AllowPriority(2, PriorityHigh) // allowed, 4 left
+	rl.AllowDetailed(5)               // denied
+	clock.Advance(time.Second)
+	rl.Allow(9) // allowed after refilling 5 tokens, 0 left
+
+	want := Stats{Type: "token_bucket", Capacity: 10, Tokens: 0, Allowed: 3, Denied: 2}
+	if got := rl.Stats(); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+
+	rl.Stop()
+	want = Stats{Type: "token_bucket", Allowed: 3, Denied: 2}
+	if got := rl.Stats(); got != want {
+		t.Errorf("Stats() after Stop() = %+v, want %+v", got, want)
+	}
+}
+
+func TestStats_GraceBurst(t *testing.T) {
+	// the grace pool on top of a full bucket isn't counted towards its tokens
+	rl := NewTokenBucket(10, 1, 10, WithClock(newFakeClock()), WithGraceBurst(5, time.Hour)).(*TokenBucket)
+	defer rl.Stop()
+
+	want := Stats{Type: "token_bucket", Capacity: 10, Tokens: 10}
+	if got := rl.Stats(); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+	if got := rl.Stats().Utilization(); got != 0 {
+		t.Errorf("Utilization() = %v, want 0", got)
+	}
+}
+
+func TestStats_Utilization(t *testing.T) {
+	tests := []struct {
+		name  string
+		stats Stats
+		want  float64
+	}{
+		{"Stats with 3 of 4 tokens, expect 0.25", Stats{Capacity: 4, Tokens: 3}, 0.25},
+		{"Stats with more tokens than capacity, expect 0", Stats{Capacity: 10, Tokens: 15}, 0},
+		{"Stats with negative tokens, expect 1", Stats{Capacity: 10, Tokens: -5}, 1},
+		{"Stats without capacity, expect 1", Stats{}, 1},
+	}
+	for _, tt := range tests {
+		if got := tt.stats.Utilization(); got != tt.want {
+			t.Errorf("%s: Utilization() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPublishExpvar(t *testing.T) {
+	rl := NewSlidingWindow(5, time.Minute, WithClock(newFakeClock())).(*SlidingWindow)
+	defer rl.Stop()
+
+	name := "ratelimitters_test_sliding_window"
+	rl.PublishExpvar(name)
+	for i := 0; i < 4; i++ {
+		rl.Allow(2)
+	}
+
+	v := expvar.Get(name)
+	if v == nil {
+		t.Fatalf("expvar.Get(%q) = nil, want the published limiter", name)
+	}
+	var got map[string]any
+	if err := json.Unmarshal([]byte(v.String()), &got); err != nil {
+		t.Fatalf("expvar %q isn't JSON: %v", name, err)
+	}
+	want := map[string]float64{"capacity": 5, "tokens": 1, "allowed": 2, "denied": 2}
+	for field, value := range want {
+		if got[field] != value {
+			t.Errorf("expvar %q field %q = %v, want %v", name, field, got[field], value)
+		}
+	}
+}
+
+func TestStatsJSON(t *testing.T) {
+	clock := newFakeClock()
+	rl := NewLeakyBucket(8, 2, WithClock(clock), WithName("uploads")).(*LeakyBucket)
+	defer rl.Stop()
+
+	clock.Advance(2 * time.Second) // 4 tokens leak out
+	rl.Allow(1)                    // allowed, 3 left
+	rl.Allow(4)                    // denied
+	rl.Allow(1)                    // allowed, 2 left
+
+	data, err := rl.StatsJSON()
+	if err != nil {
+		t.Fatalf("StatsJSON() error = %v", err)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("StatsJSON() returned invalid JSON %s: %v", data, err)
+	}
+	want := map[string]any{
+		"name":        "uploads",
+		"type":        "leaky_bucket",
+		"capacity":    8.0,
+		"tokens":      2.0,
+		"allowed":     2.0,
+		"denied":      1.0,
+		"utilization": 0.75,
+	}
+	if len(got) != len(want) {
+		t.Errorf("StatsJSON() = %s, want exactly the fields %v", data, want)
+	}
+	for field, value := range want {
+		if got[field] != value {
+			t.Errorf("StatsJSON() field %q = %v, want %v", field, got[field], value)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import "time"
+
+// DrainUntilDenied calls rl.Allow(step) until a call is denied and returns how many were admitted, which makes it
+// easy for tests to assert the capacity a limiter is configured with. It's meant for tests: it takes every token
+// it can, and it never returns for a limiter that never denies or for a step of zero or less, which it returns 0
+// for straight away
+func DrainUntilDenied(rl RateLimiter, step int) int {
+	if step <= 0 {
+		return 0
+	}
+	admitted := 0
+	for rl.Allow(step) {
+		admitted++
+	}
+	return admitted
+}
+
+// WaitUntilAvailable polls rl every millisecond until it could admit tokens right away and reports whether it got
+// there within timeout. It doesn't take the tokens from an Introspector, any other limiter is asked through Allow
+// and so has them taken once they're available. It's meant for tests, where polling works with injected clocks
+// that Wait can't sleep on
+func WaitUntilAvailable(rl RateLimiter, tokens int, timeout time.Duration) bool {
+	available := func() bool { return rl.Allow(tokens) }
+	if in, ok := rl.(Introspector); ok {
+		available = func() bool { return tokens > 0 && in.Tokens() >= tokens }
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		if available() {
+			return true
+		}
+		if !time.Now().Before(deadline) {
+			return false
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
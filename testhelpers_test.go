@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDrainUntilDenied(t *testing.T) {
+	tests := []struct {
+		name  string
+		newRL func(clock Clock) RateLimiter
+		step  int
+		want  int
+	}{
+		{"TokenBucket of 10 in steps of 1, expect 10", func(c Clock) RateLimiter { return NewTokenBucket(10, 1, 10, WithClock(c)) }, 1, 10},
+		{"TokenBucket of 10 in steps of 3, expect 3", func(c Clock) RateLimiter { return NewTokenBucket(10, 1, 10, WithClock(c)) }, 3, 3},
+		{"LeakyBucket of 10 once drained, expect 10", func(c Clock) RateLimiter { return NewLeakyBucket(10, 10, WithClock(c)) }, 1, 10},
+		{"FixedWindow of 5, expect 5", func(c Clock) RateLimiter { return NewFixedWindow(1, 5, WithClock(c)) }, 1, 5},
+		{"SlidingWindow of 5 in steps of 2, expect 2", func(c Clock) RateLimiter { return NewSlidingWindow(5, time.Second, WithClock(c)) }, 2, 2},
+		{"MinInterval, expect 1", func(c Clock) RateLimiter { return NewMinInterval(time.Second, WithClock(c)) }, 1, 1},
+		{"DecayingWindow of 7, expect 7", func(c Clock) RateLimiter { return NewDecayingWindow(7, time.Second, WithClock(c)) }, 1, 7},
+		{"TokenBucket in steps of 0, expect 0", func(c Clock) RateLimiter { return NewTokenBucket(10, 1, 10, WithClock(c)) }, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			rl := tt.newRL(clock)
+			defer rl.Stop()
+			// the leaky bucket starts full
+			clock.Advance(10 * time.Second)
+
+			if got := DrainUntilDenied(rl, tt.step); got != tt.want {
+				t.Errorf("DrainUntilDenied(%d) = %d, want %d", tt.step, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWaitUntilAvailable(t *testing.T) {
+	tests := []struct {
+		name    string
+		newRL   func() RateLimiter
+		tokens  int
+		timeout time.Duration
+		want    bool
+	}{
+		{"TokenBucket refilling in time, expect available", func() RateLimiter { return NewTokenBucketPerDuration(1, 20*time.Millisecond, 1) }, 1, time.Second, true},
+		{"LeakyBucket leaking in time, expect available", func() RateLimiter { return NewLeakyBucket(1, 1) }, 1, 2 * time.Second, true},
+		{"FixedWindow rolling over in time, expect available", func() RateLimiter { return NewFixedWindowDuration(20*time.Millisecond, 1) }, 1, time.Second, true},
+		{"SlidingWindow expiring in time, expect available", func() RateLimiter { return NewSlidingWindow(1, 20*time.Millisecond) }, 1, time.Second, true},
+		{"MinInterval ending in time, expect available", func() RateLimiter { return NewMinInterval(20 * time.Millisecond) }, 1, time.Second, true},
+		{"DecayingWindow decaying in time, expect available", func() RateLimiter { return NewDecayingWindow(1, 10*time.Millisecond) }, 1, time.Second, true},
+		{"TokenBucket refilling too slowly, expect timeout", func() RateLimiter { return NewTokenBucketPerDuration(1, time.Hour, 1) }, 1, 20 * time.Millisecond, false},
+		{"Non-introspector that never admits, expect timeout", func() RateLimiter { return stubLimiter{allow: false} }, 1, 20 * time.Millisecond, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl := tt.newRL()
+			defer rl.Stop()
+			DrainUntilDenied(rl, 1)
+
+			if got := WaitUntilAvailable(rl, tt.tokens, tt.timeout); got != tt.want {
+				t.Errorf("WaitUntilAvailable(%d, %v) = %v, want %v", tt.tokens, tt.timeout, got, tt.want)
+			}
+		})
+	}
+}
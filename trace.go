@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// Tracer starts the spans of WithTracer. It's the part of OpenTelemetry's trace.Tracer this package needs, so it
+// stays free of dependencies while an OpenTelemetry tracer takes only a small adapter to plug in
+type Tracer interface {
+	Start(ctx context.Context, spanName string) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer
+type Span interface {
+	SetAttribute(key string, value any)
+	End()
+}
+
+// waitTrace is the span of a single Wait, started only once the caller actually has to block
+type waitTrace struct {
+	tracer Tracer
+	ctx    context.Context
+	start  time.Time
+	span   Span
+}
+
+// block starts the span the first time the caller blocks, it does nothing without a tracer
+func (t *waitTrace) block() {
+	if t.tracer == nil || t.span != nil {
+		return
+	}
+	_, t.span = t.tracer.Start(t.ctx, "ratelimitters.Wait")
+}
+
+// end records how long the caller waited for tokens and whether they were admitted, then ends the span if the
+// caller ever blocked
+func (t *waitTrace) end(now time.Time, tokens int, err error) {
+	if t.span == nil {
+		return
+	}
+	t.span.SetAttribute("ratelimitters.wait.tokens", tokens)
+	t.span.SetAttribute("ratelimitters.wait.duration", now.Sub(t.start))
+	t.span.SetAttribute("ratelimitters.wait.admitted", err == nil)
+	t.span.End()
+}
+
+// closed reports whether ch has been closed, without blocking
+func closed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	ErrStopped        = errors.New("ratelimitters: limiter stopped")
+	ErrNeverAvailable = errors.New("ratelimitters: request can never be admitted")
+)
+
+// waiter is a caller blocked in Wait, turn is closed once it reaches the head of the queue
+type waiter struct {
+	turn chan struct{}
+}
+
+// Wait blocks until tokens are admitted, ctx is done or the limiter is stopped. Concurrent callers are served in
+// the order they called Wait: only the caller at the head of the queue is admitted, so a large request isn't
+// starved by smaller ones arriving after it. Requests that can never be admitted fail with ErrNeverAvailable
+// straight away, and a request reaching the head of the queue while the circuit breaker is open fails with
+// ErrCircuitOpen
+func (rlb *RateLimiterBase) Wait(ctx context.Context, tokens int) error {
+	if tokens <= 0 {
+		return ErrNeverAvailable
+	}
+
+	if rlb.dryRun {
+		// a dry run never blocks, it only records what admitting the tokens right away would have decided
+		if !rlb.exec(func() { rlb.admit(rlb.now(), tokens, nil) }) {
+			return ErrStopped
+		}
+		return nil
+	}
+
+	t := waitTrace{tracer: rlb.tracer, ctx: ctx, start: rlb.clock.Now()}
+	err := rlb.wait(ctx, tokens, &t)
+	t.end(rlb.clock.Now(), tokens, err)
+	return err
+}
+
+// wait is Wait for a valid request, calling t.block before blocking on the queue or the refill
+func (rlb *RateLimiterBase) wait(ctx context.Context, tokens int, t *waitTrace) error {
+	w := &waiter{turn: make(chan struct{})}
+	if !rlb.exec(func() { rlb.enqueue(w) }) {
+		return ErrStopped
+	}
+	defer rlb.exec(func() { rlb.dequeue(w) })
+
+	if !closed(w.turn) {
+		t.block()
+	}
+	select {
+	case <-w.turn:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-rlb.ctx.Done():
+		return ErrStopped
+	}
+
+	first := true
+	for {
+		var err error
+		var next time.Time
+		ok := rlb.exec(func() {
+			currentTime := rlb.now()
+			if first {
+				// the attempted rate counts the request once, however many attempts it takes
+				rlb.trackOverload(currentTime, tokens)
+				first = false
+			}
+			// only the final decision is counted, the attempts made while waiting aren't denials
+			switch err = rlb.verdict(currentTime, tokens, rlb.algo.allow); err {
+			case nil, ErrCircuitOpen:
+				rlb.settle(currentTime, tokens, nil, err)
+				rlb.mirror(tokens, err == nil)
+			default:
+				next = rlb.retryAt(currentTime, tokens)
+			}
+		})
+		switch {
+		case !ok:
+			return ErrStopped
+		case err == nil:
+			rlb.latencyMu.Lock()
+			rlb.latency.observe(rlb.clock.Now().Sub(t.start))
+			rlb.latencyMu.Unlock()
+			return nil
+		case err == ErrCircuitOpen:
+			return err
+		case next.IsZero():
+			return ErrNeverAvailable
+		}
+
+		t.block()
+		timer := time.NewTimer(next.Sub(rlb.clock.Now()))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-rlb.ctx.Done():
+			timer.Stop()
+			return ErrStopped
+		}
+	}
+}
+
+// retryAt returns when a waiting request for tokens that was just denied is worth trying again: the end of the
+// WithPenalty lockout, the lapse of a WithMaxStarvation reservation held for another size or the refill of its
+// tokens, the zero time if they never refill
+func (rlb *RateLimiterBase) retryAt(currentTime time.Time, tokens int) time.Time {
+	switch {
+	case rlb.locked(currentTime):
+		return rlb.penalty.until
+	case rlb.starved(currentTime, tokens):
+		// the starved request takes its tokens first, or gives up and lets the reservation lapse
+		lapse := rlb.starvation.lastSeen.Add(rlb.refillPeriod() + time.Nanosecond)
+		next := rlb.algo.nextAvailable(currentTime, saturatingAdd(rlb.starvation.tokens, rlb.cost(tokens)))
+		if next.After(currentTime) && next.Before(lapse) {
+			return next
+		}
+		return lapse
+	}
+	return rlb.algo.nextAvailable(currentTime, rlb.cost(tokens))
+}
+
+// Do waits for tokens like Wait and then runs fn, returning fn's error. If the tokens are never admitted fn doesn't
+// run and Do returns Wait's error instead, the context's error if ctx is done first
+func (rlb *RateLimiterBase) Do(ctx context.Context, tokens int, fn func() error) error {
+	if err := rlb.Wait(ctx, tokens); err != nil {
+		return err
+	}
+	return fn()
+}
+
+// enqueue appends w to the waiters, handing it the turn straight away if nobody is ahead of it
+func (rlb *RateLimiterBase) enqueue(w *waiter) {
+	if len(rlb.waiters) == 0 {
+		close(w.turn)
+	}
+	rlb.waiters = append(rlb.waiters, w)
+}
+
+// dequeue removes w from the waiters once it's admitted or gives up, passing the turn on if it was at the head
+func (rlb *RateLimiterBase) dequeue(w *waiter) {
+	for i, other := range rlb.waiters {
+		if other != w {
+			continue
+		}
+		rlb.waiters = append(rlb.waiters[:i], rlb.waiters[i+1:]...)
+		if i == 0 && len(rlb.waiters) > 0 {
+			close(rlb.waiters[0].turn)
+		}
+		return
+	}
+}
+
+// Waiters returns how many callers are blocked in Wait or Do right now, a sign of saturation when it keeps
+// growing. A stopped limiter has no waiters
+func (rlb *RateLimiterBase) Waiters() int {
+	n := 0
+	rlb.exec(func() {
+		n = len(rlb.waiters)
+	})
+	return n
+}
+
+// WaitLatency returns a histogram of how long the callers admitted by Wait were blocked, callers admitted
+// straight away count as not having waited at all
+func (rlb *RateLimiterBase) WaitLatency() Histogram {
+	rlb.latencyMu.Lock()
+	defer rlb.latencyMu.Unlock()
+	return rlb.latency
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitQueued polls until n callers are blocked in Wait on rl
+func waitQueued(t *testing.T, rl *TokenBucket, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		queued := rl.Waiters()
+		if queued == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d callers queued in Wait, want %d", queued, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestTokenBucket_Wait_FIFO(t *testing.T) {
+	clock := newFakeClock()
+	// an empty bucket accruing one token every 10 milliseconds, frozen until the clock is advanced
+	rl := NewTokenBucket(1, 100, 0, WithClock(clock)).(*TokenBucket)
+	defer rl.Stop()
+
+	var mu sync.Mutex
+	var order []int
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := rl.Wait(context.Background(), 1); err != nil {
+				t.Errorf("waiter %d: Wait(1) = %v, want nil", i, err)
+				return
+			}
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+		}()
+		// only start the next caller once this one is queued so the arrival order is known
+		waitQueued(t, rl, i+1)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for stop := false; !stop; {
+		clock.Advance(10 * time.Millisecond)
+		select {
+		case <-done:
+			stop = true
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+
+	for i, got := range order {
+		if got != i {
+			t.Fatalf("admission order %v, want arrival order", order)
+		}
+	}
+	if len(order) != 8 {
+		t.Fatalf("admitted %d waiters, want 8", len(order))
+	}
+}
+
+func TestTokenBucket_Wait_Cancel(t *testing.T) {
+	clock := newFakeClock()
+	rl := NewTokenBucket(10, 100, 0, WithClock(clock)).(*TokenBucket)
+	defer rl.Stop()
+
+	// the head of the queue needs the whole bucket, the caller behind it gives up
+	headErr := make(chan error, 1)
+	go func() { headErr <- rl.Wait(context.Background(), 10) }()
+	waitQueued(t, rl, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	behindErr := make(chan error, 1)
+	go func() { behindErr <- rl.Wait(ctx, 1) }()
+	waitQueued(t, rl, 2)
+
+	cancel()
+	if err := <-behindErr; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Wait after cancel = %v, want %v", err, context.Canceled)
+	}
+	waitQueued(t, rl, 1)
+
+	clock.Advance(100 * time.Millisecond)
+	if err := <-headErr; err != nil {
+		t.Fatalf("Wait(10) = %v, want nil", err)
+	}
+	waitQueued(t, rl, 0)
+}
+
+func TestTokenBucket_Wait_Errors(t *testing.T) {
+	rl := NewTokenBucket(10, 1, 0, WithClock(newFakeClock())).(*TokenBucket)
+
+	for _, tokens := range []int{0, -1, 11} {
+		if err := rl.Wait(context.Background(), tokens); !errors.Is(err, ErrNeverAvailable) {
+			t.Errorf("Wait(%d) = %v, want %v", tokens, err, ErrNeverAvailable)
+		}
+	}
+
+	errs := make(chan error, 1)
+	go func() { errs <- rl.Wait(context.Background(), 5) }()
+	waitQueued(t, rl, 1)
+	rl.Stop()
+	if err := <-errs; !errors.Is(err, ErrStopped) {
+		t.Errorf("Wait during Stop = %v, want %v", err, ErrStopped)
+	}
+	if err := rl.Wait(context.Background(), 1); !errors.Is(err, ErrStopped) {
+		t.Errorf("Wait after Stop = %v, want %v", err, ErrStopped)
+	}
+}
+
+func TestWaitLatency(t *testing.T) {
+	clock := newFakeClock()
+	// a token accrues every millisecond, frozen until the clock is advanced
+	rl := NewTokenBucket(1, 1000, 1, WithClock(clock)).(*TokenBucket)
+	defer rl.Stop()
+
+	for i := 0; i < 100; i++ {
+		if i%10 != 9 {
+			// a token is waiting in the bucket, Wait returns straight away
+			if err := rl.Wait(context.Background(), 1); err != nil {
+				t.Fatalf("Wait(1) = %v, want nil", err)
+			}
+			clock.Advance(time.Millisecond)
+			continue
+		}
+
+		// drain the bucket so the next caller is blocked until 40ms have passed on the clock
+		rl.Allow(1)
+		errs := make(chan error, 1)
+		go func() { errs <- rl.Wait(context.Background(), 1) }()
+		waitQueued(t, rl, 1)
+		clock.Advance(40 * time.Millisecond)
+		if err := <-errs; err != nil {
+			t.Fatalf("Wait(1) = %v, want nil", err)
+		}
+		clock.Advance(time.Millisecond)
+	}
+
+	h := rl.WaitLatency()
+	if got := h.Count(); got != 100 {
+		t.Errorf("Count() = %d, want 100", got)
+	}
+	if got := h.Percentile(50); got > time.Millisecond {
+		t.Errorf("Percentile(50) = %v, want at most 1ms", got)
+	}
+	if got := h.Percentile(99); got < 40*time.Millisecond || got > 80*time.Millisecond {
+		t.Errorf("Percentile(99) = %v, want between 40ms and 80ms", got)
+	}
+}
+
+func TestDo(t *testing.T) {
+	errBoom := errors.New("boom")
+	rl := NewTokenBucket(10, 1, 5, WithClock(newFakeClock())).(*TokenBucket)
+	defer rl.Stop()
+
+	tests := []struct {
+		name    string
+		tokens  int
+		timeout time.Duration
+		fnErr   error
+		wantRun bool
+		wantErr error
+	}{
+		{"Request 3 of 5 tokens, expect fn run", 3, time.Second, nil, true, nil},
+		{"Request 2 of 2 tokens with a failing fn, expect its error", 2, time.Second, errBoom, true, errBoom},
+		{"Request 1 token from the empty bucket, expect the deadline before admission and fn not run", 1, 20 * time.Millisecond, nil, false, context.DeadlineExceeded},
+		{"Request 11 tokens, expect ErrNeverAvailable and fn not run", 11, time.Second, nil, false, ErrNeverAvailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+
+			ran := false
+			err := rl.Do(ctx, tt.tokens, func() error {
+				ran = true
+				return tt.fnErr
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Do(%d) = %v, want %v", tt.tokens, err, tt.wantErr)
+			}
+			if ran != tt.wantRun {
+				t.Errorf("fn ran = %v, want %v", ran, tt.wantRun)
+			}
+		})
+	}
+}
+
+func TestWaiters(t *testing.T) {
+	tests := []struct {
+		name  string
+		newRL func(clock Clock) RateLimiter
+	}{
+		{"TokenBucket", func(clock Clock) RateLimiter { return NewTokenBucket(10, 1, 0, WithClock(clock)) }},
+		{"LeakyBucket", func(clock Clock) RateLimiter { return NewLeakyBucket(10, 1, WithClock(clock)) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl := tt.newRL(newFakeClock())
+			waiting := rl.(interface {
+				Wait(context.Context, int) error
+				Waiters() int
+			})
+			if got := waiting.Waiters(); got != 0 {
+				t.Fatalf("Waiters() = %d before any Wait, want 0", got)
+			}
+
+			// the clock never moves, so every caller stays blocked until Stop
+			var wg sync.WaitGroup
+			for i := 0; i < 5; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					waiting.Wait(context.Background(), 1)
+				}()
+			}
+			deadline := time.Now().Add(time.Second)
+			for waiting.Waiters() != 5 && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+			if got := waiting.Waiters(); got != 5 {
+				t.Fatalf("Waiters() = %d with 5 callers blocked, want 5", got)
+			}
+
+			rl.Stop()
+			wg.Wait()
+			if got := waiting.Waiters(); got != 0 {
+				t.Errorf("Waiters() after Stop() = %d, want 0", got)
+			}
+		})
+	}
+}
+
+// fakeTracer records the spans it starts
+type fakeTracer struct {
+	mu    sync.Mutex
+	spans []*fakeSpan
+}
+
+func (f *fakeTracer) Start(ctx context.Context, spanName string) (context.Context, Span) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	span := &fakeSpan{name: spanName, attrs: map[string]any{}}
+	f.spans = append(f.spans, span)
+	return ctx, span
+}
+
+type fakeSpan struct {
+	name  string
+	attrs map[string]any
+	ended bool
+}
+
+func (s *fakeSpan) SetAttribute(key string, value any) { s.attrs[key] = value }
+
+func (s *fakeSpan) End() { s.ended = true }
+
+func TestWithTracer(t *testing.T) {
+	clock := newFakeClock()
+	tracer := &fakeTracer{}
+	// a token accrues every millisecond, frozen until the clock is advanced
+	rl := NewTokenBucket(1, 1000, 1, WithClock(clock), WithTracer(tracer)).(*TokenBucket)
+	defer rl.Stop()
+
+	// a token is waiting in the bucket, the caller doesn't block and gets no span
+	if err := rl.Wait(context.Background(), 1); err != nil {
+		t.Fatalf("Wait(1) = %v, want nil", err)
+	}
+	if got := len(tracer.spans); got != 0 {
+		t.Fatalf("%d spans after an unblocked Wait, want 0", got)
+	}
+
+	errs := make(chan error, 1)
+	go func() { errs <- rl.Wait(context.Background(), 1) }()
+	waitQueued(t, rl, 1)
+	clock.Advance(40 * time.Millisecond)
+	if err := <-errs; err != nil {
+		t.Fatalf("Wait(1) = %v, want nil", err)
+	}
+
+	tracer.mu.Lock()
+	defer tracer.mu.Unlock()
+	if got := len(tracer.spans); got != 1 {
+		t.Fatalf("%d spans after a blocked Wait, want 1", got)
+	}
+	span := tracer.spans[0]
+	if span.name != "ratelimitters.Wait" || !span.ended {
+		t.Errorf("span %q ended %v, want %q ended", span.name, span.ended, "ratelimitters.Wait")
+	}
+	want := map[string]any{
+		"ratelimitters.wait.tokens":   1,
+		"ratelimitters.wait.duration": 40 * time.Millisecond,
+		"ratelimitters.wait.admitted": true,
+	}
+	for key, value := range want {
+		if got := span.attrs[key]; got != value {
+			t.Errorf("attribute %s = %v, want %v", key, got, value)
+		}
+	}
+}
+
+func TestWithTracer_Cancelled(t *testing.T) {
+	tracer := &fakeTracer{}
+	rl := NewTokenBucket(1, 1, 0, WithClock(newFakeClock()), WithTracer(tracer)).(*TokenBucket)
+	defer rl.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := rl.Wait(ctx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait(1) = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	tracer.mu.Lock()
+	defer tracer.mu.Unlock()
+	if got := len(tracer.spans); got != 1 {
+		t.Fatalf("%d spans after a cancelled Wait, want 1", got)
+	}
+	if got := tracer.spans[0].attrs["ratelimitters.wait.admitted"]; got != false {
+		t.Errorf("attribute ratelimitters.wait.admitted = %v, want false", got)
+	}
+}
+
+func TestLeakyBucket_StopReleasesWaiters(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{"Stop with waiters blocked, expect every one released with ErrStopped", nil},
+		{"Stop with waiters blocked and no goroutine, expect every one released with ErrStopped", []Option{WithoutGoroutine()}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := runtime.NumGoroutine()
+			// a full bucket frozen by the fake clock, so every waiter blocks until Stop
+			rl := NewLeakyBucket(5, 1, append(tt.opts, WithClock(newFakeClock()))...).(*LeakyBucket)
+
+			const numWaiters = 8
+			errs := make(chan error, numWaiters)
+			for i := 0; i < numWaiters; i++ {
+				go func() { errs <- rl.Wait(context.Background(), 1) }()
+			}
+			deadline := time.Now().Add(time.Second)
+			for rl.Waiters() != numWaiters {
+				if time.Now().After(deadline) {
+					t.Fatalf("%d callers queued in Wait, want %d", rl.Waiters(), numWaiters)
+				}
+				time.Sleep(time.Millisecond)
+			}
+
+			rl.Stop()
+			for i := 0; i < numWaiters; i++ {
+				if err := <-errs; !errors.Is(err, ErrStopped) {
+					t.Errorf("Wait(1) after Stop = %v, want %v", err, ErrStopped)
+				}
+			}
+
+			// the limiter's goroutine and every waiter are gone
+			for runtime.NumGoroutine() > before {
+				if time.Now().After(deadline) {
+					t.Fatalf("%d goroutines after Stop, want at most %d", runtime.NumGoroutine(), before)
+				}
+				time.Sleep(time.Millisecond)
+			}
+		})
+	}
+}